@@ -0,0 +1,204 @@
+package content
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+type BreakerState string
+
+const (
+	BreakerClosed   BreakerState = "closed"
+	BreakerOpen     BreakerState = "open"
+	BreakerHalfOpen BreakerState = "half-open"
+)
+
+// BreakerPollInterval is how often the callers held by an open breaker check whether they can go on
+const BreakerPollInterval = time.Second
+
+// CircuitBreaker stops the calls to an upstream after FailureThreshold consecutive failures.
+// Once OpenTimeout has passed a single probe call is let through, which closes the breaker on success
+// or opens it again on failure. A zero FailureThreshold disables the breaker
+type CircuitBreaker struct {
+	sync.Mutex
+	Name             string
+	FailureThreshold int
+	OpenTimeout      time.Duration
+	state            BreakerState
+	failures         int
+	openedAt         time.Time
+	probing          bool
+}
+
+func NewCircuitBreaker(name string, failureThreshold int, openTimeout time.Duration) *CircuitBreaker {
+	return &CircuitBreaker{
+		Name:             name,
+		FailureThreshold: failureThreshold,
+		OpenTimeout:      openTimeout,
+		state:            BreakerClosed,
+	}
+}
+
+// State reports an open breaker whose timeout has passed as half-open, as it lets the next call through
+func (b *CircuitBreaker) State() BreakerState {
+	b.Lock()
+	defer b.Unlock()
+	if b.state == BreakerOpen && time.Since(b.openedAt) >= b.OpenTimeout {
+		return BreakerHalfOpen
+	}
+	return b.state
+}
+
+// OpenedAt returns when the breaker opened last
+func (b *CircuitBreaker) OpenedAt() time.Time {
+	b.Lock()
+	defer b.Unlock()
+	return b.openedAt
+}
+
+// Wait blocks while the breaker is open or another probe call is in flight
+func (b *CircuitBreaker) Wait(ctx context.Context) error {
+	for !b.allow() {
+		select {
+		case <-time.After(BreakerPollInterval):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return nil
+}
+
+func (b *CircuitBreaker) allow() bool {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case BreakerOpen:
+		if time.Since(b.openedAt) < b.OpenTimeout {
+			return false
+		}
+		b.state = BreakerHalfOpen
+		b.probing = true
+		return true
+	case BreakerHalfOpen:
+		if b.probing {
+			return false
+		}
+		b.probing = true
+		return true
+	}
+	return true
+}
+
+func (b *CircuitBreaker) Success() {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		b.failures = 0
+	case BreakerHalfOpen:
+		log.Infof("Circuit breaker of %v closed", b.Name)
+		b.state = BreakerClosed
+		b.failures = 0
+		b.probing = false
+	}
+}
+
+func (b *CircuitBreaker) Failure() {
+	b.Lock()
+	defer b.Unlock()
+	switch b.state {
+	case BreakerClosed:
+		b.failures++
+		if b.FailureThreshold <= 0 || b.failures < b.FailureThreshold {
+			return
+		}
+		log.Warnf("Circuit breaker of %v opened after %v consecutive failures", b.Name, b.failures)
+	case BreakerHalfOpen:
+		log.Warnf("Circuit breaker of %v opened again, probe call failed", b.Name)
+	default:
+		return
+	}
+	b.state = BreakerOpen
+	b.openedAt = time.Now()
+	b.probing = false
+}
+
+// Cancel releases a call aborted before the upstream answered, so another probe can be made
+func (b *CircuitBreaker) Cancel() {
+	b.Lock()
+	defer b.Unlock()
+	if b.state == BreakerHalfOpen {
+		b.probing = false
+	}
+}
+
+// OpenBreakers returns the names of the breakers currently holding their calls back
+func OpenBreakers(breakers []*CircuitBreaker) []string {
+	var open []string
+	for _, b := range breakers {
+		if b.State() == BreakerOpen {
+			open = append(open, b.Name)
+		}
+	}
+	return open
+}
+
+// BreakingTransport holds the requests while the breaker is open and reports transport errors
+// and server errors to the breaker as failures
+type BreakingTransport struct {
+	Transport http.RoundTripper
+	Breaker   *CircuitBreaker
+}
+
+func (t *BreakingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Breaker.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.Transport.RoundTrip(req)
+	switch {
+	// Timeouts of the call itself, as set by a TimeoutTransport, leave the context of the request untouched and count as failures
+	case err != nil && req.Context().Err() != nil:
+		t.Breaker.Cancel()
+	case err != nil || resp.StatusCode >= http.StatusInternalServerError:
+		t.Breaker.Failure()
+	default:
+		t.Breaker.Success()
+	}
+	return resp, err
+}
+
+// TimeoutTransport bounds each call made through it, including the reading of the response body.
+// Placed under the BreakingTransport, the time a call is held by an open breaker does not count, unlike http.Client.Timeout
+type TimeoutTransport struct {
+	Transport http.RoundTripper
+	Timeout   time.Duration
+}
+
+func (t *TimeoutTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if t.Timeout <= 0 {
+		return t.Transport.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), t.Timeout)
+	resp, err := t.Transport.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	defer c.cancel()
+	return c.ReadCloser.Close()
+}
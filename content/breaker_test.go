@@ -0,0 +1,137 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestCircuitBreakerOpensAfterConsecutiveFailures(t *testing.T) {
+	breaker := NewCircuitBreaker("s3Writer", 3, time.Minute)
+
+	breaker.Failure()
+	breaker.Failure()
+	breaker.Success()
+	breaker.Failure()
+	breaker.Failure()
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	breaker.Failure()
+	assert.Equal(t, BreakerOpen, breaker.State())
+	assert.Equal(t, []string{"s3Writer"}, OpenBreakers([]*CircuitBreaker{breaker}))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, breaker.Wait(ctx))
+}
+
+func TestCircuitBreakerLetsSingleProbeThroughAfterTimeout(t *testing.T) {
+	breaker := NewCircuitBreaker("s3Writer", 1, 0)
+	breaker.Failure()
+	assert.Equal(t, BreakerHalfOpen, breaker.State())
+	assert.Empty(t, OpenBreakers([]*CircuitBreaker{breaker}))
+
+	assert.NoError(t, breaker.Wait(context.Background()))
+	assert.False(t, breaker.allow())
+
+	breaker.Cancel()
+	assert.True(t, breaker.allow())
+
+	breaker.Success()
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestCircuitBreakerOpensAgainWhenProbeFails(t *testing.T) {
+	breaker := NewCircuitBreaker("s3Writer", 1, 0)
+	breaker.Failure()
+	assert.True(t, breaker.allow())
+
+	breaker.OpenTimeout = time.Minute
+	breaker.Failure()
+	assert.Equal(t, BreakerOpen, breaker.State())
+}
+
+func TestCircuitBreakerDisabled(t *testing.T) {
+	breaker := NewCircuitBreaker("s3Writer", 0, time.Minute)
+	for i := 0; i < 100; i++ {
+		breaker.Failure()
+	}
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestBreakingTransportCountsServerErrors(t *testing.T) {
+	status := http.StatusInternalServerError
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker("s3Writer", 2, time.Minute)
+	client := &http.Client{Transport: &BreakingTransport{Transport: http.DefaultTransport, Breaker: breaker}}
+
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	status = http.StatusNotFound
+	resp, err = client.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, BreakerClosed, breaker.State())
+
+	status = http.StatusBadGateway
+	for i := 0; i < 2; i++ {
+		resp, err = client.Get(server.URL)
+		assert.NoError(t, err)
+		resp.Body.Close()
+	}
+	assert.Equal(t, BreakerOpen, breaker.State())
+}
+
+func TestCallHeldByOpenBreakerLongerThanTimeoutSucceeds(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker("s3Writer", 1, 600*time.Millisecond)
+	breaker.Failure()
+	require.Equal(t, BreakerOpen, breaker.State())
+	client := &http.Client{Transport: &BreakingTransport{
+		Transport: &TimeoutTransport{Transport: http.DefaultTransport, Timeout: 200 * time.Millisecond},
+		Breaker:   breaker,
+	}}
+	updater := &S3Updater{Client: client, S3WriterBaseURL: server.URL}
+
+	start := time.Now()
+	err := updater.Upload(context.Background(), []byte("uuid1"), "tid_1234", "uuid1", "2017-10-09")
+	assert.NoError(t, err)
+	assert.True(t, time.Since(start) > 200*time.Millisecond)
+	assert.Equal(t, BreakerClosed, breaker.State())
+}
+
+func TestTimeoutTransportFailsSlowCalls(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-time.After(time.Second):
+		case <-r.Context().Done():
+		}
+	}))
+	defer server.Close()
+
+	breaker := NewCircuitBreaker("s3Writer", 1, time.Minute)
+	client := &http.Client{Transport: &BreakingTransport{
+		Transport: &TimeoutTransport{Transport: http.DefaultTransport, Timeout: 50 * time.Millisecond},
+		Breaker:   breaker,
+	}}
+	req, err := http.NewRequest(http.MethodGet, server.URL, nil)
+	require.NoError(t, err)
+
+	_, err = client.Do(req)
+	assert.Error(t, err)
+	assert.Equal(t, BreakerOpen, breaker.State())
+}
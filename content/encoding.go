@@ -0,0 +1,84 @@
+package content
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"sync"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+type Encoding string
+
+const (
+	IdentityEncoding Encoding = "identity"
+	GzipEncoding     Encoding = "gzip"
+	ZstdEncoding     Encoding = "zstd"
+)
+
+// ParseEncoding maps a configured encoding name to an Encoding, an empty name meaning no compression
+func ParseEncoding(name string) (Encoding, error) {
+	switch Encoding(name) {
+	case "", IdentityEncoding:
+		return IdentityEncoding, nil
+	case GzipEncoding, ZstdEncoding:
+		return Encoding(name), nil
+	}
+	return "", fmt.Errorf("Unsupported content encoding: %v", name)
+}
+
+// zstdEncoder is shared by every upload, EncodeAll being safe for concurrent use
+var zstdEncoder, zstdEncoderErr = zstd.NewWriter(nil)
+
+var gzipWriters = sync.Pool{
+	New: func() interface{} {
+		return gzip.NewWriter(nil)
+	},
+}
+
+// Encode compresses the payload according to the encoding
+func (e Encoding) Encode(payload []byte) ([]byte, error) {
+	switch e {
+	case "", IdentityEncoding:
+		return payload, nil
+	case GzipEncoding:
+		buf := new(bytes.Buffer)
+		w := gzipWriters.Get().(*gzip.Writer)
+		defer gzipWriters.Put(w)
+		w.Reset(buf)
+		if _, err := w.Write(payload); err != nil {
+			return nil, err
+		}
+		if err := w.Close(); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	case ZstdEncoding:
+		if zstdEncoderErr != nil {
+			return nil, zstdEncoderErr
+		}
+		return zstdEncoder.EncodeAll(payload, nil), nil
+	}
+	return nil, fmt.Errorf("Unsupported content encoding: %v", e)
+}
+
+// IsIdentity tells whether the payload is left uncompressed
+func (e Encoding) IsIdentity() bool {
+	return e == "" || e == IdentityEncoding
+}
+
+// EncodingUpdater is a sink compressing the uploaded payloads, whose encoding can be chosen per export
+type EncodingUpdater interface {
+	Updater
+	ContentEncoding() Encoding
+	WithEncoding(e Encoding) Updater
+}
+
+// UpdaterEncoding returns the encoding the updater applies to uploaded payloads
+func UpdaterEncoding(u Updater) Encoding {
+	if eu, ok := u.(EncodingUpdater); ok {
+		return eu.ContentEncoding()
+	}
+	return IdentityEncoding
+}
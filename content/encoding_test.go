@@ -0,0 +1,63 @@
+package content
+
+import (
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"testing"
+
+	"github.com/klauspost/compress/zstd"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEncoding(t *testing.T) {
+	e, err := ParseEncoding("")
+	assert.NoError(t, err)
+	assert.Equal(t, IdentityEncoding, e)
+
+	e, err = ParseEncoding("zstd")
+	assert.NoError(t, err)
+	assert.Equal(t, ZstdEncoding, e)
+
+	_, err = ParseEncoding("brotli")
+	assert.Error(t, err)
+	assert.Equal(t, "Unsupported content encoding: brotli", err.Error())
+}
+
+func TestIdentityEncodingLeavesPayloadUntouched(t *testing.T) {
+	payload := []byte(`{"uuid":"uuid1"}`)
+	encoded, err := IdentityEncoding.Encode(payload)
+	assert.NoError(t, err)
+	assert.Equal(t, payload, encoded)
+}
+
+func TestGzipEncoding(t *testing.T) {
+	payload := []byte(`{"uuid":"uuid1"}`)
+	encoded, err := GzipEncoding.Encode(payload)
+	require.NoError(t, err)
+
+	r, err := gzip.NewReader(bytes.NewReader(encoded))
+	require.NoError(t, err)
+	decoded, err := ioutil.ReadAll(r)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+}
+
+func TestZstdEncoding(t *testing.T) {
+	payload := []byte(`{"uuid":"uuid1"}`)
+	encoded, err := ZstdEncoding.Encode(payload)
+	require.NoError(t, err)
+
+	r, err := zstd.NewReader(nil)
+	require.NoError(t, err)
+	defer r.Close()
+	decoded, err := r.DecodeAll(encoded, nil)
+	require.NoError(t, err)
+	assert.Equal(t, payload, decoded)
+}
+
+func TestUpdaterEncodingDefaultsToIdentity(t *testing.T) {
+	assert.Equal(t, IdentityEncoding, UpdaterEncoding(&mockUpdater{}))
+	assert.Equal(t, IdentityEncoding, UpdaterEncoding(&S3Updater{}))
+}
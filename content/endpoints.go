@@ -0,0 +1,127 @@
+package content
+
+import (
+	"fmt"
+	"math/rand"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultEndpointCooldown = 30 * time.Second
+
+// Endpoint is one of the read endpoints serving the same content, e.g. a region or a Varnish in front of it
+type Endpoint struct {
+	BaseURL   string
+	HealthURL string
+	Weight    int
+}
+
+// EndpointPool spreads the calls over healthy endpoints by weight and fails over to the others.
+// An endpoint which failed is avoided for the cooldown period, unless all the endpoints are failing
+type EndpointPool struct {
+	sync.RWMutex
+	endpoints      []*Endpoint
+	unhealthyUntil map[*Endpoint]time.Time
+	Cooldown       time.Duration
+}
+
+func NewEndpointPool(endpoints ...*Endpoint) *EndpointPool {
+	return &EndpointPool{
+		endpoints:      endpoints,
+		unhealthyUntil: make(map[*Endpoint]time.Time),
+		Cooldown:       defaultEndpointCooldown,
+	}
+}
+
+// ParseEndpoints pairs the comma separated base and health URLs with their weights.
+// Health URLs default to the base URL followed by /__gtg and weights default to 1
+func ParseEndpoints(baseURLs, healthURLs, weights string) ([]*Endpoint, error) {
+	bases := splitList(baseURLs)
+	healths := splitList(healthURLs)
+	ws := splitList(weights)
+	if len(bases) == 0 {
+		return nil, fmt.Errorf("No endpoint configured")
+	}
+	if len(healths) != 0 && len(healths) != len(bases) {
+		return nil, fmt.Errorf("%v health URLs configured for %v endpoints", len(healths), len(bases))
+	}
+	if len(ws) != 0 && len(ws) != len(bases) {
+		return nil, fmt.Errorf("%v weights configured for %v endpoints", len(ws), len(bases))
+	}
+	var endpoints []*Endpoint
+	for i, base := range bases {
+		e := &Endpoint{BaseURL: base, HealthURL: base + "/__gtg", Weight: 1}
+		if len(healths) != 0 {
+			e.HealthURL = healths[i]
+		}
+		if len(ws) != 0 {
+			if _, err := fmt.Sscanf(ws[i], "%d", &e.Weight); err != nil || e.Weight < 0 {
+				return nil, fmt.Errorf("Invalid weight %v for endpoint %v", ws[i], base)
+			}
+		}
+		endpoints = append(endpoints, e)
+	}
+	return endpoints, nil
+}
+
+func splitList(list string) []string {
+	var items []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (p *EndpointPool) Endpoints() []*Endpoint {
+	return p.endpoints
+}
+
+// Order returns the endpoints in the order they should be tried: a healthy endpoint picked by weight,
+// followed by the other healthy endpoints and finally the unhealthy ones
+func (p *EndpointPool) Order() []*Endpoint {
+	p.RLock()
+	defer p.RUnlock()
+	now := time.Now()
+	var healthy, unhealthy []*Endpoint
+	totalWeight := 0
+	for _, e := range p.endpoints {
+		if now.Before(p.unhealthyUntil[e]) {
+			unhealthy = append(unhealthy, e)
+			continue
+		}
+		healthy = append(healthy, e)
+		totalWeight += e.Weight
+	}
+	if totalWeight > 0 {
+		pick := rand.Intn(totalWeight)
+		for i, e := range healthy {
+			if pick < e.Weight {
+				healthy[0], healthy[i] = healthy[i], healthy[0]
+				break
+			}
+			pick -= e.Weight
+		}
+	}
+	return append(healthy, unhealthy...)
+}
+
+func (p *EndpointPool) MarkFailed(e *Endpoint) {
+	p.Lock()
+	defer p.Unlock()
+	p.unhealthyUntil[e] = time.Now().Add(p.Cooldown)
+}
+
+func (p *EndpointPool) MarkHealthy(e *Endpoint) {
+	p.Lock()
+	defer p.Unlock()
+	delete(p.unhealthyUntil, e)
+}
+
+func (p *EndpointPool) IsHealthy(e *Endpoint) bool {
+	p.RLock()
+	defer p.RUnlock()
+	return !time.Now().Before(p.unhealthyUntil[e])
+}
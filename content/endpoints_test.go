@@ -0,0 +1,123 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseEndpoints(t *testing.T) {
+	endpoints, err := ParseEndpoints("http://region-a, http://region-b", "", "3,1")
+	require.NoError(t, err)
+	require.Len(t, endpoints, 2)
+	assert.Equal(t, &Endpoint{BaseURL: "http://region-a", HealthURL: "http://region-a/__gtg", Weight: 3}, endpoints[0])
+	assert.Equal(t, &Endpoint{BaseURL: "http://region-b", HealthURL: "http://region-b/__gtg", Weight: 1}, endpoints[1])
+
+	endpoints, err = ParseEndpoints("http://region-a", "http://region-a/__health", "")
+	require.NoError(t, err)
+	assert.Equal(t, &Endpoint{BaseURL: "http://region-a", HealthURL: "http://region-a/__health", Weight: 1}, endpoints[0])
+}
+
+func TestParseEndpointsErrors(t *testing.T) {
+	_, err := ParseEndpoints("", "", "")
+	assert.EqualError(t, err, "No endpoint configured")
+
+	_, err = ParseEndpoints("http://region-a,http://region-b", "http://region-a/__gtg", "")
+	assert.EqualError(t, err, "1 health URLs configured for 2 endpoints")
+
+	_, err = ParseEndpoints("http://region-a,http://region-b", "", "1")
+	assert.EqualError(t, err, "1 weights configured for 2 endpoints")
+
+	_, err = ParseEndpoints("http://region-a", "", "heavy")
+	assert.EqualError(t, err, "Invalid weight heavy for endpoint http://region-a")
+}
+
+func TestEndpointPoolOrderPutsUnhealthyEndpointsLast(t *testing.T) {
+	a := &Endpoint{BaseURL: "a", Weight: 1}
+	b := &Endpoint{BaseURL: "b", Weight: 1}
+	pool := NewEndpointPool(a, b)
+
+	pool.MarkFailed(a)
+	assert.False(t, pool.IsHealthy(a))
+	assert.Equal(t, []*Endpoint{b, a}, pool.Order())
+
+	pool.MarkHealthy(a)
+	assert.True(t, pool.IsHealthy(a))
+	assert.Len(t, pool.Order(), 2)
+}
+
+func TestEndpointPoolOrderPicksByWeight(t *testing.T) {
+	a := &Endpoint{BaseURL: "a", Weight: 0}
+	b := &Endpoint{BaseURL: "b", Weight: 1}
+	pool := NewEndpointPool(a, b)
+
+	for i := 0; i < 10; i++ {
+		assert.Equal(t, b, pool.Order()[0])
+	}
+}
+
+func TestEnrichedContentFetcherFailsOverToNextEndpoint(t *testing.T) {
+	failing := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer failing.Close()
+	working := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("content"))
+	}))
+	defer working.Close()
+
+	failingEndpoint := &Endpoint{BaseURL: failing.URL, Weight: 1}
+	pool := NewEndpointPool(failingEndpoint, &Endpoint{BaseURL: working.URL, Weight: 0})
+	fetcher := &EnrichedContentFetcher{Client: &http.Client{}, Endpoints: pool}
+
+	payload, err := fetcher.GetContent(context.Background(), "uuid1", "tid_1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "content", string(payload))
+	assert.False(t, pool.IsHealthy(failingEndpoint))
+}
+
+func TestEnrichedContentFetcherDoesNotFailOverOnClientError(t *testing.T) {
+	notFound := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer notFound.Close()
+	calledOther := false
+	other := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calledOther = true
+	}))
+	defer other.Close()
+
+	notFoundEndpoint := &Endpoint{BaseURL: notFound.URL, Weight: 1}
+	pool := NewEndpointPool(notFoundEndpoint, &Endpoint{BaseURL: other.URL, Weight: 0})
+	fetcher := &EnrichedContentFetcher{Client: &http.Client{}, Endpoints: pool}
+
+	_, err := fetcher.GetContent(context.Background(), "uuid1", "tid_1234")
+	assert.Equal(t, ErrContentNotFound, err)
+	assert.False(t, calledOther)
+	assert.True(t, pool.IsHealthy(notFoundEndpoint))
+}
+
+func TestEnrichedContentFetcherCheckHealthWithOneHealthyEndpoint(t *testing.T) {
+	unhealthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer unhealthy.Close()
+	healthy := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer healthy.Close()
+
+	unhealthyEndpoint := &Endpoint{HealthURL: unhealthy.URL}
+	pool := NewEndpointPool(unhealthyEndpoint, &Endpoint{HealthURL: healthy.URL})
+	fetcher := &EnrichedContentFetcher{Endpoints: pool}
+
+	msg, err := fetcher.CheckHealth(&http.Client{})
+	assert.NoError(t, err)
+	assert.Equal(t, "EnrichedContent fetcher is good to go.", msg)
+
+	_, err = fetcher.CheckEndpointHealth(&http.Client{}, unhealthyEndpoint)
+	assert.EqualError(t, err, "GTG HTTP status code is 503")
+	assert.False(t, pool.IsHealthy(unhealthyEndpoint))
+}
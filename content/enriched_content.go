@@ -1,9 +1,13 @@
 package content
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
+
+	log "github.com/sirupsen/logrus"
 )
 
 type Client interface {
@@ -12,22 +16,105 @@ type Client interface {
 
 const enrichedContentPath = "/enrichedcontent/"
 
+// ErrForbidden is returned when the content is not accessible with the configured credentials and policies
+var ErrForbidden = errors.New("Access to content is forbidden. Skipping")
+
+// ErrContentNotFound is returned when the content does not exist in the source
+var ErrContentNotFound = errors.New("Content not found")
+
+// IsInaccessible tells whether the error means the content cannot be exported at all, as opposed to a failure worth retrying
+func IsInaccessible(err error) bool {
+	return errors.Is(err, ErrForbidden) || errors.Is(err, ErrContentNotFound)
+}
+
 type Fetcher interface {
-	GetContent(uuid, tid string) ([]byte, error)
+	GetContent(ctx context.Context, uuid, tid string) ([]byte, error)
+}
+
+// BatchFetcher gets several contents at once, where the upstream supports it.
+// Besides the payloads it returns the errors of the individual contents that could not be fetched
+type BatchFetcher interface {
+	Fetcher
+	GetContents(ctx context.Context, uuids []string, tid string) (map[string][]byte, map[string]error, error)
+}
+
+// FetchContents gets the contents in a batch if the fetcher supports it, falling back to fetching them one by one otherwise
+func FetchContents(ctx context.Context, f Fetcher, uuids []string, tid string) (map[string][]byte, map[string]error) {
+	payloads := make(map[string][]byte)
+	errs := make(map[string]error)
+	if bf, ok := f.(BatchFetcher); ok && len(uuids) > 1 {
+		batchPayloads, batchErrs, err := bf.GetContents(ctx, uuids, tid)
+		if err != nil {
+			for _, uuid := range uuids {
+				errs[uuid] = err
+			}
+			return payloads, errs
+		}
+		return batchPayloads, batchErrs
+	}
+	for _, uuid := range uuids {
+		payload, err := f.GetContent(ctx, uuid, tid)
+		if err != nil {
+			errs[uuid] = err
+			continue
+		}
+		payloads[uuid] = payload
+	}
+	return payloads, errs
+}
+
+// PolicyFetcher is a fetcher whose upstream honours X-Policy headers, so the expansions can be chosen per export
+type PolicyFetcher interface {
+	Fetcher
+	WithXPolicies(xPolicies string) Fetcher
+}
+
+// ParseXPolicies splits comma separated X-Policy values
+func ParseXPolicies(xPolicies string) []string {
+	return splitList(xPolicies)
 }
 
 type EnrichedContentFetcher struct {
-	Client                   Client
-	EnrichedContentBaseURL   string
-	EnrichedContentHealthURL string
-	XPolicyHeaderValues      string
-	Authorization            string
+	Client              Client
+	Endpoints           *EndpointPool
+	XPolicyHeaderValues string
+	Authorization       string
+}
+
+// Scope tells the payloads depend on the X-Policy values sent
+func (e *EnrichedContentFetcher) Scope() string {
+	return EnrichedSource + " " + e.XPolicyHeaderValues
+}
+
+// WithXPolicies returns a copy of the fetcher sending the X-Policy values instead of the configured ones.
+// The copy shares the endpoint pool, so endpoint failures are seen by both
+func (e *EnrichedContentFetcher) WithXPolicies(xPolicies string) Fetcher {
+	fetcher := *e
+	fetcher.XPolicyHeaderValues = xPolicies
+	return &fetcher
+}
+
+// GetContent calls the endpoints in the order given by the pool, failing over to the next one
+// when an endpoint is unreachable or returns a server error
+func (e *EnrichedContentFetcher) GetContent(ctx context.Context, uuid, tid string) ([]byte, error) {
+	var err error
+	for _, endpoint := range e.Endpoints.Order() {
+		var payload []byte
+		var failover bool
+		payload, failover, err = e.getContentFrom(ctx, endpoint, uuid, tid)
+		if !failover {
+			return payload, err
+		}
+		e.Endpoints.MarkFailed(endpoint)
+		log.WithField("transaction_id", tid).WithField("uuid", uuid).WithError(err).Warnf("EnrichedContent endpoint %v failed", endpoint.BaseURL)
+	}
+	return nil, err
 }
 
-func (e *EnrichedContentFetcher) GetContent(uuid, tid string) ([]byte, error) {
-	req, err := http.NewRequest("GET", e.EnrichedContentBaseURL+enrichedContentPath+uuid, nil)
+func (e *EnrichedContentFetcher) getContentFrom(ctx context.Context, endpoint *Endpoint, uuid, tid string) ([]byte, bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", endpoint.BaseURL+enrichedContentPath+uuid, nil)
 	if err != nil {
-		return nil, err
+		return nil, false, err
 	}
 	req.Header.Add("User-Agent", "UPP Content Exporter")
 	req.Header.Add("Accept", "application/json")
@@ -42,22 +129,38 @@ func (e *EnrichedContentFetcher) GetContent(uuid, tid string) ([]byte, error) {
 
 	resp, err := e.Client.Do(req)
 	if err != nil {
-		return nil, err
+		return nil, ctx.Err() == nil, err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
-		if resp.StatusCode == http.StatusForbidden {
-			return nil, fmt.Errorf("Access to content is forbidden. Skipping")
+		switch resp.StatusCode {
+		case http.StatusForbidden:
+			return nil, false, ErrForbidden
+		case http.StatusNotFound:
+			return nil, false, ErrContentNotFound
 		}
-		return nil, fmt.Errorf("EnrichedContent returned HTTP %v", resp.StatusCode)
+		failover := resp.StatusCode >= http.StatusInternalServerError || resp.StatusCode == http.StatusTooManyRequests
+		return nil, failover, fmt.Errorf("EnrichedContent returned HTTP %v", resp.StatusCode)
 	}
 
-	return ioutil.ReadAll(resp.Body)
+	payload, err := ioutil.ReadAll(resp.Body)
+	return payload, err != nil && ctx.Err() == nil, err
+}
+
+// CheckHealth reports the fetcher good to go if any of its endpoints is
+func (e *EnrichedContentFetcher) CheckHealth(client Client) (msg string, err error) {
+	for _, endpoint := range e.Endpoints.Endpoints() {
+		msg, err = e.CheckEndpointHealth(client, endpoint)
+		if err == nil {
+			return
+		}
+	}
+	return
 }
 
-func (e *EnrichedContentFetcher) CheckHealth(client Client) (string, error) {
-	req, err := http.NewRequest("GET", e.EnrichedContentHealthURL, nil)
+func (e *EnrichedContentFetcher) CheckEndpointHealth(client Client, endpoint *Endpoint) (string, error) {
+	req, err := http.NewRequest("GET", endpoint.HealthURL, nil)
 	if err != nil {
 		return "Error in building request to check if the enrichedContent fetcher is good to go", err
 	}
@@ -67,11 +170,14 @@ func (e *EnrichedContentFetcher) CheckHealth(client Client) (string, error) {
 	}
 	resp, err := client.Do(req)
 	if err != nil {
+		e.Endpoints.MarkFailed(endpoint)
 		return "Error in getting request to check if the enrichedContent fetcher is good to go", err
 	}
 	defer resp.Body.Close()
 	if resp.StatusCode != http.StatusOK {
+		e.Endpoints.MarkFailed(endpoint)
 		return "EnrichedContent fetcher is not good to go.", fmt.Errorf("GTG HTTP status code is %v", resp.StatusCode)
 	}
+	e.Endpoints.MarkHealthy(endpoint)
 	return "EnrichedContent fetcher is good to go.", nil
 }
@@ -1,6 +1,7 @@
 package content
 
 import (
+	"context"
 	"fmt"
 	"net/http"
 	"net/http/httptest"
@@ -77,7 +78,7 @@ func TestEnrichedContentFetcherGetValidContent(t *testing.T) {
 
 	fetcher := NewEnrichedContentFetcher(server.URL, "", "")
 
-	resp, err := fetcher.GetContent(testUUID, "tid_1234")
+	resp, err := fetcher.GetContent(context.Background(), testUUID, "tid_1234")
 
 	assert.NoError(t, err)
 	mockServer.AssertExpectations(t)
@@ -97,7 +98,7 @@ func TestEnrichedContentFetcherGetValidContentWithAuthorizationAndXPolicy(t *tes
 
 	fetcher := NewEnrichedContentFetcher(server.URL, auth, xPolicies)
 
-	resp, err := fetcher.GetContent(testUUID, "tid_1234")
+	resp, err := fetcher.GetContent(context.Background(), testUUID, "tid_1234")
 	assert.NoError(t, err)
 	mockServer.AssertExpectations(t)
 	assert.Equal(t, len(testData), len(resp))
@@ -115,7 +116,7 @@ func TestEnrichedContentFetcherGetContentWithAuthError(t *testing.T) {
 
 	fetcher := NewEnrichedContentFetcher(server.URL, auth, xPolicies)
 
-	_, err := fetcher.GetContent(testUUID, "tid_1234")
+	_, err := fetcher.GetContent(context.Background(), testUUID, "tid_1234")
 	assert.Error(t, err)
 	mockServer.AssertExpectations(t)
 	assert.Equal(t, "EnrichedContent returned HTTP 401", err.Error())
@@ -132,7 +133,7 @@ func TestEnrichedContentFetcherGetContentWithForbiddenError(t *testing.T) {
 
 	fetcher := NewEnrichedContentFetcher(server.URL, auth, xPolicies)
 
-	_, err := fetcher.GetContent(testUUID, "tid_1234")
+	_, err := fetcher.GetContent(context.Background(), testUUID, "tid_1234")
 	assert.Error(t, err)
 	mockServer.AssertExpectations(t)
 	assert.Equal(t, "Access to content is forbidden. Skipping", err.Error())
@@ -140,10 +141,10 @@ func TestEnrichedContentFetcherGetContentWithForbiddenError(t *testing.T) {
 
 func TestEnrichedContentFetcherGetContentWithErrorOnNewRequest(t *testing.T) {
 	fetcher := &EnrichedContentFetcher{Client: &http.Client{},
-		EnrichedContentBaseURL: "://",
+		Endpoints: NewEndpointPool(&Endpoint{BaseURL: "://"}),
 	}
 
-	_, err := fetcher.GetContent("uuid1", "tid_1234")
+	_, err := fetcher.GetContent(context.Background(), "uuid1", "tid_1234")
 	assert.Error(t, err)
 	assert.Equal(t, "parse :///enrichedcontent/uuid1: missing protocol scheme", err.Error())
 }
@@ -153,15 +154,29 @@ func TestEnrichedContentFetcherGetContentErrorOnRequestDo(t *testing.T) {
 	mockClient.On("Do", mock.AnythingOfType("*http.Request")).Return(&http.Response{}, errors.New("Http Client err"))
 
 	fetcher := &EnrichedContentFetcher{Client: mockClient,
-		EnrichedContentBaseURL: "http://server",
+		Endpoints: NewEndpointPool(&Endpoint{BaseURL: "http://server"}),
 	}
 
-	_, err := fetcher.GetContent("uuid1", "tid_1234")
+	_, err := fetcher.GetContent(context.Background(), "uuid1", "tid_1234")
 	assert.Error(t, err)
 	assert.Equal(t, "Http Client err", err.Error())
 	mockClient.AssertExpectations(t)
 }
 
+func TestEnrichedContentFetcherGetContentWithCancelledContext(t *testing.T) {
+	mockServer := new(mockEnrichedContentServer)
+	server := mockServer.startMockEnrichedContentServer(t)
+	fetcher := NewEnrichedContentFetcher(server.URL, "", "")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err := fetcher.GetContent(ctx, uuid.New(), "tid_1234")
+
+	assert.Error(t, err)
+	assert.Contains(t, err.Error(), context.Canceled.Error())
+	mockServer.AssertExpectations(t)
+}
+
 func TestEnrichedContentFetcherCheckHealth(t *testing.T) {
 	mockServer := new(mockEnrichedContentServer)
 	mockServer.On("GTG").Return(200)
@@ -190,7 +205,7 @@ func TestEnrichedContentFetcherCheckHealthError(t *testing.T) {
 
 func TestEnrichedContentFetcherCheckHealthErrorOnNewRequest(t *testing.T) {
 	fetcher := &EnrichedContentFetcher{
-		EnrichedContentHealthURL: "://",
+		Endpoints: NewEndpointPool(&Endpoint{HealthURL: "://"}),
 	}
 
 	resp, err := fetcher.CheckHealth(&http.Client{})
@@ -205,8 +220,8 @@ func TestEnrichedContentFetcherCheckHealthErrorOnRequestDo(t *testing.T) {
 	mockClient.On("Do", mock.AnythingOfType("*http.Request")).Return(&http.Response{}, errors.New("Http Client err"))
 
 	fetcher := &EnrichedContentFetcher{
-		EnrichedContentHealthURL: "http://server",
-		Authorization:            "some-auth",
+		Endpoints:     NewEndpointPool(&Endpoint{HealthURL: "http://server"}),
+		Authorization: "some-auth",
 	}
 
 	resp, err := fetcher.CheckHealth(mockClient)
@@ -218,9 +233,44 @@ func TestEnrichedContentFetcherCheckHealthErrorOnRequestDo(t *testing.T) {
 
 func NewEnrichedContentFetcher(enrichedContentBaseURL, authorization, xPolicyHeaderValues string) Fetcher {
 	return &EnrichedContentFetcher{Client: &http.Client{},
-		EnrichedContentBaseURL:   enrichedContentBaseURL,
-		EnrichedContentHealthURL: enrichedContentBaseURL + "/__gtg",
-		XPolicyHeaderValues:      xPolicyHeaderValues,
-		Authorization:            authorization,
+		Endpoints:           NewEndpointPool(&Endpoint{BaseURL: enrichedContentBaseURL, HealthURL: enrichedContentBaseURL + "/__gtg", Weight: 1}),
+		XPolicyHeaderValues: xPolicyHeaderValues,
+		Authorization:       authorization,
+	}
+}
+
+func TestEnrichedContentFetcherWithXPoliciesSendsJobPolicies(t *testing.T) {
+	var xPolicy string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		xPolicy = r.Header.Get("X-Policy")
+	}))
+	defer server.Close()
+
+	fetcher := &EnrichedContentFetcher{
+		Client:              &http.Client{},
+		Endpoints:           NewEndpointPool(&Endpoint{BaseURL: server.URL}),
+		XPolicyHeaderValues: "INCLUDE_RICH_CONTENT",
 	}
+	jobFetcher := fetcher.WithXPolicies("EXPAND_IMAGES,INCLUDE_RICH_CONTENT")
+
+	_, err := jobFetcher.GetContent(context.Background(), "uuid1", "tid_1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "EXPAND_IMAGES,INCLUDE_RICH_CONTENT", xPolicy)
+
+	_, err = fetcher.GetContent(context.Background(), "uuid1", "tid_1234")
+	assert.NoError(t, err)
+	assert.Equal(t, "INCLUDE_RICH_CONTENT", xPolicy)
+}
+
+func TestEnrichedContentFetcherGetContentNotFound(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+	}))
+	defer server.Close()
+
+	fetcher := &EnrichedContentFetcher{Client: &http.Client{}, Endpoints: NewEndpointPool(&Endpoint{BaseURL: server.URL})}
+	_, err := fetcher.GetContent(context.Background(), "uuid1", "tid_1234")
+
+	assert.Equal(t, ErrContentNotFound, err)
+	assert.True(t, IsInaccessible(err))
 }
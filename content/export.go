@@ -1,20 +1,48 @@
 package content
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
 	"fmt"
 	"strings"
+
+	log "github.com/sirupsen/logrus"
 )
 
 const DefaultDate = "0000-00-00"
 
+// UnknownDate is the date of the stubs of notifications which do not carry the date of the content, like metadata changes.
+// It is read from the payload when the content is exported
+const UnknownDate = ""
+
+// ErrUnchanged is returned when the payload matches the last exported version, so no upload was made
+var ErrUnchanged = errors.New("Content has not changed since last export")
+
+// ErrNotDistributable is returned when the fetched payload has a canBeDistributed other than "yes", so no upload was made
+var ErrNotDistributable = errors.New("Content cannot be distributed")
+
+// canBeDistributedYes is the canBeDistributed value of the content which can be exported
+const canBeDistributedYes = "yes"
+
+// ErrEncodingNotSupported is returned when an encoding is requested for an updater that does not compress payloads
+var ErrEncodingNotSupported = errors.New("Sink does not support content encodings")
+
+// ErrXPoliciesNotSupported is returned when X-Policy values are requested for a fetcher that does not send them upstream
+var ErrXPoliciesNotSupported = errors.New("Source does not support X-Policy headers")
+
 type Stub struct {
 	Uuid, Date       string
 	CanBeDistributed *string
 }
 
 type Exporter struct {
-	Fetcher Fetcher
-	Updater Updater
+	Fetcher           Fetcher
+	Updater           Updater
+	HashIndex         HashIndex
+	ConditionalUpload bool
+	Transformer       Transformer
+	Validator         Validator
 }
 
 func NewExporter(fetcher Fetcher, updater Updater) *Exporter {
@@ -24,19 +52,172 @@ func NewExporter(fetcher Fetcher, updater Updater) *Exporter {
 	}
 }
 
-func (e *Exporter) HandleContent(tid string, doc Stub) error {
-	payload, err := e.Fetcher.GetContent(doc.Uuid, tid)
+// WithFetcher returns a copy of the exporter getting the content from the fetcher
+func (e *Exporter) WithFetcher(f Fetcher) *Exporter {
+	exporter := *e
+	exporter.Fetcher = f
+	return &exporter
+}
+
+// WithXPolicies returns a copy of the exporter whose fetcher sends the X-Policy values with every request
+func (e *Exporter) WithXPolicies(xPolicies string) (*Exporter, error) {
+	pf, ok := e.Fetcher.(PolicyFetcher)
+	if !ok {
+		return nil, ErrXPoliciesNotSupported
+	}
+	return e.WithFetcher(pf.WithXPolicies(xPolicies)), nil
+}
+
+// SupportsBatches tells whether the fetcher gets several contents in a single call
+func (e *Exporter) SupportsBatches() bool {
+	_, ok := e.Fetcher.(BatchFetcher)
+	return ok
+}
+
+// WithEncoding returns a copy of the exporter whose updater compresses the uploaded payloads with the encoding
+func (e *Exporter) WithEncoding(encoding Encoding) (*Exporter, error) {
+	eu, ok := e.Updater.(EncodingUpdater)
+	if !ok {
+		if encoding.IsIdentity() {
+			return e, nil
+		}
+		return nil, ErrEncodingNotSupported
+	}
+	exporter := *e
+	exporter.Updater = eu.WithEncoding(encoding)
+	return &exporter, nil
+}
+
+// WithTransformer returns a copy of the exporter applying the transformer to fetched payloads before uploading them
+func (e *Exporter) WithTransformer(t Transformer) *Exporter {
+	exporter := *e
+	exporter.Transformer = t
+	return &exporter
+}
+
+// HandleContent fetches and uploads the doc. Failures are *AttemptsError, telling how many calls were made
+func (e *Exporter) HandleContent(ctx context.Context, tid string, doc Stub) error {
+	ctx = WithAttemptCounter(ctx)
+	payload, err := e.Fetcher.GetContent(ctx, doc.Uuid, tid)
 	if err != nil {
-		return fmt.Errorf("Error getting content for %v: %v", doc.Uuid, err)
+		return withAttempts(ctx, fmt.Errorf("Error getting content for %v: %w", doc.Uuid, err))
 	}
+	return withAttempts(ctx, e.exportPayload(ctx, tid, doc, payload))
+}
 
-	err = e.Updater.Upload(payload, tid, doc.Uuid, doc.Date)
+// HandleContents exports the docs fetching them in a batch where the fetcher supports it.
+// It returns the errors of the docs that failed, keyed by uuid
+func (e *Exporter) HandleContents(ctx context.Context, tid string, docs []Stub) map[string]error {
+	errs := make(map[string]error)
+	if !e.SupportsBatches() || len(docs) == 1 {
+		// Each doc is fetched on its own, counting its own attempts
+		for _, doc := range docs {
+			if err := e.HandleContent(ctx, tid, doc); err != nil {
+				errs[doc.Uuid] = err
+			}
+		}
+		return errs
+	}
+	uuids := make([]string, len(docs))
+	for i, doc := range docs {
+		uuids[i] = doc.Uuid
+	}
+	fetchCtx := WithAttemptCounter(ctx)
+	payloads, fetchErrs := FetchContents(fetchCtx, e.Fetcher, uuids, tid)
+
+	for _, doc := range docs {
+		if err, failed := fetchErrs[doc.Uuid]; failed {
+			errs[doc.Uuid] = withAttempts(fetchCtx, fmt.Errorf("Error getting content for %v: %w", doc.Uuid, err))
+			continue
+		}
+		docCtx := WithAttemptCounter(ctx)
+		if err := e.exportPayload(docCtx, tid, doc, payloads[doc.Uuid]); err != nil {
+			errs[doc.Uuid] = withAttempts(docCtx, err)
+		}
+	}
+	return errs
+}
+
+func (e *Exporter) exportPayload(ctx context.Context, tid string, doc Stub, payload []byte) (err error) {
+	// Notifications of some formats carry neither the date of the content nor whether it can be distributed,
+	// both are read from the payload. Payloads which are not JSON objects have none
+	var fields map[string]interface{}
+	json.Unmarshal(payload, &fields)
+	if canBeDistributed, ok := fields["canBeDistributed"].(string); ok && canBeDistributed != canBeDistributedYes {
+		return ErrNotDistributable
+	}
+	if doc.Date == UnknownDate {
+		doc.Date = GetDateOrDefault(fields)
+	}
+	if e.Validator != nil {
+		if err := e.Validator.Validate(payload); err != nil {
+			return fmt.Errorf("Error validating content for %v: %w", doc.Uuid, err)
+		}
+	}
+
+	if e.Transformer != nil {
+		payload, err = e.Transformer.Transform(payload)
+		if err != nil {
+			return fmt.Errorf("Error transforming content for %v: %v", doc.Uuid, err)
+		}
+	}
+
+	var hash string
+	if e.HashIndex != nil {
+		hash = e.hash(payload)
+		lastHash, err := e.HashIndex.Get(doc.Uuid)
+		if err != nil {
+			log.WithField("transaction_id", tid).WithField("uuid", doc.Uuid).WithError(err).Warn("Could not read last exported hash, uploading anyway")
+		} else if lastHash == hash {
+			return ErrUnchanged
+		}
+	} else if e.ConditionalUpload {
+		current, err := e.Updater.IsCurrent(ctx, payload, tid, doc.Uuid, doc.Date)
+		if err != nil {
+			log.WithField("transaction_id", tid).WithField("uuid", doc.Uuid).WithError(err).Warn("Could not check stored version, uploading anyway")
+		} else if current {
+			return ErrUnchanged
+		}
+	}
+
+	err = e.Updater.Upload(ctx, payload, tid, doc.Uuid, doc.Date)
 	if err != nil {
 		return fmt.Errorf("Error uploading content for %v: %v", doc.Uuid, err)
 	}
+	if e.HashIndex != nil {
+		if err := e.HashIndex.Put(doc.Uuid, hash); err != nil {
+			log.WithField("transaction_id", tid).WithField("uuid", doc.Uuid).WithError(err).Warn("Could not store exported hash")
+		}
+	}
 	return nil
 }
 
+// hash is the hash the HashIndex keeps for the payload. It covers the scope of the fetcher and the encoding of the
+// updater, so a content exported again from another source, with other X-Policy values or another encoding is uploaded
+// even when its payload is the same. Payloads fetched without a scope and uploaded as they are hash on their own
+func (e *Exporter) hash(payload []byte) string {
+	var scope []string
+	if sf, ok := e.Fetcher.(ScopedFetcher); ok {
+		scope = append(scope, sf.Scope())
+	}
+	if encoding := UpdaterEncoding(e.Updater); !encoding.IsIdentity() {
+		scope = append(scope, string(encoding))
+	}
+	if len(scope) == 0 {
+		return Hash(payload)
+	}
+	return Hash(append([]byte(strings.Join(scope, "\n")+"\n"), payload...))
+}
+
+func (e *Exporter) DeleteContent(ctx context.Context, tid, uuid string) error {
+	if e.HashIndex != nil {
+		if err := e.HashIndex.Delete(uuid); err != nil {
+			log.WithField("transaction_id", tid).WithField("uuid", uuid).WithError(err).Warn("Could not remove exported hash")
+		}
+	}
+	return e.Updater.Delete(ctx, uuid, tid)
+}
+
 func GetDateOrDefault(payload map[string]interface{}) (date string) {
 	docFirstPublishedDate, _ := payload["firstPublishedDate"]
 	d, ok := docFirstPublishedDate.(string)
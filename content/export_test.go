@@ -1,10 +1,12 @@
 package content
 
 import (
+	"context"
+	"errors"
 	"testing"
 
-	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
 )
 
 func TestExporterHandleContentWithValidContent(t *testing.T) {
@@ -16,13 +18,55 @@ func TestExporterHandleContentWithValidContent(t *testing.T) {
 	updater := &mockUpdater{t: t, expectedUuid: stubUuid, expectedTid: tid, expectedDate: date, expectedPayload: testData}
 
 	exporter := NewExporter(fetcher, updater)
-	err := exporter.HandleContent(tid, Stub{stubUuid, date, nil})
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
 
 	assert.NoError(t, err)
 	assert.True(t, fetcher.called)
 	assert.True(t, updater.called)
 }
 
+func TestExporterHandleContentReadsUnknownDateFromContent(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	testData := []byte(`{"uuid": "uuid1", "firstPublishedDate": "2017-10-09T10:20:30.000Z"}`)
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: testData}
+	updater := &mockUpdater{t: t, expectedUuid: stubUuid, expectedTid: tid, expectedDate: "2017-10-09", expectedPayload: testData}
+
+	exporter := NewExporter(fetcher, updater)
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, UnknownDate, nil})
+
+	assert.NoError(t, err)
+	assert.True(t, updater.called)
+}
+
+func TestExporterHandleContentSkipsContentWhichCannotBeDistributed(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	testData := []byte(`{"uuid": "uuid1", "canBeDistributed": "verify"}`)
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: testData}
+	updater := &mockUpdater{t: t}
+
+	exporter := NewExporter(fetcher, updater)
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, UnknownDate, nil})
+
+	assert.Equal(t, ErrNotDistributable, err)
+	assert.False(t, updater.called)
+}
+
+func TestExporterHandleContentKeepsDefaultDate(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	testData := []byte(`{"uuid": "uuid1", "firstPublishedDate": "2017-10-09T10:20:30.000Z"}`)
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: testData}
+	updater := &mockUpdater{t: t, expectedUuid: stubUuid, expectedTid: tid, expectedDate: DefaultDate, expectedPayload: testData}
+
+	exporter := NewExporter(fetcher, updater)
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, DefaultDate, nil})
+
+	assert.NoError(t, err)
+	assert.True(t, updater.called)
+}
+
 func TestExporterHandleContentWithErrorFromFetcher(t *testing.T) {
 	tid := "tid_1234"
 	stubUuid := "uuid1"
@@ -32,7 +76,7 @@ func TestExporterHandleContentWithErrorFromFetcher(t *testing.T) {
 	updater := &mockUpdater{t: t}
 
 	exporter := NewExporter(fetcher, updater)
-	err := exporter.HandleContent(tid, Stub{stubUuid, date, nil})
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
 
 	assert.Error(t, err)
 	assert.Equal(t, "Error getting content for uuid1: fetcher err", err.Error())
@@ -40,6 +84,18 @@ func TestExporterHandleContentWithErrorFromFetcher(t *testing.T) {
 	assert.False(t, updater.called)
 }
 
+func TestExporterHandleContentWithForbiddenContent(t *testing.T) {
+	fetcher := &mockFetcher{t: t, expectedUuid: "uuid1", expectedTid: "tid_1234", err: ErrForbidden}
+	updater := &mockUpdater{t: t}
+
+	exporter := NewExporter(fetcher, updater)
+	err := exporter.HandleContent(context.Background(), "tid_1234", Stub{"uuid1", "2017-10-09", nil})
+
+	assert.True(t, errors.Is(err, ErrForbidden))
+	assert.True(t, IsInaccessible(err))
+	assert.False(t, updater.called)
+}
+
 func TestExporterHandleContentWithErrorFromUpdater(t *testing.T) {
 	tid := "tid_1234"
 	stubUuid := "uuid1"
@@ -49,7 +105,7 @@ func TestExporterHandleContentWithErrorFromUpdater(t *testing.T) {
 	updater := &mockUpdater{t: t, expectedUuid: stubUuid, expectedTid: tid, expectedDate: date, expectedPayload: testData, err: errors.New("updater err")}
 
 	exporter := NewExporter(fetcher, updater)
-	err := exporter.HandleContent(tid, Stub{stubUuid, date, nil})
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
 
 	assert.Error(t, err)
 	assert.Equal(t, "Error uploading content for uuid1: updater err", err.Error())
@@ -57,6 +113,233 @@ func TestExporterHandleContentWithErrorFromUpdater(t *testing.T) {
 	assert.True(t, updater.called)
 }
 
+func TestExporterHandleContentSkipsUnchangedContent(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	date := "2017-10-09"
+	testData := []byte(stubUuid)
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: testData}
+	updater := &mockUpdater{t: t}
+	exporter := NewExporter(fetcher, updater)
+	exporter.HashIndex = NewInMemoryHashIndex()
+	exporter.HashIndex.Put(stubUuid, Hash(testData))
+
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
+	assert.Equal(t, ErrUnchanged, err)
+	assert.True(t, fetcher.called)
+	assert.False(t, updater.called)
+}
+
+func TestExporterHandleContentStoresHashOfUploadedContent(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	date := "2017-10-09"
+	testData := []byte(stubUuid)
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: testData}
+	updater := &mockUpdater{t: t, expectedUuid: stubUuid, expectedTid: tid, expectedDate: date, expectedPayload: testData}
+	exporter := NewExporter(fetcher, updater)
+	exporter.HashIndex = NewInMemoryHashIndex()
+	exporter.HashIndex.Put(stubUuid, Hash([]byte("previous version")))
+
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
+	assert.NoError(t, err)
+	assert.True(t, updater.called)
+	hash, _ := exporter.HashIndex.Get(stubUuid)
+	assert.Equal(t, Hash(testData), hash)
+}
+
+func TestExporterHandleContentDoesNotStoreHashWhenUploadFails(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	date := "2017-10-09"
+	testData := []byte(stubUuid)
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: testData}
+	updater := &mockUpdater{t: t, expectedUuid: stubUuid, expectedTid: tid, expectedDate: date, expectedPayload: testData, err: errors.New("updater err")}
+	exporter := NewExporter(fetcher, updater)
+	exporter.HashIndex = NewInMemoryHashIndex()
+
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
+	assert.Error(t, err)
+	hash, _ := exporter.HashIndex.Get(stubUuid)
+	assert.Empty(t, hash)
+}
+
+func TestExporterHandleContentSkipsContentAlreadyCurrentInUpdater(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	date := "2017-10-09"
+	testData := []byte(stubUuid)
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: testData}
+	updater := &mockUpdater{t: t, expectedUuid: stubUuid, expectedPayload: testData, current: true}
+	exporter := NewExporter(fetcher, updater)
+	exporter.ConditionalUpload = true
+
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
+	assert.Equal(t, ErrUnchanged, err)
+	assert.True(t, updater.checked)
+	assert.False(t, updater.called)
+}
+
+func TestExporterHandleContentUploadsWhenVersionCheckFails(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	date := "2017-10-09"
+	testData := []byte(stubUuid)
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: testData}
+	updater := &mockUpdater{t: t, expectedUuid: stubUuid, expectedTid: tid, expectedDate: date, expectedPayload: testData, checkErr: errors.New("check err")}
+	exporter := NewExporter(fetcher, updater)
+	exporter.ConditionalUpload = true
+
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
+	assert.NoError(t, err)
+	assert.True(t, updater.checked)
+	assert.True(t, updater.called)
+}
+
+func TestExporterHandleContentWithTransformer(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	date := "2017-10-09"
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: []byte(`{"uuid":"uuid1","annotations":[]}`)}
+	updater := &mockUpdater{t: t, expectedUuid: stubUuid, expectedTid: tid, expectedDate: date, expectedPayload: []byte(`{"uuid":"uuid1"}`)}
+	exporter := NewExporter(fetcher, updater).WithTransformer(FieldDenyList{"annotations"})
+
+	err := exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
+	assert.NoError(t, err)
+	assert.True(t, updater.called)
+}
+
+func TestExporterHandleContentWithErrorFromTransformer(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	date := "2017-10-09"
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: []byte(`{"uuid":"uuid1"}`)}
+	updater := &mockUpdater{t: t}
+	transformer, err := NewTemplateTransformer(`{{.uuid}}`)
+	assert.NoError(t, err)
+	exporter := NewExporter(fetcher, updater).WithTransformer(transformer)
+
+	err = exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
+	assert.EqualError(t, err, "Error transforming content for uuid1: Template output is not valid JSON")
+	assert.False(t, updater.called)
+}
+
+func TestExporterWithTransformerDoesNotChangeOriginal(t *testing.T) {
+	exporter := NewExporter(&mockFetcher{}, &mockUpdater{})
+	transformed := exporter.WithTransformer(FieldDenyList{"annotations"})
+	assert.Nil(t, exporter.Transformer)
+	assert.NotNil(t, transformed.Transformer)
+	assert.Equal(t, exporter.Fetcher, transformed.Fetcher)
+}
+
+func TestExporterHandleContentUploadsUnchangedContentWithAnotherEncoding(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	date := "2017-10-09"
+	testData := []byte(stubUuid)
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: testData}
+	updater := &mockEncodingUpdater{mockUpdater: mockUpdater{t: t, expectedUuid: stubUuid, expectedTid: tid, expectedDate: date, expectedPayload: testData}, encoding: IdentityEncoding}
+	exporter := NewExporter(fetcher, updater)
+	exporter.HashIndex = NewInMemoryHashIndex()
+
+	require.NoError(t, exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil}))
+	assert.Equal(t, ErrUnchanged, exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil}))
+
+	gzipExporter, err := exporter.WithEncoding(GzipEncoding)
+	require.NoError(t, err)
+	gzipUpdater := gzipExporter.Updater.(*mockEncodingUpdater)
+	assert.NoError(t, gzipExporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil}))
+	assert.True(t, gzipUpdater.called)
+	assert.Equal(t, ErrUnchanged, gzipExporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil}))
+}
+
+func TestExporterHashCoversSourceAndXPolicies(t *testing.T) {
+	payload := []byte(`{"uuid":"uuid1"}`)
+	exporter := NewExporter(&EnrichedContentFetcher{XPolicyHeaderValues: "INCLUDE_RICH_CONTENT"}, &mockUpdater{})
+	withPolicies, err := exporter.WithXPolicies("EXPAND_IMAGES")
+	require.NoError(t, err)
+	fromStore := exporter.WithFetcher(NewMongoFetcher(nil, "content"))
+
+	assert.Equal(t, exporter.hash(payload), exporter.hash(payload))
+	assert.NotEqual(t, exporter.hash(payload), withPolicies.hash(payload))
+	assert.NotEqual(t, exporter.hash(payload), fromStore.hash(payload))
+	assert.NotEqual(t, Hash(payload), fromStore.hash(payload))
+}
+
+func TestExporterWithEncoding(t *testing.T) {
+	exporter := NewExporter(&mockFetcher{}, &S3Updater{})
+	zstdExporter, err := exporter.WithEncoding(ZstdEncoding)
+	require.NoError(t, err)
+	assert.Equal(t, ZstdEncoding, UpdaterEncoding(zstdExporter.Updater))
+	assert.Equal(t, IdentityEncoding, UpdaterEncoding(exporter.Updater))
+
+	_, err = NewExporter(&mockFetcher{}, &mockUpdater{}).WithEncoding(GzipEncoding)
+	assert.Equal(t, ErrEncodingNotSupported, err)
+}
+
+func TestExporterWithXPoliciesChangesFetcherHeaders(t *testing.T) {
+	fetcher := &EnrichedContentFetcher{XPolicyHeaderValues: "INCLUDE_RICH_CONTENT"}
+	exporter := NewExporter(fetcher, &mockUpdater{})
+
+	withPolicies, err := exporter.WithXPolicies("EXPAND_IMAGES")
+	assert.NoError(t, err)
+	assert.Equal(t, "EXPAND_IMAGES", withPolicies.Fetcher.(*EnrichedContentFetcher).XPolicyHeaderValues)
+	assert.Equal(t, "INCLUDE_RICH_CONTENT", fetcher.XPolicyHeaderValues)
+}
+
+func TestExporterWithXPoliciesForUnsupportedFetcher(t *testing.T) {
+	exporter := NewExporter(&mockFetcher{}, &mockUpdater{})
+
+	_, err := exporter.WithXPolicies("EXPAND_IMAGES")
+	assert.Equal(t, ErrXPoliciesNotSupported, err)
+}
+
+func TestExporterHandleContentWithInvalidContent(t *testing.T) {
+	tid := "tid_1234"
+	stubUuid := "uuid1"
+	date := "2017-10-09"
+	fetcher := &mockFetcher{t: t, expectedUuid: stubUuid, expectedTid: tid, result: []byte(`{"type":"Article"}`)}
+	updater := &mockUpdater{t: t}
+	validator, err := NewSchemaValidator(map[string][]byte{"Article": []byte(`{"required": ["title"]}`)})
+	assert.NoError(t, err)
+	exporter := NewExporter(fetcher, updater)
+	exporter.Validator = validator
+
+	err = exporter.HandleContent(context.Background(), tid, Stub{stubUuid, date, nil})
+	assert.EqualError(t, err, "Error validating content for uuid1: Content of type Article is invalid: (root): title is required")
+	var validationErr *ValidationError
+	assert.True(t, errors.As(err, &validationErr))
+	assert.False(t, updater.called)
+}
+
+func TestExporterHandleContentsFallsBackToSingleFetches(t *testing.T) {
+	tid := "tid_1234"
+	date := "2017-10-09"
+	fetcher := &mockMultiFetcher{results: map[string][]byte{"uuid1": []byte("uuid1")}}
+	updater := &mockUpdater{t: t, expectedUuid: "uuid1", expectedTid: tid, expectedDate: date, expectedPayload: []byte("uuid1")}
+	exporter := NewExporter(fetcher, updater)
+
+	errs := exporter.HandleContents(context.Background(), tid, []Stub{{"uuid1", date, nil}, {"uuid2", date, nil}})
+	assert.Len(t, errs, 1)
+	assert.EqualError(t, errs["uuid2"], "Error getting content for uuid2: not found")
+	assert.Equal(t, []string{"uuid1", "uuid2"}, fetcher.calls)
+	assert.True(t, updater.called)
+}
+
+type mockMultiFetcher struct {
+	results map[string][]byte
+	calls   []string
+}
+
+func (f *mockMultiFetcher) GetContent(ctx context.Context, uuid, tid string) ([]byte, error) {
+	f.calls = append(f.calls, uuid)
+	result, found := f.results[uuid]
+	if !found {
+		return nil, errors.New("not found")
+	}
+	return result, nil
+}
+
 type mockFetcher struct {
 	t                         *testing.T
 	expectedUuid, expectedTid string
@@ -65,7 +348,7 @@ type mockFetcher struct {
 	called                    bool
 }
 
-func (f *mockFetcher) GetContent(uuid, tid string) ([]byte, error) {
+func (f *mockFetcher) GetContent(ctx context.Context, uuid, tid string) ([]byte, error) {
 	assert.Equal(f.t, f.expectedUuid, uuid)
 	assert.Equal(f.t, f.expectedTid, tid)
 	f.called = true
@@ -78,9 +361,12 @@ type mockUpdater struct {
 	expectedPayload                         []byte
 	err                                     error
 	called                                  bool
+	current                                 bool
+	checkErr                                error
+	checked                                 bool
 }
 
-func (u *mockUpdater) Upload(content []byte, tid, uuid, date string) error {
+func (u *mockUpdater) Upload(ctx context.Context, content []byte, tid, uuid, date string) error {
 	assert.Equal(u.t, u.expectedUuid, uuid)
 	assert.Equal(u.t, u.expectedTid, tid)
 	assert.Equal(u.t, u.expectedDate, date)
@@ -89,10 +375,32 @@ func (u *mockUpdater) Upload(content []byte, tid, uuid, date string) error {
 	return u.err
 }
 
-func (u *mockUpdater) Delete(uuid, tid string) error {
+func (u *mockUpdater) Delete(ctx context.Context, uuid, tid string) error {
 	panic("should not be called")
 }
 
+func (u *mockUpdater) IsCurrent(ctx context.Context, content []byte, tid, uuid, date string) (bool, error) {
+	assert.Equal(u.t, u.expectedUuid, uuid)
+	assert.Equal(u.t, u.expectedPayload, content)
+	u.checked = true
+	return u.current, u.checkErr
+}
+
+type mockEncodingUpdater struct {
+	mockUpdater
+	encoding Encoding
+}
+
+func (u *mockEncodingUpdater) ContentEncoding() Encoding {
+	return u.encoding
+}
+
+func (u *mockEncodingUpdater) WithEncoding(e Encoding) Updater {
+	updater := &mockEncodingUpdater{mockUpdater: u.mockUpdater, encoding: e}
+	updater.called = false
+	return updater
+}
+
 func TestGetDateWhenFirstPublishedDateIsPresent(t *testing.T) {
 	expectedDate := "2006-01-02"
 	firsPublishDate := expectedDate + "T15:04:05Z07:00"
@@ -0,0 +1,106 @@
+package content
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"sync"
+	"time"
+
+	bolt "go.etcd.io/bbolt"
+)
+
+var hashBucket = []byte("content-hashes")
+
+// HashIndex keeps the hash of the last exported payload for each content
+type HashIndex interface {
+	Get(uuid string) (string, error)
+	Put(uuid, hash string) error
+	Delete(uuid string) error
+}
+
+// ScopedFetcher is a fetcher whose payloads depend on more than the content, like the source it reads from
+// and the X-Policy header values it sends. Its scope is hashed along with the payloads
+type ScopedFetcher interface {
+	Fetcher
+	Scope() string
+}
+
+// Hash returns the content hash the HashIndex stores for a payload
+func Hash(payload []byte) string {
+	sum := sha256.Sum256(payload)
+	return hex.EncodeToString(sum[:])
+}
+
+type InMemoryHashIndex struct {
+	sync.RWMutex
+	hashes map[string]string
+}
+
+func NewInMemoryHashIndex() *InMemoryHashIndex {
+	return &InMemoryHashIndex{hashes: make(map[string]string)}
+}
+
+func (i *InMemoryHashIndex) Get(uuid string) (string, error) {
+	i.RLock()
+	defer i.RUnlock()
+	return i.hashes[uuid], nil
+}
+
+func (i *InMemoryHashIndex) Put(uuid, hash string) error {
+	i.Lock()
+	defer i.Unlock()
+	i.hashes[uuid] = hash
+	return nil
+}
+
+func (i *InMemoryHashIndex) Delete(uuid string) error {
+	i.Lock()
+	defer i.Unlock()
+	delete(i.hashes, uuid)
+	return nil
+}
+
+// BoltHashIndex persists the hashes in a local embedded store so they survive restarts
+type BoltHashIndex struct {
+	db *bolt.DB
+}
+
+func NewBoltHashIndex(path string) (*BoltHashIndex, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(hashBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltHashIndex{db: db}, nil
+}
+
+func (i *BoltHashIndex) Get(uuid string) (hash string, err error) {
+	err = i.db.View(func(tx *bolt.Tx) error {
+		hash = string(tx.Bucket(hashBucket).Get([]byte(uuid)))
+		return nil
+	})
+	return
+}
+
+func (i *BoltHashIndex) Put(uuid, hash string) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(hashBucket).Put([]byte(uuid), []byte(hash))
+	})
+}
+
+func (i *BoltHashIndex) Delete(uuid string) error {
+	return i.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(hashBucket).Delete([]byte(uuid))
+	})
+}
+
+func (i *BoltHashIndex) Close() error {
+	return i.db.Close()
+}
@@ -0,0 +1,58 @@
+package content
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHashIsStableForSamePayload(t *testing.T) {
+	assert.Equal(t, Hash([]byte(`{"uuid":"uuid1"}`)), Hash([]byte(`{"uuid":"uuid1"}`)))
+	assert.NotEqual(t, Hash([]byte(`{"uuid":"uuid1"}`)), Hash([]byte(`{"uuid":"uuid2"}`)))
+}
+
+func TestInMemoryHashIndex(t *testing.T) {
+	index := NewInMemoryHashIndex()
+	testHashIndex(t, index)
+}
+
+func TestBoltHashIndex(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-exporter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "hashes.db")
+	index, err := NewBoltHashIndex(path)
+	require.NoError(t, err)
+	testHashIndex(t, index)
+
+	require.NoError(t, index.Put("uuid2", "hash2"))
+	require.NoError(t, index.Close())
+
+	reopened, err := NewBoltHashIndex(path)
+	require.NoError(t, err)
+	defer reopened.Close()
+	hash, err := reopened.Get("uuid2")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash2", hash)
+}
+
+func testHashIndex(t *testing.T, index HashIndex) {
+	hash, err := index.Get("uuid1")
+	assert.NoError(t, err)
+	assert.Empty(t, hash)
+
+	assert.NoError(t, index.Put("uuid1", "hash1"))
+	hash, err = index.Get("uuid1")
+	assert.NoError(t, err)
+	assert.Equal(t, "hash1", hash)
+
+	assert.NoError(t, index.Delete("uuid1"))
+	hash, err = index.Get("uuid1")
+	assert.NoError(t, err)
+	assert.Empty(t, hash)
+}
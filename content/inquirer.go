@@ -1,6 +1,7 @@
 package content
 
 import (
+	"context"
 	"fmt"
 
 	"github.com/Financial-Times/content-exporter/db"
@@ -8,7 +9,7 @@ import (
 )
 
 type Inquirer interface {
-	Inquire(collection string, candidates []string) (chan Stub, error, int)
+	Inquire(ctx context.Context, collection string, candidates []string) (chan Stub, error, int)
 }
 
 type MongoInquirer struct {
@@ -19,7 +20,7 @@ func NewMongoInquirer(mongo db.Service) *MongoInquirer {
 	return &MongoInquirer{Mongo: mongo}
 }
 
-func (m *MongoInquirer) Inquire(collection string, candidates []string) (chan Stub, error, int) {
+func (m *MongoInquirer) Inquire(ctx context.Context, collection string, candidates []string) (chan Stub, error, int) {
 	tx, err := m.Mongo.Open()
 
 	if err != nil {
@@ -47,7 +48,12 @@ func (m *MongoInquirer) Inquire(collection string, candidates []string) (chan St
 				log.Warn(err)
 				continue
 			}
-			docs <- stub
+			select {
+			case docs <- stub:
+			case <-ctx.Done():
+				log.Infof("Inquiry cancelled after %v docs", counter)
+				return
+			}
 		}
 		log.Infof("Processed %v docs", counter)
 	}()
@@ -33,6 +33,16 @@ func (tx *mockTX) FindUUIDs(collectionID string, candidates []string) (db.Iterat
 	return args.Get(0).(db.Iterator), args.Int(1), args.Error(2)
 }
 
+func (tx *mockTX) FindContent(collectionID string, uuid string) (map[string]interface{}, error) {
+	args := tx.Called(collectionID, uuid)
+	return args.Get(0).(map[string]interface{}), args.Error(1)
+}
+
+func (tx *mockTX) FindContents(collectionID string, uuids []string) ([]map[string]interface{}, error) {
+	args := tx.Called(collectionID, uuids)
+	return args.Get(0).([]map[string]interface{}), args.Error(1)
+}
+
 func (tx *mockTX) Ping(ctx context.Context) error {
 	panic("implement me")
 }
@@ -90,7 +100,7 @@ func TestMongoInquirerInquireSuccessfully(t *testing.T) {
 	mockIter.On("Close").Return(nil)
 	inquirer := NewInquirer(mockDb)
 
-	docCh, err, count := inquirer.Inquire(testCollection, nil)
+	docCh, err, count := inquirer.Inquire(context.Background(), testCollection, nil)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, count)
 waitLoop:
@@ -112,6 +122,44 @@ waitLoop:
 	mockIter.AssertExpectations(t)
 }
 
+func TestMongoInquirerInquireStopsWhenCancelled(t *testing.T) {
+	mockDb := new(mockDbService)
+	mockTx := new(mockTX)
+	mockIter := new(MockDBIter)
+
+	testCollection := "testing"
+
+	mockDb.On("Open").Return(mockTx, nil)
+	mockTx.On("Close")
+	mockTx.On("FindUUIDs", testCollection, mock.AnythingOfType("[]string")).Return(mockIter, 100, nil)
+	mockIter.On("Next", mock.AnythingOfType("*map[string]interface {}")).Return(true).Run(func(args mock.Arguments) {
+		arg := args.Get(0).(*map[string]interface{})
+		*arg = make(map[string]interface{})
+		(*arg)["uuid"] = "uuid1"
+	})
+	mockIter.On("Close").Return(nil)
+	inquirer := NewInquirer(mockDb)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	docCh, err, count := inquirer.Inquire(ctx, testCollection, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, 100, count)
+	cancel()
+waitLoop:
+	for {
+		select {
+		case _, open := <-docCh:
+			if !open {
+				break waitLoop
+			}
+		case <-time.After(3 * time.Second):
+			t.FailNow()
+		}
+	}
+	mockTx.AssertExpectations(t)
+	mockIter.AssertExpectations(t)
+}
+
 func TestMongoInquirerInquireWithoutValidContent(t *testing.T) {
 	mockDb := new(mockDbService)
 	mockTx := new(mockTX)
@@ -132,7 +180,7 @@ func TestMongoInquirerInquireWithoutValidContent(t *testing.T) {
 	mockIter.On("Close").Return(nil)
 	inquirer := NewInquirer(mockDb)
 
-	docCh, err, count := inquirer.Inquire(testCollection, candidates)
+	docCh, err, count := inquirer.Inquire(context.Background(), testCollection, candidates)
 	assert.NoError(t, err)
 	assert.Equal(t, 1, count)
 waitLoop:
@@ -167,7 +215,7 @@ func TestMongoInquirerInquireErrorFindingUUIDs(t *testing.T) {
 
 	inquirer := NewInquirer(mockDb)
 
-	docCh, err, count := inquirer.Inquire(testCollection, candidates)
+	docCh, err, count := inquirer.Inquire(context.Background(), testCollection, candidates)
 	assert.Error(t, err)
 	assert.Equal(t, "Mongo err", err.Error())
 	assert.Equal(t, 0, count)
@@ -191,7 +239,7 @@ func TestMongoInquirerInquireErrorOpenMongo(t *testing.T) {
 
 	inquirer := NewInquirer(mockDb)
 
-	docCh, err, count := inquirer.Inquire(testCollection, candidates)
+	docCh, err, count := inquirer.Inquire(context.Background(), testCollection, candidates)
 	assert.Error(t, err)
 	assert.Equal(t, "Mongo err", err.Error())
 	assert.Equal(t, 0, count)
@@ -0,0 +1,25 @@
+package content
+
+import (
+	"context"
+	"time"
+)
+
+// Manifest describes an export, telling the consumers of the exported content how its payloads were written
+type Manifest struct {
+	JobID           string    `json:"jobId"`
+	Status          string    `json:"status"`
+	ContentEncoding Encoding  `json:"contentEncoding"`
+	Transformation  string    `json:"transformation,omitempty"`
+	Source          string    `json:"source,omitempty"`
+	XPolicies       string    `json:"xPolicies,omitempty"`
+	Count           int       `json:"count"`
+	Progress        int       `json:"progress"`
+	Failed          int       `json:"failed"`
+	Finished        time.Time `json:"finished"`
+}
+
+// ManifestWriter is a sink storing the manifests of the exports
+type ManifestWriter interface {
+	WriteManifest(ctx context.Context, tid string, manifest Manifest) error
+}
@@ -0,0 +1,130 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"golang.org/x/time/rate"
+)
+
+const (
+	rateDecreaseFactor = 0.5
+	rateIncreaseSteps  = 20
+)
+
+// RateLimiter is a token bucket shared by everything calling the same upstream.
+// It halves the rate when the upstream pushes back with 429 or 503, grows it back step by step
+// on successful responses up to the configured rate, and holds all the calls for the Retry-After period
+type RateLimiter struct {
+	sync.Mutex
+	limiter     *rate.Limiter
+	maxRate     rate.Limit
+	pausedUntil time.Time
+}
+
+// NewRateLimiter allows ratePerSecond calls per second. Zero means no limit, though Retry-After is still honoured
+func NewRateLimiter(ratePerSecond int) *RateLimiter {
+	l := &RateLimiter{limiter: rate.NewLimiter(rate.Inf, 1)}
+	l.SetRate(ratePerSecond)
+	return l
+}
+
+// SetRate changes the configured rate, resetting any adaptation made so far
+func (l *RateLimiter) SetRate(ratePerSecond int) {
+	l.Lock()
+	defer l.Unlock()
+	l.maxRate = rate.Inf
+	if ratePerSecond > 0 {
+		l.maxRate = rate.Limit(ratePerSecond)
+	}
+	l.limiter.SetLimit(l.maxRate)
+}
+
+// Rate returns the configured and the current, adapted rate. Zero means no limit
+func (l *RateLimiter) Rate() (maxRate float64, currentRate float64) {
+	l.Lock()
+	defer l.Unlock()
+	if l.maxRate == rate.Inf {
+		return 0, 0
+	}
+	return float64(l.maxRate), float64(l.limiter.Limit())
+}
+
+// Wait blocks until the call is allowed or the context is done
+func (l *RateLimiter) Wait(ctx context.Context) error {
+	l.Lock()
+	pause := time.Until(l.pausedUntil)
+	l.Unlock()
+	if pause > 0 {
+		select {
+		case <-time.After(pause):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+	return l.limiter.Wait(ctx)
+}
+
+// Observe adapts the rate to the response of the upstream
+func (l *RateLimiter) Observe(resp *http.Response) {
+	l.Lock()
+	defer l.Unlock()
+	if resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode == http.StatusServiceUnavailable {
+		if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After")); ok {
+			if until := time.Now().Add(retryAfter); until.After(l.pausedUntil) {
+				l.pausedUntil = until
+			}
+		}
+		if l.maxRate == rate.Inf {
+			return
+		}
+		decreased := l.limiter.Limit() * rateDecreaseFactor
+		if min := l.maxRate / rateIncreaseSteps; decreased < min {
+			decreased = min
+		}
+		l.limiter.SetLimit(decreased)
+		return
+	}
+	if resp.StatusCode < 300 && l.limiter.Limit() < l.maxRate {
+		increased := l.limiter.Limit() + l.maxRate/rateIncreaseSteps
+		if increased > l.maxRate {
+			increased = l.maxRate
+		}
+		l.limiter.SetLimit(increased)
+	}
+}
+
+func parseRetryAfter(value string) (time.Duration, bool) {
+	if value == "" {
+		return 0, false
+	}
+	if seconds, err := strconv.Atoi(value); err == nil {
+		return time.Duration(seconds) * time.Second, seconds >= 0
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		return time.Until(date), true
+	}
+	return 0, false
+}
+
+// RateLimitedTransport waits for the rate limiter before each request and feeds it the responses.
+// Placed under the retrying client, every retry is rate limited as well
+type RateLimitedTransport struct {
+	Transport http.RoundTripper
+	Limiter   *RateLimiter
+}
+
+func (t *RateLimitedTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if err := t.Limiter.Wait(req.Context()); err != nil {
+		return nil, err
+	}
+	resp, err := t.Transport.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	t.Limiter.Observe(resp)
+	return resp, nil
+}
@@ -0,0 +1,104 @@
+package content
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func upstreamResponse(status int, retryAfter string) *http.Response {
+	resp := &http.Response{StatusCode: status, Header: make(http.Header)}
+	if retryAfter != "" {
+		resp.Header.Set("Retry-After", retryAfter)
+	}
+	return resp
+}
+
+func TestRateLimiterDecreasesOnPushBackAndRecovers(t *testing.T) {
+	limiter := NewRateLimiter(100)
+
+	limiter.Observe(upstreamResponse(http.StatusTooManyRequests, ""))
+	maxRate, currentRate := limiter.Rate()
+	assert.Equal(t, 100.0, maxRate)
+	assert.Equal(t, 50.0, currentRate)
+
+	limiter.Observe(upstreamResponse(http.StatusServiceUnavailable, ""))
+	_, currentRate = limiter.Rate()
+	assert.Equal(t, 25.0, currentRate)
+
+	limiter.Observe(upstreamResponse(http.StatusOK, ""))
+	_, currentRate = limiter.Rate()
+	assert.Equal(t, 30.0, currentRate)
+
+	for i := 0; i < 20; i++ {
+		limiter.Observe(upstreamResponse(http.StatusOK, ""))
+	}
+	_, currentRate = limiter.Rate()
+	assert.Equal(t, 100.0, currentRate)
+}
+
+func TestRateLimiterDoesNotDecreaseBelowMinimum(t *testing.T) {
+	limiter := NewRateLimiter(20)
+	for i := 0; i < 10; i++ {
+		limiter.Observe(upstreamResponse(http.StatusTooManyRequests, ""))
+	}
+	_, currentRate := limiter.Rate()
+	assert.Equal(t, 1.0, currentRate)
+}
+
+func TestRateLimiterSetRateResetsAdaptation(t *testing.T) {
+	limiter := NewRateLimiter(100)
+	limiter.Observe(upstreamResponse(http.StatusTooManyRequests, ""))
+
+	limiter.SetRate(10)
+	maxRate, currentRate := limiter.Rate()
+	assert.Equal(t, 10.0, maxRate)
+	assert.Equal(t, 10.0, currentRate)
+
+	limiter.SetRate(0)
+	maxRate, currentRate = limiter.Rate()
+	assert.Equal(t, 0.0, maxRate)
+	assert.Equal(t, 0.0, currentRate)
+}
+
+func TestRateLimiterHonoursRetryAfter(t *testing.T) {
+	limiter := NewRateLimiter(0)
+	limiter.Observe(upstreamResponse(http.StatusServiceUnavailable, "1"))
+
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, limiter.Wait(ctx))
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	d, ok := parseRetryAfter("3")
+	assert.True(t, ok)
+	assert.Equal(t, 3*time.Second, d)
+
+	d, ok = parseRetryAfter(time.Now().Add(time.Minute).UTC().Format(http.TimeFormat))
+	assert.True(t, ok)
+	assert.InDelta(t, float64(time.Minute), float64(d), float64(2*time.Second))
+
+	_, ok = parseRetryAfter("soon")
+	assert.False(t, ok)
+}
+
+func TestRateLimitedTransportAdaptsToResponses(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	defer server.Close()
+
+	limiter := NewRateLimiter(100)
+	client := &http.Client{Transport: &RateLimitedTransport{Transport: http.DefaultTransport, Limiter: limiter}}
+
+	resp, err := client.Get(server.URL)
+	assert.NoError(t, err)
+	resp.Body.Close()
+	_, currentRate := limiter.Rate()
+	assert.Equal(t, 50.0, currentRate)
+}
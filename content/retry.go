@@ -0,0 +1,192 @@
+package content
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"math/rand"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// DefaultRetryPolicy makes up to 4 attempts with a jittered backoff from 500ms, only on transport errors, 429 and transient 5xx
+// statuses. It differs from the pester client used before, which made 3 attempts waiting 2s then 4s and retried on any 5xx
+const DefaultRetryPolicy = "attempts=4;base=500ms;cap=10s;jitter=0.5;statuses=429,500,502,503,504"
+
+// RetryPolicy tells how calls to an upstream are retried. Only idempotent requests are retried,
+// on transport errors or on the retryable status codes, waiting an exponential backoff between attempts
+type RetryPolicy struct {
+	MaxAttempts          int
+	BackoffBase          time.Duration
+	BackoffCap           time.Duration
+	Jitter               float64
+	RetryableStatusCodes []int
+}
+
+// ParseRetryPolicy reads a policy like attempts=4;base=500ms;cap=10s;jitter=0.5;statuses=429,503.
+// Jitter is the fraction of each backoff which is randomised
+func ParseRetryPolicy(spec string) (RetryPolicy, error) {
+	policy := RetryPolicy{MaxAttempts: 1}
+	for _, setting := range strings.Split(spec, ";") {
+		setting = strings.TrimSpace(setting)
+		if setting == "" {
+			continue
+		}
+		kv := strings.SplitN(setting, "=", 2)
+		if len(kv) != 2 {
+			return RetryPolicy{}, fmt.Errorf("Invalid retry policy setting: %v", setting)
+		}
+		key, value := strings.TrimSpace(kv[0]), strings.TrimSpace(kv[1])
+		var err error
+		switch key {
+		case "attempts":
+			policy.MaxAttempts, err = strconv.Atoi(value)
+			if err == nil && policy.MaxAttempts < 1 {
+				err = fmt.Errorf("at least 1 attempt is needed")
+			}
+		case "base":
+			policy.BackoffBase, err = time.ParseDuration(value)
+		case "cap":
+			policy.BackoffCap, err = time.ParseDuration(value)
+		case "jitter":
+			policy.Jitter, err = strconv.ParseFloat(value, 64)
+			if err == nil && (policy.Jitter < 0 || policy.Jitter > 1) {
+				err = fmt.Errorf("jitter must be between 0 and 1")
+			}
+		case "statuses":
+			policy.RetryableStatusCodes = nil
+			for _, status := range splitList(value) {
+				code, err := strconv.Atoi(status)
+				if err != nil {
+					return RetryPolicy{}, fmt.Errorf("Invalid retry policy setting %v: %v", setting, err)
+				}
+				policy.RetryableStatusCodes = append(policy.RetryableStatusCodes, code)
+			}
+		default:
+			err = fmt.Errorf("unknown setting")
+		}
+		if err != nil {
+			return RetryPolicy{}, fmt.Errorf("Invalid retry policy setting %v: %v", setting, err)
+		}
+	}
+	return policy, nil
+}
+
+func (p RetryPolicy) isRetryable(statusCode int) bool {
+	for _, code := range p.RetryableStatusCodes {
+		if code == statusCode {
+			return true
+		}
+	}
+	return false
+}
+
+// Backoff returns the wait before the attempt following the given one
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	backoff := float64(p.BackoffBase) * math.Pow(2, float64(attempt-1))
+	if p.BackoffCap > 0 && backoff > float64(p.BackoffCap) {
+		backoff = float64(p.BackoffCap)
+	}
+	backoff -= backoff * p.Jitter * rand.Float64()
+	return time.Duration(backoff)
+}
+
+// RetryingClient makes the calls according to the retry policy
+type RetryingClient struct {
+	Client Client
+	Policy RetryPolicy
+}
+
+func (c *RetryingClient) Do(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	maxAttempts := c.Policy.MaxAttempts
+	if !isIdempotent(req.Method) || (req.Body != nil && req.Body != http.NoBody && req.GetBody == nil) {
+		maxAttempts = 1
+	}
+	for attempt := 1; ; attempt++ {
+		attemptReq := req
+		if attempt > 1 {
+			attemptReq = req.Clone(ctx)
+			if req.GetBody != nil {
+				body, err := req.GetBody()
+				if err != nil {
+					return nil, err
+				}
+				attemptReq.Body = body
+			}
+		}
+		countAttempt(ctx)
+		resp, err := c.Client.Do(attemptReq)
+		if attempt >= maxAttempts || ctx.Err() != nil {
+			return resp, err
+		}
+		if err == nil {
+			if !c.Policy.isRetryable(resp.StatusCode) {
+				return resp, nil
+			}
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		select {
+		case <-time.After(c.Policy.Backoff(attempt)):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodPut, http.MethodDelete, http.MethodOptions:
+		return true
+	}
+	return false
+}
+
+type attemptsKey struct{}
+
+// WithAttemptCounter returns a context in which the retrying clients count the calls they make
+func WithAttemptCounter(ctx context.Context) context.Context {
+	return context.WithValue(ctx, attemptsKey{}, new(int32))
+}
+
+// Attempts returns the number of calls counted in the context so far
+func Attempts(ctx context.Context) int {
+	if counter, ok := ctx.Value(attemptsKey{}).(*int32); ok {
+		return int(atomic.LoadInt32(counter))
+	}
+	return 0
+}
+
+func countAttempt(ctx context.Context) {
+	if counter, ok := ctx.Value(attemptsKey{}).(*int32); ok {
+		atomic.AddInt32(counter, 1)
+	}
+}
+
+// AttemptsError carries the number of calls made to the upstreams before the export failed
+type AttemptsError struct {
+	Attempts int
+	Err      error
+}
+
+func (e *AttemptsError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *AttemptsError) Unwrap() error {
+	return e.Err
+}
+
+// withAttempts attaches the attempts counted in the context to the export failure
+func withAttempts(ctx context.Context, err error) error {
+	if err == nil || err == ErrUnchanged || err == ErrNotDistributable {
+		return err
+	}
+	return &AttemptsError{Attempts: Attempts(ctx), Err: err}
+}
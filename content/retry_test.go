@@ -0,0 +1,129 @@
+package content
+
+import (
+	"bytes"
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseRetryPolicy(t *testing.T) {
+	policy, err := ParseRetryPolicy(DefaultRetryPolicy)
+	require.NoError(t, err)
+	assert.Equal(t, RetryPolicy{
+		MaxAttempts:          4,
+		BackoffBase:          500 * time.Millisecond,
+		BackoffCap:           10 * time.Second,
+		Jitter:               0.5,
+		RetryableStatusCodes: []int{429, 500, 502, 503, 504},
+	}, policy)
+
+	policy, err = ParseRetryPolicy("")
+	require.NoError(t, err)
+	assert.Equal(t, 1, policy.MaxAttempts)
+}
+
+func TestParseRetryPolicyErrors(t *testing.T) {
+	for _, spec := range []string{"attempts", "attempts=0", "base=soon", "jitter=2", "statuses=5xx", "retries=3"} {
+		_, err := ParseRetryPolicy(spec)
+		assert.Error(t, err, spec)
+	}
+}
+
+func TestRetryPolicyBackoff(t *testing.T) {
+	policy := RetryPolicy{BackoffBase: 100 * time.Millisecond, BackoffCap: time.Second}
+	assert.Equal(t, 100*time.Millisecond, policy.Backoff(1))
+	assert.Equal(t, 400*time.Millisecond, policy.Backoff(3))
+	assert.Equal(t, time.Second, policy.Backoff(10))
+
+	policy.Jitter = 0.5
+	for i := 0; i < 10; i++ {
+		backoff := policy.Backoff(1)
+		assert.True(t, backoff >= 50*time.Millisecond && backoff <= 100*time.Millisecond)
+	}
+}
+
+func TestRetryingClientRetriesRetryableStatusCodes(t *testing.T) {
+	calls := 0
+	var bodies []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		if calls < 3 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+	}))
+	defer server.Close()
+
+	client := &RetryingClient{Client: &http.Client{}, Policy: RetryPolicy{MaxAttempts: 4, RetryableStatusCodes: []int{503}}}
+	ctx := WithAttemptCounter(context.Background())
+	req, _ := http.NewRequestWithContext(ctx, "PUT", server.URL, bytes.NewBufferString("payload"))
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusOK, resp.StatusCode)
+	assert.Equal(t, 3, calls)
+	assert.Equal(t, []string{"payload", "payload", "payload"}, bodies)
+	assert.Equal(t, 3, Attempts(ctx))
+}
+
+func TestRetryingClientDoesNotRetryPermanentErrors(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusForbidden)
+	}))
+	defer server.Close()
+
+	client := &RetryingClient{Client: &http.Client{}, Policy: RetryPolicy{MaxAttempts: 4, RetryableStatusCodes: []int{503}}}
+	req, _ := http.NewRequest("GET", server.URL, nil)
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, http.StatusForbidden, resp.StatusCode)
+	assert.Equal(t, 1, calls)
+}
+
+func TestRetryingClientDoesNotRetryNonIdempotentRequests(t *testing.T) {
+	calls := 0
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		calls++
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	client := &RetryingClient{Client: &http.Client{}, Policy: RetryPolicy{MaxAttempts: 4, RetryableStatusCodes: []int{503}}}
+	req, _ := http.NewRequest("POST", server.URL, bytes.NewBufferString("payload"))
+
+	resp, err := client.Do(req)
+	require.NoError(t, err)
+	resp.Body.Close()
+	assert.Equal(t, 1, calls)
+}
+
+func TestExporterHandleContentReportsAttempts(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+
+	client := &RetryingClient{Client: &http.Client{}, Policy: RetryPolicy{MaxAttempts: 2, RetryableStatusCodes: []int{502}}}
+	fetcher := &EnrichedContentFetcher{Client: client, Endpoints: NewEndpointPool(&Endpoint{BaseURL: server.URL})}
+	exporter := NewExporter(fetcher, &mockUpdater{t: t})
+
+	err := exporter.HandleContent(context.Background(), "tid_1234", Stub{"uuid1", "2017-10-09", nil})
+	require.Error(t, err)
+	assert.Equal(t, "Error getting content for uuid1: EnrichedContent returned HTTP 502", err.Error())
+	attemptsErr, ok := err.(*AttemptsError)
+	require.True(t, ok)
+	assert.Equal(t, 2, attemptsErr.Attempts)
+}
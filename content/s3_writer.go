@@ -2,29 +2,39 @@ package content
 
 import (
 	"bytes"
+	"context"
+	"crypto/md5"
+	"encoding/hex"
+	"encoding/json"
 	"fmt"
 	"github.com/pkg/errors"
 	"io/ioutil"
 	"net/http"
+	"strings"
 )
 
 const s3WriterPath = "/content/"
 
+// s3WriterManifestPath is where the manifests of the exports are stored, one per job
+const s3WriterManifestPath = "/manifest/"
+
 var ErrNotFound = errors.New("Content RW S3 returned HTTP 404 with message")
 
 type Updater interface {
-	Upload(content []byte, tid, uuid, date string) error
-	Delete(uuid, tid string) error
+	Upload(ctx context.Context, content []byte, tid, uuid, date string) error
+	Delete(ctx context.Context, uuid, tid string) error
+	IsCurrent(ctx context.Context, content []byte, tid, uuid, date string) (bool, error)
 }
 
 type S3Updater struct {
 	Client            Client
 	S3WriterBaseURL   string
 	S3WriterHealthURL string
+	Encoding          Encoding
 }
 
-func (u *S3Updater) Delete(uuid, tid string) error {
-	req, err := http.NewRequest("DELETE", u.S3WriterBaseURL+s3WriterPath+uuid, nil)
+func (u *S3Updater) Delete(ctx context.Context, uuid, tid string) error {
+	req, err := http.NewRequestWithContext(ctx, "DELETE", u.S3WriterBaseURL+s3WriterPath+uuid, nil)
 	if err != nil {
 		return err
 	}
@@ -47,20 +57,27 @@ func (u *S3Updater) Delete(uuid, tid string) error {
 	return nil
 }
 
-func (u *S3Updater) Upload(content []byte, tid, uuid, date string) error {
+func (u *S3Updater) Upload(ctx context.Context, content []byte, tid, uuid, date string) error {
+	encoded, err := u.Encoding.Encode(content)
+	if err != nil {
+		return err
+	}
 	buf := new(bytes.Buffer)
-	_, err := buf.Write(content)
+	_, err = buf.Write(encoded)
 	if err != nil {
 		return err
 	}
 
-	req, err := http.NewRequest("PUT", u.S3WriterBaseURL+s3WriterPath+uuid+"?date="+date, buf)
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.S3WriterBaseURL+s3WriterPath+uuid+"?date="+date, buf)
 	if err != nil {
 		return err
 	}
 	req.Header.Add("User-Agent", "UPP Content Exporter")
 	req.Header.Add("Content-Type", "application/json")
 	req.Header.Add("X-Request-Id", tid)
+	if !u.Encoding.IsIdentity() {
+		req.Header.Add("Content-Encoding", string(u.Encoding))
+	}
 
 	resp, err := u.Client.Do(req)
 	if err != nil {
@@ -74,6 +91,78 @@ func (u *S3Updater) Upload(content []byte, tid, uuid, date string) error {
 	return nil
 }
 
+// IsCurrent checks whether the stored object already holds the content, comparing its ETag with the MD5 of the payload as it would be uploaded
+func (u *S3Updater) IsCurrent(ctx context.Context, content []byte, tid, uuid, date string) (bool, error) {
+	encoded, err := u.Encoding.Encode(content)
+	if err != nil {
+		return false, err
+	}
+	sum := md5.Sum(encoded)
+	etag := hex.EncodeToString(sum[:])
+
+	req, err := http.NewRequestWithContext(ctx, "HEAD", u.S3WriterBaseURL+s3WriterPath+uuid+"?date="+date, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Add("User-Agent", "UPP Content Exporter")
+	req.Header.Add("X-Request-Id", tid)
+	req.Header.Add("If-None-Match", `"`+etag+`"`)
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return false, err
+	}
+	defer resp.Body.Close()
+	switch {
+	case resp.StatusCode == http.StatusNotModified:
+		return true, nil
+	case resp.StatusCode == http.StatusNotFound:
+		return false, nil
+	case resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices:
+		return false, fmt.Errorf("Content RW S3 returned HTTP %v", resp.StatusCode)
+	}
+	return strings.Trim(resp.Header.Get("ETag"), `"`) == etag, nil
+}
+
+func (u *S3Updater) ContentEncoding() Encoding {
+	if u.Encoding == "" {
+		return IdentityEncoding
+	}
+	return u.Encoding
+}
+
+// WithEncoding returns a copy of the updater compressing the uploaded payloads with the encoding
+func (u *S3Updater) WithEncoding(e Encoding) Updater {
+	updater := *u
+	updater.Encoding = e
+	return &updater
+}
+
+// WriteManifest stores the manifest of an export next to the exported content, uncompressed
+func (u *S3Updater) WriteManifest(ctx context.Context, tid string, manifest Manifest) error {
+	body, err := json.Marshal(manifest)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", u.S3WriterBaseURL+s3WriterManifestPath+manifest.JobID, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Add("User-Agent", "UPP Content Exporter")
+	req.Header.Add("Content-Type", "application/json")
+	req.Header.Add("X-Request-Id", tid)
+
+	resp, err := u.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode < http.StatusOK || resp.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("Content RW S3 returned HTTP %v", resp.StatusCode)
+	}
+	return nil
+}
+
 func (u *S3Updater) CheckHealth(client Client) (string, error) {
 	req, err := http.NewRequest("GET", u.S3WriterHealthURL, nil)
 	if err != nil {
@@ -1,6 +1,9 @@
 package content
 
 import (
+	"compress/gzip"
+	"context"
+	"encoding/json"
 	"errors"
 	"io/ioutil"
 	"net/http"
@@ -12,6 +15,7 @@ import (
 	"github.com/pborman/uuid"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 func (m *mockS3WriterServer) startMockS3WriterServer(t *testing.T) *httptest.Server {
@@ -88,11 +92,62 @@ func TestS3UpdaterUploadContent(t *testing.T) {
 
 	updater := NewS3Updater(server.URL)
 
-	err := updater.Upload(testData, "tid_1234", testUUID, date)
+	err := updater.Upload(context.Background(), testData, "tid_1234", testUUID, date)
 	assert.NoError(t, err)
 	mockServer.AssertExpectations(t)
 }
 
+func TestS3UpdaterUploadGzipEncodedContent(t *testing.T) {
+	testUUID := uuid.NewUUID().String()
+	testData := []byte(testUUID)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, "gzip", r.Header.Get("Content-Encoding"))
+		zr, err := gzip.NewReader(r.Body)
+		require.NoError(t, err)
+		body, err := ioutil.ReadAll(zr)
+		require.NoError(t, err)
+		assert.Equal(t, testData, body)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	updater := &S3Updater{Client: &http.Client{}, S3WriterBaseURL: server.URL, Encoding: GzipEncoding}
+
+	err := updater.Upload(context.Background(), testData, "tid_1234", testUUID, "2017-10-09")
+	assert.NoError(t, err)
+	assert.Equal(t, GzipEncoding, UpdaterEncoding(updater))
+}
+
+func TestS3UpdaterWithEncodingLeavesOriginalUntouched(t *testing.T) {
+	updater := &S3Updater{Client: &http.Client{}, Encoding: GzipEncoding}
+	zstdUpdater := updater.WithEncoding(ZstdEncoding)
+
+	assert.Equal(t, ZstdEncoding, UpdaterEncoding(zstdUpdater))
+	assert.Equal(t, GzipEncoding, UpdaterEncoding(updater))
+}
+
+func TestS3UpdaterWriteManifest(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodPut, r.Method)
+		assert.Equal(t, "/manifest/job1", r.URL.Path)
+		assert.Equal(t, "tid_1234", r.Header.Get("X-Request-Id"))
+		assert.Empty(t, r.Header.Get("Content-Encoding"))
+		var manifest Manifest
+		require.NoError(t, json.NewDecoder(r.Body).Decode(&manifest))
+		assert.Equal(t, "job1", manifest.JobID)
+		assert.Equal(t, ZstdEncoding, manifest.ContentEncoding)
+		assert.Equal(t, "EXPAND_IMAGES, INCLUDE_RICH_CONTENT", manifest.XPolicies)
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer server.Close()
+
+	updater := &S3Updater{Client: &http.Client{}, S3WriterBaseURL: server.URL, Encoding: ZstdEncoding}
+
+	err := updater.WriteManifest(context.Background(), "tid_1234", Manifest{JobID: "job1", ContentEncoding: ZstdEncoding, XPolicies: "EXPAND_IMAGES, INCLUDE_RICH_CONTENT"})
+	assert.NoError(t, err)
+}
+
 func TestS3UpdaterUploadContentErrorResponse(t *testing.T) {
 	testUUID := uuid.NewUUID().String()
 	testData := []byte(testUUID)
@@ -104,7 +159,7 @@ func TestS3UpdaterUploadContentErrorResponse(t *testing.T) {
 
 	updater := NewS3Updater(server.URL)
 
-	err := updater.Upload(testData, "tid_1234", testUUID, date)
+	err := updater.Upload(context.Background(), testData, "tid_1234", testUUID, date)
 	assert.Error(t, err)
 	assert.Equal(t, "Content RW S3 returned HTTP 503", err.Error())
 	mockServer.AssertExpectations(t)
@@ -113,7 +168,7 @@ func TestS3UpdaterUploadContentErrorResponse(t *testing.T) {
 func TestS3UpdaterUploadContentWithErrorOnNewRequest(t *testing.T) {
 	updater := NewS3Updater("://")
 
-	err := updater.Upload(nil, "tid_1234", "uuid1", "aDate")
+	err := updater.Upload(context.Background(), nil, "tid_1234", "uuid1", "aDate")
 	assert.Error(t, err)
 	assert.Equal(t, "parse :///content/uuid1?date=aDate: missing protocol scheme", err.Error())
 }
@@ -126,12 +181,65 @@ func TestS3UpdaterUploadContentErrorOnRequestDo(t *testing.T) {
 		S3WriterBaseURL: "http://server",
 	}
 
-	err := updater.Upload(nil, "tid_1234", "uuid1", "aDate")
+	err := updater.Upload(context.Background(), nil, "tid_1234", "uuid1", "aDate")
 	assert.Error(t, err)
 	assert.Equal(t, "Http Client err", err.Error())
 	mockClient.AssertExpectations(t)
 }
 
+func startMockS3WriterHeadServer(t *testing.T, status int, etag string, expectedIfNoneMatch string) *httptest.Server {
+	return httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		assert.Equal(t, http.MethodHead, r.Method)
+		assert.Equal(t, "/content/uuid1", r.URL.Path)
+		assert.Equal(t, "2017-10-09", r.URL.Query().Get("date"))
+		assert.Equal(t, "tid_1234", r.Header.Get("X-Request-Id"))
+		assert.Equal(t, expectedIfNoneMatch, r.Header.Get("If-None-Match"))
+		if etag != "" {
+			w.Header().Set("ETag", etag)
+		}
+		w.WriteHeader(status)
+	}))
+}
+
+func TestS3UpdaterIsCurrent(t *testing.T) {
+	testData := []byte("payload")
+	etag := `"321c3cf486ed509164edec1e1981fec8"`
+
+	var tests = []struct {
+		name     string
+		status   int
+		etag     string
+		expected bool
+	}{
+		{"not modified", http.StatusNotModified, "", true},
+		{"matching etag", http.StatusOK, etag, true},
+		{"different etag", http.StatusOK, `"other"`, false},
+		{"not found", http.StatusNotFound, "", false},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := startMockS3WriterHeadServer(t, test.status, test.etag, etag)
+			defer server.Close()
+
+			updater := NewS3Updater(server.URL)
+			current, err := updater.IsCurrent(context.Background(), testData, "tid_1234", "uuid1", "2017-10-09")
+			assert.NoError(t, err)
+			assert.Equal(t, test.expected, current)
+		})
+	}
+}
+
+func TestS3UpdaterIsCurrentErrorResponse(t *testing.T) {
+	server := startMockS3WriterHeadServer(t, http.StatusServiceUnavailable, "", `"321c3cf486ed509164edec1e1981fec8"`)
+	defer server.Close()
+
+	updater := NewS3Updater(server.URL)
+	_, err := updater.IsCurrent(context.Background(), []byte("payload"), "tid_1234", "uuid1", "2017-10-09")
+	assert.Error(t, err)
+	assert.Equal(t, "Content RW S3 returned HTTP 503", err.Error())
+}
+
 func TestS3UpdaterDeleteContent(t *testing.T) {
 	testUUID := uuid.NewUUID().String()
 
@@ -141,7 +249,7 @@ func TestS3UpdaterDeleteContent(t *testing.T) {
 
 	updater := NewS3Updater(server.URL)
 
-	err := updater.Delete(testUUID, "tid_1234")
+	err := updater.Delete(context.Background(), testUUID, "tid_1234")
 	assert.NoError(t, err)
 	mockServer.AssertExpectations(t)
 }
@@ -155,7 +263,7 @@ func TestS3UpdaterDeleteContentErrorResponse(t *testing.T) {
 
 	updater := NewS3Updater(server.URL)
 
-	err := updater.Delete(testUUID, "tid_1234")
+	err := updater.Delete(context.Background(), testUUID, "tid_1234")
 	assert.Error(t, err)
 	assert.Contains(t, err.Error(), "Content RW S3 returned HTTP 503")
 	mockServer.AssertExpectations(t)
@@ -164,7 +272,7 @@ func TestS3UpdaterDeleteContentErrorResponse(t *testing.T) {
 func TestS3UpdaterDeleteContentErrorOnNewRequest(t *testing.T) {
 	updater := NewS3Updater("://")
 
-	err := updater.Delete("uuid1", "tid_1234")
+	err := updater.Delete(context.Background(), "uuid1", "tid_1234")
 	assert.Error(t, err)
 	assert.Equal(t, "parse :///content/uuid1: missing protocol scheme", err.Error())
 }
@@ -178,7 +286,7 @@ func TestS3UpdaterDeleteContentErrorOnRequestDo(t *testing.T) {
 		S3WriterHealthURL: "http://server",
 	}
 
-	err := updater.Delete("uuid1", "tid_1234")
+	err := updater.Delete(context.Background(), "uuid1", "tid_1234")
 	assert.Error(t, err)
 	assert.Equal(t, "Http Client err", err.Error())
 	mockClient.AssertExpectations(t)
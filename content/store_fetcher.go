@@ -0,0 +1,88 @@
+package content
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+
+	"github.com/Financial-Times/content-exporter/db"
+	"gopkg.in/mgo.v2"
+)
+
+const (
+	EnrichedSource = "enriched"
+	StoreSource    = "store"
+)
+
+// MongoFetcher reads the raw content straight from the store instead of calling the enrichedcontent endpoint
+type MongoFetcher struct {
+	Mongo      db.Service
+	Collection string
+}
+
+func NewMongoFetcher(mongo db.Service, collection string) *MongoFetcher {
+	return &MongoFetcher{Mongo: mongo, Collection: collection}
+}
+
+// Scope tells the payloads are the raw content of the collection
+func (m *MongoFetcher) Scope() string {
+	return StoreSource + " " + m.Collection
+}
+
+func (m *MongoFetcher) GetContent(ctx context.Context, uuid, tid string) ([]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	tx, err := m.Mongo.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Close()
+
+	doc, err := tx.FindContent(m.Collection, uuid)
+	if err == mgo.ErrNotFound {
+		return nil, m.notFound()
+	}
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(doc)
+}
+
+// GetContents reads all the documents with a single query
+func (m *MongoFetcher) GetContents(ctx context.Context, uuids []string, tid string) (map[string][]byte, map[string]error, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+	tx, err := m.Mongo.Open()
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Close()
+
+	docs, err := tx.FindContents(m.Collection, uuids)
+	if err != nil {
+		return nil, nil, err
+	}
+	payloads := make(map[string][]byte)
+	errs := make(map[string]error)
+	for _, doc := range docs {
+		uuid, _ := doc["uuid"].(string)
+		payload, err := json.Marshal(doc)
+		if err != nil {
+			errs[uuid] = err
+			continue
+		}
+		payloads[uuid] = payload
+	}
+	for _, uuid := range uuids {
+		if _, found := payloads[uuid]; !found && errs[uuid] == nil {
+			errs[uuid] = m.notFound()
+		}
+	}
+	return payloads, errs, nil
+}
+
+func (m *MongoFetcher) notFound() error {
+	return fmt.Errorf("%w in %v collection", ErrContentNotFound, m.Collection)
+}
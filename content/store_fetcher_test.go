@@ -0,0 +1,110 @@
+package content
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/mgo.v2"
+)
+
+func TestMongoFetcherGetContent(t *testing.T) {
+	mockDb := new(mockDbService)
+	mockTx := new(mockTX)
+	mockDb.On("Open").Return(mockTx, nil)
+	mockTx.On("Close")
+	mockTx.On("FindContent", "content", "uuid1").Return(map[string]interface{}{"uuid": "uuid1", "title": "A title"}, nil)
+
+	fetcher := NewMongoFetcher(mockDb, "content")
+	payload, err := fetcher.GetContent(context.Background(), "uuid1", "tid_1234")
+
+	assert.NoError(t, err)
+	assert.JSONEq(t, `{"uuid":"uuid1","title":"A title"}`, string(payload))
+	mockDb.AssertExpectations(t)
+	mockTx.AssertExpectations(t)
+}
+
+func TestMongoFetcherGetContentNotFound(t *testing.T) {
+	mockDb := new(mockDbService)
+	mockTx := new(mockTX)
+	mockDb.On("Open").Return(mockTx, nil)
+	mockTx.On("Close")
+	mockTx.On("FindContent", "content", "uuid1").Return(map[string]interface{}(nil), mgo.ErrNotFound)
+
+	fetcher := NewMongoFetcher(mockDb, "content")
+	_, err := fetcher.GetContent(context.Background(), "uuid1", "tid_1234")
+
+	assert.EqualError(t, err, "Content not found in content collection")
+	mockTx.AssertExpectations(t)
+}
+
+func TestMongoFetcherGetContentErrorOpenMongo(t *testing.T) {
+	mockDb := new(mockDbService)
+	mockDb.On("Open").Return(new(mockTX), errors.New("Couldn't open mongo"))
+
+	fetcher := NewMongoFetcher(mockDb, "content")
+	_, err := fetcher.GetContent(context.Background(), "uuid1", "tid_1234")
+
+	assert.EqualError(t, err, "Couldn't open mongo")
+	mockDb.AssertExpectations(t)
+}
+
+func TestMongoFetcherGetContentWithCancelledContext(t *testing.T) {
+	mockDb := new(mockDbService)
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	fetcher := NewMongoFetcher(mockDb, "content")
+	_, err := fetcher.GetContent(ctx, "uuid1", "tid_1234")
+
+	assert.Equal(t, context.Canceled, err)
+	mockDb.AssertExpectations(t)
+}
+
+func TestMongoFetcherGetContents(t *testing.T) {
+	mockDb := new(mockDbService)
+	mockTx := new(mockTX)
+	mockDb.On("Open").Return(mockTx, nil)
+	mockTx.On("Close")
+	mockTx.On("FindContents", "content", []string{"uuid1", "uuid2"}).Return([]map[string]interface{}{{"uuid": "uuid1"}}, nil)
+
+	fetcher := NewMongoFetcher(mockDb, "content")
+	payloads, errs, err := fetcher.GetContents(context.Background(), []string{"uuid1", "uuid2"}, "tid_1234")
+
+	assert.NoError(t, err)
+	assert.Len(t, payloads, 1)
+	assert.JSONEq(t, `{"uuid":"uuid1"}`, string(payloads["uuid1"]))
+	assert.Len(t, errs, 1)
+	assert.EqualError(t, errs["uuid2"], "Content not found in content collection")
+	mockTx.AssertExpectations(t)
+}
+
+func TestFetchContentsUsesBatchFetcher(t *testing.T) {
+	mockDb := new(mockDbService)
+	mockTx := new(mockTX)
+	mockDb.On("Open").Return(mockTx, nil).Once()
+	mockTx.On("Close")
+	mockTx.On("FindContents", "content", []string{"uuid1", "uuid2"}).Return([]map[string]interface{}{{"uuid": "uuid1"}, {"uuid": "uuid2"}}, nil)
+
+	payloads, errs := FetchContents(context.Background(), NewMongoFetcher(mockDb, "content"), []string{"uuid1", "uuid2"}, "tid_1234")
+
+	assert.Len(t, payloads, 2)
+	assert.Empty(t, errs)
+	mockDb.AssertExpectations(t)
+	mockTx.AssertExpectations(t)
+}
+
+func TestFetchContentsFailsWholeBatch(t *testing.T) {
+	mockDb := new(mockDbService)
+	mockTx := new(mockTX)
+	mockDb.On("Open").Return(mockTx, nil)
+	mockTx.On("Close")
+	mockTx.On("FindContents", "content", []string{"uuid1", "uuid2"}).Return([]map[string]interface{}(nil), errors.New("query err"))
+
+	payloads, errs := FetchContents(context.Background(), NewMongoFetcher(mockDb, "content"), []string{"uuid1", "uuid2"}, "tid_1234")
+
+	assert.Empty(t, payloads)
+	assert.EqualError(t, errs["uuid1"], "query err")
+	assert.EqualError(t, errs["uuid2"], "query err")
+}
@@ -0,0 +1,340 @@
+package content
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"text/template"
+)
+
+// Transformer reshapes an enriched content payload before it is uploaded
+type Transformer interface {
+	Transform(payload []byte) ([]byte, error)
+}
+
+// TransformerChain applies its transformers in order, each one receiving the output of the previous
+type TransformerChain []Transformer
+
+func (c TransformerChain) Transform(payload []byte) ([]byte, error) {
+	var err error
+	for _, t := range c {
+		payload, err = t.Transform(payload)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return payload, nil
+}
+
+// FieldAllowList keeps only the listed fields. Fields are dot separated paths, e.g. "alternativeTitles.promotionalTitle"
+type FieldAllowList []string
+
+func (l FieldAllowList) Transform(payload []byte) ([]byte, error) {
+	doc, err := unmarshalDocument(payload)
+	if err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	for _, field := range l {
+		keys := strings.Split(field, ".")
+		if value, found := lookupField(doc, keys); found {
+			setField(result, keys, value)
+		}
+	}
+	return json.Marshal(result)
+}
+
+// FieldDenyList removes the listed fields. Fields are dot separated paths, e.g. "annotations"
+type FieldDenyList []string
+
+func (l FieldDenyList) Transform(payload []byte) ([]byte, error) {
+	doc, err := unmarshalDocument(payload)
+	if err != nil {
+		return nil, err
+	}
+	for _, field := range l {
+		deleteField(doc, strings.Split(field, "."))
+	}
+	return json.Marshal(doc)
+}
+
+// Rename moves the value of the From field to the To field
+type Rename struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+}
+
+// FieldRenamer applies its renames in order, so chained renames like a to b then b to c always give the same result
+type FieldRenamer []Rename
+
+func (r FieldRenamer) Transform(payload []byte) ([]byte, error) {
+	doc, err := unmarshalDocument(payload)
+	if err != nil {
+		return nil, err
+	}
+	for _, rename := range r {
+		fromKeys := strings.Split(rename.From, ".")
+		value, found := lookupField(doc, fromKeys)
+		if !found {
+			continue
+		}
+		deleteField(doc, fromKeys)
+		setField(doc, strings.Split(rename.To, "."), value)
+	}
+	return json.Marshal(doc)
+}
+
+// JSONPathProjection builds a new document whose fields are the results of JSONPath expressions against the payload.
+// The supported expressions are the dot notation with array indexes and wildcards, e.g. "$.brands[*].id"
+type JSONPathProjection map[string]string
+
+func (p JSONPathProjection) Transform(payload []byte) ([]byte, error) {
+	var doc interface{}
+	if err := decodeJSON(payload, &doc); err != nil {
+		return nil, err
+	}
+	result := make(map[string]interface{})
+	for field, path := range p {
+		tokens, err := parseJSONPath(path)
+		if err != nil {
+			return nil, err
+		}
+		if value, found := evaluateJSONPath(doc, tokens); found {
+			setField(result, strings.Split(field, "."), value)
+		}
+	}
+	return json.Marshal(result)
+}
+
+// TemplateTransformer renders the payload through a text/template which has to produce a valid JSON document.
+// The template receives the payload as a map and can use the "json" function to marshal values
+type TemplateTransformer struct {
+	tmpl *template.Template
+}
+
+func NewTemplateTransformer(text string) (*TemplateTransformer, error) {
+	tmpl, err := template.New("transformation").Funcs(template.FuncMap{
+		"json": func(v interface{}) (string, error) {
+			b, err := json.Marshal(v)
+			return string(b), err
+		},
+	}).Option("missingkey=zero").Parse(text)
+	if err != nil {
+		return nil, err
+	}
+	return &TemplateTransformer{tmpl: tmpl}, nil
+}
+
+func (t *TemplateTransformer) Transform(payload []byte) ([]byte, error) {
+	doc, err := unmarshalDocument(payload)
+	if err != nil {
+		return nil, err
+	}
+	buf := new(bytes.Buffer)
+	if err := t.tmpl.Execute(buf, doc); err != nil {
+		return nil, err
+	}
+	if !json.Valid(buf.Bytes()) {
+		return nil, fmt.Errorf("Template output is not valid JSON")
+	}
+	return buf.Bytes(), nil
+}
+
+type TransformerConfig struct {
+	Type     string            `json:"type"`
+	Fields   []string          `json:"fields,omitempty"`
+	Renames  []Rename          `json:"renames,omitempty"`
+	Paths    map[string]string `json:"paths,omitempty"`
+	Template string            `json:"template,omitempty"`
+}
+
+func (c TransformerConfig) build() (Transformer, error) {
+	switch c.Type {
+	case "allow":
+		return FieldAllowList(c.Fields), nil
+	case "deny":
+		return FieldDenyList(c.Fields), nil
+	case "rename":
+		return FieldRenamer(c.Renames), nil
+	case "project":
+		for _, path := range c.Paths {
+			if _, err := parseJSONPath(path); err != nil {
+				return nil, err
+			}
+		}
+		return JSONPathProjection(c.Paths), nil
+	case "template":
+		return NewTemplateTransformer(c.Template)
+	}
+	return nil, fmt.Errorf("Unknown transformer type: %v", c.Type)
+}
+
+// ParseTransformations builds the named transformer chains declared in the JSON config
+func ParseTransformations(config []byte) (map[string]TransformerChain, error) {
+	var declared map[string][]TransformerConfig
+	if err := json.Unmarshal(config, &declared); err != nil {
+		return nil, err
+	}
+	transformations := make(map[string]TransformerChain)
+	for name, configs := range declared {
+		var chain TransformerChain
+		for _, c := range configs {
+			t, err := c.build()
+			if err != nil {
+				return nil, fmt.Errorf("Invalid transformation %v: %v", name, err)
+			}
+			chain = append(chain, t)
+		}
+		transformations[name] = chain
+	}
+	return transformations, nil
+}
+
+func LoadTransformations(path string) (map[string]TransformerChain, error) {
+	config, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return ParseTransformations(config)
+}
+
+// decodeJSON keeps numbers as json.Number, so large integers are written back without losing precision
+func decodeJSON(payload []byte, v interface{}) error {
+	decoder := json.NewDecoder(bytes.NewReader(payload))
+	decoder.UseNumber()
+	if err := decoder.Decode(v); err != nil {
+		return err
+	}
+	if decoder.More() {
+		return fmt.Errorf("Unexpected data after the JSON document")
+	}
+	return nil
+}
+
+func unmarshalDocument(payload []byte) (map[string]interface{}, error) {
+	var doc map[string]interface{}
+	if err := decodeJSON(payload, &doc); err != nil {
+		return nil, err
+	}
+	if doc == nil {
+		doc = make(map[string]interface{})
+	}
+	return doc, nil
+}
+
+func lookupField(doc map[string]interface{}, keys []string) (interface{}, bool) {
+	value, found := doc[keys[0]]
+	if !found || len(keys) == 1 {
+		return value, found
+	}
+	nested, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, false
+	}
+	return lookupField(nested, keys[1:])
+}
+
+func setField(doc map[string]interface{}, keys []string, value interface{}) {
+	if len(keys) == 1 {
+		doc[keys[0]] = value
+		return
+	}
+	nested, ok := doc[keys[0]].(map[string]interface{})
+	if !ok {
+		nested = make(map[string]interface{})
+		doc[keys[0]] = nested
+	}
+	setField(nested, keys[1:], value)
+}
+
+func deleteField(doc map[string]interface{}, keys []string) {
+	if len(keys) == 1 {
+		delete(doc, keys[0])
+		return
+	}
+	if nested, ok := doc[keys[0]].(map[string]interface{}); ok {
+		deleteField(nested, keys[1:])
+	}
+}
+
+const jsonPathWildcard = "[*]"
+
+// parseJSONPath splits a path like "$.brands[*].id" into the tokens "brands", "[*]" and "id"
+func parseJSONPath(path string) ([]string, error) {
+	if !strings.HasPrefix(path, "$") {
+		return nil, fmt.Errorf("JSONPath %v must start with $", path)
+	}
+	var tokens []string
+	rest := path[1:]
+	for len(rest) > 0 {
+		switch rest[0] {
+		case '.':
+			end := strings.IndexAny(rest[1:], ".[")
+			if end == -1 {
+				end = len(rest) - 1
+			}
+			name := rest[1 : end+1]
+			if name == "" {
+				return nil, fmt.Errorf("JSONPath %v has an empty field name", path)
+			}
+			tokens = append(tokens, name)
+			rest = rest[end+1:]
+		case '[':
+			end := strings.Index(rest, "]")
+			if end == -1 {
+				return nil, fmt.Errorf("JSONPath %v has an unclosed bracket", path)
+			}
+			index := rest[1:end]
+			if index != "*" {
+				if _, err := strconv.Atoi(index); err != nil {
+					return nil, fmt.Errorf("JSONPath %v has an invalid array index %v", path, index)
+				}
+			}
+			tokens = append(tokens, rest[:end+1])
+			rest = rest[end+1:]
+		default:
+			return nil, fmt.Errorf("JSONPath %v is not in dot notation", path)
+		}
+	}
+	return tokens, nil
+}
+
+func evaluateJSONPath(node interface{}, tokens []string) (interface{}, bool) {
+	if len(tokens) == 0 {
+		return node, true
+	}
+	token := tokens[0]
+	if !strings.HasPrefix(token, "[") {
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, found := obj[token]
+		if !found {
+			return nil, false
+		}
+		return evaluateJSONPath(value, tokens[1:])
+	}
+
+	arr, ok := node.([]interface{})
+	if !ok {
+		return nil, false
+	}
+	if token == jsonPathWildcard {
+		results := make([]interface{}, 0, len(arr))
+		for _, item := range arr {
+			if value, found := evaluateJSONPath(item, tokens[1:]); found {
+				results = append(results, value)
+			}
+		}
+		return results, true
+	}
+	i, _ := strconv.Atoi(token[1 : len(token)-1])
+	if i < 0 || i >= len(arr) {
+		return nil, false
+	}
+	return evaluateJSONPath(arr[i], tokens[1:])
+}
@@ -0,0 +1,136 @@
+package content
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testTransformPayload = `{"id":"http://www.ft.com/thing/uuid1","title":"A title","annotations":[{"id":"a1"}],"alternativeTitles":{"promotionalTitle":"Promo","internal":"x"},"brands":[{"id":"b1"},{"id":"b2"}]}`
+
+func TestFieldAllowList(t *testing.T) {
+	result, err := FieldAllowList{"title", "alternativeTitles.promotionalTitle", "missing"}.Transform([]byte(testTransformPayload))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"title":"A title","alternativeTitles":{"promotionalTitle":"Promo"}}`, string(result))
+}
+
+func TestFieldDenyList(t *testing.T) {
+	result, err := FieldDenyList{"annotations", "alternativeTitles.internal", "brands"}.Transform([]byte(testTransformPayload))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"http://www.ft.com/thing/uuid1","title":"A title","alternativeTitles":{"promotionalTitle":"Promo"}}`, string(result))
+}
+
+func TestFieldRenamer(t *testing.T) {
+	result, err := FieldRenamer{{From: "title", To: "headline"}, {From: "alternativeTitles.promotionalTitle", To: "promo.title"}}.Transform([]byte(`{"title":"A title","alternativeTitles":{"promotionalTitle":"Promo"}}`))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"headline":"A title","alternativeTitles":{},"promo":{"title":"Promo"}}`, string(result))
+}
+
+func TestFieldRenamerAppliesChainedRenamesInOrder(t *testing.T) {
+	renamer := FieldRenamer{{From: "b", To: "c"}, {From: "a", To: "b"}}
+	for i := 0; i < 20; i++ {
+		result, err := renamer.Transform([]byte(`{"a":"A","b":"B"}`))
+		require.NoError(t, err)
+		assert.JSONEq(t, `{"b":"A","c":"B"}`, string(result))
+	}
+}
+
+func TestTransformersKeepLargeIntegers(t *testing.T) {
+	payload := []byte(`{"id":9007199254740993,"nested":{"size":12345678901234567890},"ratio":0.5}`)
+	result, err := FieldDenyList{"missing"}.Transform(payload)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":9007199254740993,"nested":{"size":12345678901234567890},"ratio":0.5}`, string(result))
+
+	result, err = JSONPathProjection{"id": "$.id"}.Transform(payload)
+	require.NoError(t, err)
+	assert.Equal(t, `{"id":9007199254740993}`, string(result))
+}
+
+func TestJSONPathProjection(t *testing.T) {
+	result, err := JSONPathProjection{
+		"id":          "$.id",
+		"brandIds":    "$.brands[*].id",
+		"firstBrand":  "$.brands[0].id",
+		"promo.title": "$.alternativeTitles.promotionalTitle",
+		"missing":     "$.brands[5].id",
+	}.Transform([]byte(testTransformPayload))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"http://www.ft.com/thing/uuid1","brandIds":["b1","b2"],"firstBrand":"b1","promo":{"title":"Promo"}}`, string(result))
+}
+
+func TestParseJSONPathErrors(t *testing.T) {
+	var tests = []struct {
+		path string
+		err  string
+	}{
+		{"brands", "JSONPath brands must start with $"},
+		{"$.brands[", "JSONPath $.brands[ has an unclosed bracket"},
+		{"$.brands[x]", "JSONPath $.brands[x] has an invalid array index x"},
+		{"$..id", "JSONPath $..id has an empty field name"},
+		{"$brands", "JSONPath $brands is not in dot notation"},
+	}
+	for _, test := range tests {
+		_, err := parseJSONPath(test.path)
+		assert.EqualError(t, err, test.err)
+	}
+}
+
+func TestTemplateTransformer(t *testing.T) {
+	transformer, err := NewTemplateTransformer(`{"id": {{json .id}}, "headline": {{json .title}}, "brandCount": {{len .brands}}}`)
+	require.NoError(t, err)
+
+	result, err := transformer.Transform([]byte(testTransformPayload))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"id":"http://www.ft.com/thing/uuid1","headline":"A title","brandCount":2}`, string(result))
+}
+
+func TestTemplateTransformerWithInvalidOutput(t *testing.T) {
+	transformer, err := NewTemplateTransformer(`{"id": {{.id}}}`)
+	require.NoError(t, err)
+
+	_, err = transformer.Transform([]byte(testTransformPayload))
+	assert.EqualError(t, err, "Template output is not valid JSON")
+}
+
+func TestTransformerChainAppliesTransformersInOrder(t *testing.T) {
+	chain := TransformerChain{FieldRenamer{{From: "title", To: "headline"}}, FieldAllowList{"headline"}}
+	result, err := chain.Transform([]byte(testTransformPayload))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"headline":"A title"}`, string(result))
+}
+
+func TestTransformerChainFailsOnInvalidPayload(t *testing.T) {
+	chain := TransformerChain{FieldDenyList{"annotations"}}
+	_, err := chain.Transform([]byte("not json"))
+	assert.Error(t, err)
+}
+
+func TestParseTransformations(t *testing.T) {
+	config := `{
+		"partner-a": [
+			{"type": "deny", "fields": ["annotations"]},
+			{"type": "rename", "renames": [{"from": "title", "to": "headline"}]},
+			{"type": "project", "paths": {"headline": "$.headline"}}
+		],
+		"partner-b": [
+			{"type": "template", "template": "{\"id\": {{json .id}}}"}
+		]
+	}`
+	transformations, err := ParseTransformations([]byte(config))
+	require.NoError(t, err)
+	assert.Len(t, transformations, 2)
+	assert.Len(t, transformations["partner-a"], 3)
+
+	result, err := transformations["partner-a"].Transform([]byte(testTransformPayload))
+	require.NoError(t, err)
+	assert.JSONEq(t, `{"headline":"A title"}`, string(result))
+}
+
+func TestParseTransformationsWithInvalidConfig(t *testing.T) {
+	_, err := ParseTransformations([]byte(`{"partner-a": [{"type": "unknown"}]}`))
+	assert.EqualError(t, err, "Invalid transformation partner-a: Unknown transformer type: unknown")
+
+	_, err = ParseTransformations([]byte(`{"partner-a": [{"type": "project", "paths": {"id": "id"}}]}`))
+	assert.EqualError(t, err, "Invalid transformation partner-a: JSONPath id must start with $")
+}
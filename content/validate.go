@@ -0,0 +1,95 @@
+package content
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/xeipuuv/gojsonschema"
+)
+
+// Validator checks fetched content before it gets uploaded
+type Validator interface {
+	Validate(payload []byte) error
+}
+
+// ValidationError is returned for content which does not conform to the schema of its type
+type ValidationError struct {
+	ContentType string
+	Errors      []string
+}
+
+func (e *ValidationError) Error() string {
+	if e.ContentType == "" {
+		// Malformed content has no type to report
+		return fmt.Sprintf("Content of unknown type is invalid: %v", strings.Join(e.Errors, "; "))
+	}
+	return fmt.Sprintf("Content of type %v is invalid: %v", e.ContentType, strings.Join(e.Errors, "; "))
+}
+
+// SchemaValidator validates content against the JSON Schema configured for its type.
+// Content of types without a schema is considered valid
+type SchemaValidator struct {
+	schemas map[string]*gojsonschema.Schema
+}
+
+func NewSchemaValidator(schemas map[string][]byte) (*SchemaValidator, error) {
+	v := &SchemaValidator{schemas: make(map[string]*gojsonschema.Schema)}
+	for contentType, schema := range schemas {
+		s, err := gojsonschema.NewSchema(gojsonschema.NewBytesLoader(schema))
+		if err != nil {
+			return nil, fmt.Errorf("Invalid schema for content type %v: %v", contentType, err)
+		}
+		v.schemas[contentType] = s
+	}
+	return v, nil
+}
+
+// LoadSchemaValidator reads the schemas from the directory, each file being named after the content type it applies to, e.g. Article.json
+func LoadSchemaValidator(dir string) (*SchemaValidator, error) {
+	files, err := filepath.Glob(filepath.Join(dir, "*.json"))
+	if err != nil {
+		return nil, err
+	}
+	schemas := make(map[string][]byte)
+	for _, file := range files {
+		schema, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		schemas[strings.TrimSuffix(filepath.Base(file), ".json")] = schema
+	}
+	return NewSchemaValidator(schemas)
+}
+
+func (v *SchemaValidator) Validate(payload []byte) error {
+	var doc map[string]interface{}
+	if err := json.Unmarshal(payload, &doc); err != nil {
+		return &ValidationError{Errors: []string{err.Error()}}
+	}
+	contentType := getContentType(doc)
+	schema, found := v.schemas[contentType]
+	if !found {
+		return nil
+	}
+	result, err := schema.Validate(gojsonschema.NewGoLoader(doc))
+	if err != nil {
+		return err
+	}
+	if result.Valid() {
+		return nil
+	}
+	validationErr := &ValidationError{ContentType: contentType}
+	for _, e := range result.Errors() {
+		validationErr.Errors = append(validationErr.Errors, e.String())
+	}
+	return validationErr
+}
+
+// getContentType returns the last segment of the type URI, e.g. Article for http://www.ft.com/ontology/content/Article
+func getContentType(doc map[string]interface{}) string {
+	t, _ := doc["type"].(string)
+	return t[strings.LastIndex(t, "/")+1:]
+}
@@ -0,0 +1,75 @@
+package content
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testArticleSchema = `{
+	"type": "object",
+	"required": ["id", "title"],
+	"properties": {
+		"id": {"type": "string"},
+		"title": {"type": "string", "minLength": 1}
+	}
+}`
+
+func TestSchemaValidatorValidContent(t *testing.T) {
+	validator, err := NewSchemaValidator(map[string][]byte{"Article": []byte(testArticleSchema)})
+	require.NoError(t, err)
+
+	err = validator.Validate([]byte(`{"type":"http://www.ft.com/ontology/content/Article","id":"uuid1","title":"A title"}`))
+	assert.NoError(t, err)
+}
+
+func TestSchemaValidatorInvalidContent(t *testing.T) {
+	validator, err := NewSchemaValidator(map[string][]byte{"Article": []byte(testArticleSchema)})
+	require.NoError(t, err)
+
+	err = validator.Validate([]byte(`{"type":"http://www.ft.com/ontology/content/Article","id":"uuid1"}`))
+	require.Error(t, err)
+	validationErr, ok := err.(*ValidationError)
+	require.True(t, ok)
+	assert.Equal(t, "Article", validationErr.ContentType)
+	assert.Equal(t, []string{"(root): title is required"}, validationErr.Errors)
+	assert.Equal(t, "Content of type Article is invalid: (root): title is required", err.Error())
+}
+
+func TestSchemaValidatorMalformedContent(t *testing.T) {
+	validator, err := NewSchemaValidator(map[string][]byte{"Article": []byte(testArticleSchema)})
+	require.NoError(t, err)
+
+	err = validator.Validate([]byte(`{"type":"http://www.ft.com/ontology/content/Article",`))
+	assert.IsType(t, &ValidationError{}, err)
+	assert.Contains(t, err.Error(), "Content of unknown type is invalid: ")
+}
+
+func TestSchemaValidatorContentTypeWithoutSchema(t *testing.T) {
+	validator, err := NewSchemaValidator(map[string][]byte{"Article": []byte(testArticleSchema)})
+	require.NoError(t, err)
+
+	err = validator.Validate([]byte(`{"type":"http://www.ft.com/ontology/content/Audio"}`))
+	assert.NoError(t, err)
+}
+
+func TestNewSchemaValidatorWithInvalidSchema(t *testing.T) {
+	_, err := NewSchemaValidator(map[string][]byte{"Article": []byte(`{"type": 5}`)})
+	assert.Error(t, err)
+}
+
+func TestLoadSchemaValidator(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-exporter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	require.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Article.json"), []byte(testArticleSchema), 0600))
+
+	validator, err := LoadSchemaValidator(dir)
+	require.NoError(t, err)
+	assert.Len(t, validator.schemas, 1)
+	assert.Error(t, validator.Validate([]byte(`{"type":"Article"}`)))
+}
@@ -22,6 +22,8 @@ type Service interface {
 // TX contains database transaction functions
 type TX interface {
 	FindUUIDs(collectionId string, candidates []string) (Iterator, int, error)
+	FindContent(collectionId string, uuid string) (map[string]interface{}, error)
+	FindContents(collectionId string, uuids []string) ([]map[string]interface{}, error)
 	Ping(ctx context.Context) error
 	Close()
 }
@@ -84,6 +86,32 @@ func (tx *MongoTX) FindUUIDs(collectionID string, candidates []string) (Iterator
 	return iter, count, err
 }
 
+// FindContent returns the raw stored document, without the mongo internal _id
+func (tx *MongoTX) FindContent(collectionID string, uuid string) (map[string]interface{}, error) {
+	collection := tx.session.DB("upp-store").C(collectionID)
+
+	var result map[string]interface{}
+	if err := collection.Find(bson.M{"uuid": uuid}).One(&result); err != nil {
+		return nil, err
+	}
+	delete(result, "_id")
+	return result, nil
+}
+
+// FindContents returns the raw stored documents found for the uuids, without the mongo internal _id
+func (tx *MongoTX) FindContents(collectionID string, uuids []string) ([]map[string]interface{}, error) {
+	collection := tx.session.DB("upp-store").C(collectionID)
+
+	var results []map[string]interface{}
+	if err := collection.Find(bson.M{"uuid": bson.M{"$in": uuids}}).All(&results); err != nil {
+		return nil, err
+	}
+	for _, result := range results {
+		delete(result, "_id")
+	}
+	return results, nil
+}
+
 // Ping returns a mongo ping response
 func (tx *MongoTX) Ping(ctx context.Context) error {
 	ping := make(chan error, 1)
@@ -233,6 +233,52 @@ func TestFindUUIDsWithCandidate(t *testing.T) {
 	assert.Equal(t, testUUID1, result["uuid"].(string))
 }
 
+func TestFindContent(t *testing.T) {
+	mongo := startMongo(t)
+	defer mongo.Close()
+	tx, err := mongo.Open()
+	defer tx.Close()
+	assert.NoError(t, err)
+
+	testUUID := uuid.NewUUID().String()
+	t.Log("Test uuid to use", testUUID)
+	testContent := make(map[string]interface{})
+
+	testContent["uuid"] = testUUID
+	testContent["title"] = "A title"
+	insertTestContent(t, mongo.(*MongoDB), testContent)
+	defer cleanupTestContent(t, mongo.(*MongoDB), testUUID)
+
+	result, err := tx.FindContent("testing", testUUID)
+	require.NoError(t, err)
+	assert.Equal(t, testUUID, result["uuid"])
+	assert.Equal(t, "A title", result["title"])
+	assert.NotContains(t, result, "_id")
+}
+
+func TestFindContents(t *testing.T) {
+	mongo := startMongo(t)
+	defer mongo.Close()
+	tx, err := mongo.Open()
+	defer tx.Close()
+	assert.NoError(t, err)
+
+	testUUID1 := uuid.NewUUID().String()
+	testUUID2 := uuid.NewUUID().String()
+	t.Log("Test uuids to use: ", testUUID1, testUUID2)
+	insertTestContent(t, mongo.(*MongoDB), map[string]interface{}{"uuid": testUUID1})
+	insertTestContent(t, mongo.(*MongoDB), map[string]interface{}{"uuid": testUUID2})
+	defer cleanupTestContent(t, mongo.(*MongoDB), testUUID1, testUUID2)
+
+	results, err := tx.FindContents("testing", []string{testUUID1, testUUID2, uuid.NewUUID().String()})
+	require.NoError(t, err)
+	require.Len(t, results, 2)
+	for _, result := range results {
+		assert.Contains(t, []string{testUUID1, testUUID2}, result["uuid"])
+		assert.NotContains(t, result, "_id")
+	}
+}
+
 func insertTestContent(t *testing.T, mongo *MongoDB, testContent map[string]interface{}) {
 	session := mongo.session.Copy()
 	defer session.Close()
@@ -1,6 +1,8 @@
 package export
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"sync"
 	"time"
@@ -13,36 +15,74 @@ type Service struct {
 	sync.RWMutex
 	jobs                  map[string]*Job
 	NrOfConcurrentWorkers int
+	BatchSize             int
 	*content.Exporter
+	// Breakers of the upstreams the jobs depend on. The jobs pause while any of them is open
+	Breakers []*content.CircuitBreaker
+	ctx      context.Context
+	cancel   context.CancelFunc
 }
 
+// ErrJobNotFound is returned for an unknown job ID
+var ErrJobNotFound = errors.New("Job not found")
+
+// ErrJobNotRunning is returned when a job which has already finished or been cancelled is changed
+var ErrJobNotRunning = errors.New("Job is not running")
+
 type State string
 
 const (
-	STARTING State = "Starting"
-	RUNNING  State = "Running"
-	FINISHED State = "Finished"
+	STARTING  State = "Starting"
+	RUNNING   State = "Running"
+	PAUSED    State = "Paused"
+	FINISHED  State = "Finished"
+	CANCELLED State = "Cancelled"
 )
 
 type Job struct {
 	sync.RWMutex
 	wg                       sync.WaitGroup
-	NrWorker                 int               `json:"-"`
+	cancel                   context.CancelFunc
+	breakers                 []*content.CircuitBreaker
+	workers                  *Semaphore
+	NrWorker                 int               `json:"NrWorker,omitempty"`
+	BatchSize                int               `json:"-"`
 	DocIds                   chan content.Stub `json:"-"`
 	ID                       string            `json:"ID"`
 	Count                    int               `json:"Count,omitempty"`
 	Progress                 int               `json:"Progress,omitempty"`
+	Unchanged                int               `json:"Unchanged,omitempty"`
 	Failed                   []string          `json:"Failed,omitempty"`
+	Failures                 []Failure         `json:"Failures,omitempty"`
+	Invalid                  []string          `json:"Invalid,omitempty"`
+	Skipped                  []string          `json:"Skipped,omitempty"`
 	Status                   State             `json:"Status"`
 	ErrorMessage             string            `json:"ErrorMessage,omitempty"`
-	ContentRetrievalThrottle int               `json:"-"`
+	PausedBy                 []string          `json:"PausedBy,omitempty"`
+	ContentEncoding          string            `json:"ContentEncoding,omitempty"`
+	Transformation           string            `json:"Transformation,omitempty"`
+	Source                   string            `json:"Source,omitempty"`
+	XPolicies                string            `json:"XPolicies,omitempty"`
+	ReplaySince              string            `json:"ReplaySince,omitempty"`
+	ContentRetrievalThrottle int               `json:"ContentRetrievalThrottle,omitempty"`
+}
+
+// Failure records why a doc could not be exported and how many calls were made to the upstreams before giving up
+type Failure struct {
+	Uuid     string `json:"uuid"`
+	Error    string `json:"error"`
+	Attempts int    `json:"attempts,omitempty"`
 }
 
-func NewFullExporter(nrOfWorkers int, exporter *content.Exporter) *Service {
+func NewFullExporter(nrOfWorkers, batchSize int, exporter *content.Exporter) *Service {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &Service{
 		jobs: make(map[string]*Job),
 		NrOfConcurrentWorkers: nrOfWorkers,
+		BatchSize:             batchSize,
 		Exporter:              exporter,
+		ctx:                   ctx,
+		cancel:                cancel,
 	}
 }
 
@@ -51,7 +91,7 @@ func (fe *Service) GetRunningJobs() []Job {
 	defer fe.RUnlock()
 	var jobs []Job
 	for _, job := range fe.jobs {
-		if job.Status == RUNNING {
+		if job.Status == RUNNING || job.Status == PAUSED {
 			jobs = append(jobs, job.Copy())
 		}
 	}
@@ -63,59 +103,328 @@ func (fe *Service) GetJob(jobID string) (Job, error) {
 	defer fe.RUnlock()
 	job, ok := fe.jobs[jobID]
 	if !ok {
-		return Job{}, fmt.Errorf("Job %v not found", jobID)
+		return Job{}, fmt.Errorf("%w: %v", ErrJobNotFound, jobID)
 	}
 	return job.Copy(), nil
 }
 
-func (fe *Service) AddJob(job *Job) {
-	if job != nil {
-		fe.Lock()
-		fe.jobs[job.ID] = job
-		fe.Unlock()
+// AddJob registers the job and returns the context it should run in.
+// The context is cancelled when the job is cancelled or the service is shut down
+func (fe *Service) AddJob(job *Job) context.Context {
+	if job == nil {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(fe.ctx)
+	job.Lock()
+	job.cancel = cancel
+	job.breakers = fe.Breakers
+	job.Unlock()
+	fe.Lock()
+	fe.jobs[job.ID] = job
+	fe.Unlock()
+	return ctx
+}
+
+func (fe *Service) CancelJob(jobID string) error {
+	fe.RLock()
+	job, ok := fe.jobs[jobID]
+	fe.RUnlock()
+	if !ok {
+		return fmt.Errorf("%w: %v", ErrJobNotFound, jobID)
+	}
+	job.RLock()
+	defer job.RUnlock()
+	if job.Status == FINISHED || job.Status == CANCELLED {
+		return fmt.Errorf("%w: %v", ErrJobNotRunning, jobID)
+	}
+	if job.cancel != nil {
+		job.cancel()
+	}
+	return nil
+}
+
+// Release cancels the context of the job once it has ended, so it is not kept under the context of the service
+func (job *Job) Release() {
+	job.Lock()
+	defer job.Unlock()
+	if job.cancel != nil {
+		job.cancel()
+		job.cancel = nil
 	}
 }
 
+// Shutdown cancels all the jobs
+func (fe *Service) Shutdown() {
+	fe.cancel()
+}
+
 func (job *Job) Copy() Job {
 	job.Lock()
 	defer job.Unlock()
 	return Job{
-		Progress: job.Progress,
-		Status:   job.Status,
-		ID:       job.ID,
-		Count:    job.Count,
-		Failed:   job.Failed,
+		Progress:                 job.Progress,
+		Status:                   job.Status,
+		PausedBy:                 job.PausedBy,
+		ID:                       job.ID,
+		Count:                    job.Count,
+		Unchanged:                job.Unchanged,
+		Failed:                   failedUuids(job.Failures),
+		Failures:                 job.Failures,
+		Invalid:                  job.Invalid,
+		Skipped:                  job.Skipped,
+		ContentEncoding:          job.ContentEncoding,
+		Transformation:           job.Transformation,
+		Source:                   job.Source,
+		XPolicies:                job.XPolicies,
+		ReplaySince:              job.ReplaySince,
+		NrWorker:                 job.NrWorker,
+		ContentRetrievalThrottle: job.ContentRetrievalThrottle,
+	}
+}
+
+// AdjustJob changes the number of workers and the throttle of a running job. Nil values are left unchanged
+func (fe *Service) AdjustJob(jobID string, nrWorker, contentRetrievalThrottle *int) (Job, error) {
+	fe.RLock()
+	job, ok := fe.jobs[jobID]
+	fe.RUnlock()
+	if !ok {
+		return Job{}, fmt.Errorf("%w: %v", ErrJobNotFound, jobID)
+	}
+	job.Lock()
+	if job.Status == FINISHED || job.Status == CANCELLED {
+		job.Unlock()
+		return Job{}, fmt.Errorf("%w: %v", ErrJobNotRunning, jobID)
 	}
+	if nrWorker != nil {
+		job.NrWorker = *nrWorker
+		if job.workers != nil {
+			job.workers.Resize(*nrWorker)
+		}
+	}
+	if contentRetrievalThrottle != nil {
+		job.ContentRetrievalThrottle = *contentRetrievalThrottle
+	}
+	log.Infof("Job %v adjusted to %v worker(s) and %v ms throttle", job.ID, job.NrWorker, job.ContentRetrievalThrottle)
+	job.Unlock()
+	return job.Copy(), nil
 }
 
-func (job *Job) RunFullExport(tid string, export func(string, content.Stub) error) {
+func (job *Job) RunFullExport(ctx context.Context, tid string, export func(context.Context, string, []content.Stub) map[string]error) {
+	defer job.Release()
 	log.Infof("Job started: %v", job.ID)
+	job.Lock()
 	job.Status = RUNNING
-	worker := make(chan struct{}, job.NrWorker)
+	job.workers = NewSemaphore(job.NrWorker)
+	job.Unlock()
 	for {
-		doc, ok := <-job.DocIds
-		if !ok {
+		batch := job.nextBatch(ctx)
+		if ctx.Err() != nil {
+			job.cancelled()
+			return
+		}
+		if len(batch) == 0 {
 			job.wg.Wait()
+			job.Lock()
 			job.Status = FINISHED
-			log.Infof("Finished job %v with %v failure(s), progress: %v", job.ID, len(job.Failed), job.Progress)
-			close(worker)
+			job.Unlock()
+			log.Infof("Finished job %v with %v failure(s), %v invalid, %v unchanged, progress: %v", job.ID, len(job.Failures), len(job.Invalid), job.Unchanged, job.Progress)
+			return
+		}
+		if !job.waitForBreakers(ctx) {
+			job.cancelled()
 			return
 		}
 
-		worker <- struct{}{} // Will block until worker is available to span up new goroutines
+		if err := job.workers.Acquire(ctx); err != nil { // Will block until worker is available to span up new goroutines
+			job.cancelled()
+			return
+		}
 
-		job.Progress++
 		job.wg.Add(1)
 		go func() {
 			defer job.wg.Done()
-			time.Sleep(time.Duration(job.ContentRetrievalThrottle) * time.Millisecond)
-			if err := export(tid, doc); err != nil {
-				log.WithField("transaction_id", tid).WithField("uuid", doc.Uuid).Error(err)
+			defer job.workers.Release()
+			// The throttle applies per doc, whatever the size of the batch
+			select {
+			case <-time.After(job.throttle() * time.Duration(len(batch))):
+			case <-ctx.Done():
+				return
+			}
+			errs := export(ctx, tid, batch)
+			for _, doc := range batch {
+				if ctx.Err() != nil {
+					return
+				}
 				job.Lock()
-				job.Failed = append(job.Failed, doc.Uuid)
+				job.Progress++
 				job.Unlock()
+				job.record(ctx, tid, doc, errs[doc.Uuid])
 			}
-			<-worker
 		}()
 	}
 }
+
+// ReplayFunc handles the items of a replay job. It calls ready before handling each item, which blocks while the job
+// is paused and returns false once it is cancelled, and done with the outcome of each item
+type ReplayFunc func(ctx context.Context, ready func() bool, done func(tid string, doc content.Stub, err error)) error
+
+// RunReplay runs a job whose items are produced by the replay itself, like the notifications replayed from Kafka
+func (job *Job) RunReplay(ctx context.Context, replay ReplayFunc) {
+	defer job.Release()
+	log.Infof("Replay job started: %v", job.ID)
+	job.Lock()
+	job.Status = RUNNING
+	job.Unlock()
+	ready := func() bool {
+		return ctx.Err() == nil && job.waitForBreakers(ctx)
+	}
+	done := func(tid string, doc content.Stub, err error) {
+		job.Lock()
+		job.Progress++
+		job.Unlock()
+		job.record(ctx, tid, doc, err)
+	}
+	err := replay(ctx, ready, done)
+	if ctx.Err() != nil {
+		job.cancelled()
+		return
+	}
+	job.Lock()
+	defer job.Unlock()
+	if err != nil {
+		job.ErrorMessage = err.Error()
+		log.WithError(err).Errorf("Replay job %v failed", job.ID)
+	}
+	job.Status = FINISHED
+	log.Infof("Finished replay job %v with %v failure(s), %v unchanged, progress: %v", job.ID, len(job.Failures), job.Unchanged, job.Progress)
+}
+
+func (job *Job) throttle() time.Duration {
+	job.RLock()
+	defer job.RUnlock()
+	return time.Duration(job.ContentRetrievalThrottle) * time.Millisecond
+}
+
+// waitForBreakers pauses the job while any of the breakers is open, returning false if the job is cancelled meanwhile
+func (job *Job) waitForBreakers(ctx context.Context) bool {
+	for {
+		open := content.OpenBreakers(job.breakers)
+		job.Lock()
+		if len(open) == 0 {
+			if job.Status == PAUSED {
+				log.Infof("Job %v resumed", job.ID)
+				job.Status = RUNNING
+				job.PausedBy = nil
+			}
+			job.Unlock()
+			return true
+		}
+		if job.Status != PAUSED {
+			log.Warnf("Job %v paused, circuit breaker of %v is open", job.ID, open)
+			job.Status = PAUSED
+		}
+		job.PausedBy = open
+		job.Unlock()
+
+		select {
+		case <-time.After(content.BreakerPollInterval):
+		case <-ctx.Done():
+			return false
+		}
+	}
+}
+
+// nextBatch reads up to BatchSize docs. An empty batch means there are no more docs to export
+func (job *Job) nextBatch(ctx context.Context) []content.Stub {
+	size := job.BatchSize
+	if size < 1 {
+		size = 1
+	}
+	batch := make([]content.Stub, 0, size)
+	for len(batch) < size {
+		select {
+		case doc, ok := <-job.DocIds:
+			if !ok {
+				return batch
+			}
+			batch = append(batch, doc)
+		case <-ctx.Done():
+			return batch
+		}
+	}
+	return batch
+}
+
+func (job *Job) record(ctx context.Context, tid string, doc content.Stub, err error) {
+	var validationErr *content.ValidationError
+	if err == content.ErrUnchanged {
+		job.Lock()
+		job.Unchanged++
+		job.Unlock()
+	} else if errors.As(err, &validationErr) {
+		log.WithField("transaction_id", tid).WithField("uuid", doc.Uuid).Warn(err)
+		job.Lock()
+		job.Invalid = append(job.Invalid, doc.Uuid)
+		job.Unlock()
+	} else if content.IsInaccessible(err) || err == content.ErrNotDistributable {
+		log.WithField("transaction_id", tid).WithField("uuid", doc.Uuid).Warn(err)
+		job.Lock()
+		job.Skipped = append(job.Skipped, doc.Uuid)
+		job.Unlock()
+	} else if err != nil {
+		if ctx.Err() != nil {
+			return
+		}
+		failure := Failure{Uuid: doc.Uuid, Error: err.Error()}
+		var attemptsErr *content.AttemptsError
+		if errors.As(err, &attemptsErr) {
+			failure.Attempts = attemptsErr.Attempts
+		}
+		log.WithField("transaction_id", tid).WithField("uuid", doc.Uuid).WithField("attempts", failure.Attempts).Error(err)
+		job.Lock()
+		job.Failures = append(job.Failures, failure)
+		job.Unlock()
+	}
+}
+
+// WriteManifest stores the manifest of the finished or cancelled job in the sink, if the sink keeps manifests
+func (job *Job) WriteManifest(tid string, updater content.Updater) {
+	writer, ok := updater.(content.ManifestWriter)
+	if !ok {
+		return
+	}
+	job.RLock()
+	manifest := content.Manifest{
+		JobID:           job.ID,
+		Status:          string(job.Status),
+		ContentEncoding: content.Encoding(job.ContentEncoding),
+		Transformation:  job.Transformation,
+		Source:          job.Source,
+		XPolicies:       job.XPolicies,
+		Count:           job.Count,
+		Progress:        job.Progress,
+		Failed:          len(job.Failures),
+		Finished:        time.Now().UTC(),
+	}
+	job.RUnlock()
+	if err := writer.WriteManifest(context.Background(), tid, manifest); err != nil {
+		log.WithField("transaction_id", tid).WithError(err).Errorf("Failed to write manifest of job %v", job.ID)
+	}
+}
+
+// failedUuids lists the UUIDs of the failures, which the copies of a job expose as Failed
+func failedUuids(failures []Failure) []string {
+	var uuids []string
+	for _, f := range failures {
+		uuids = append(uuids, f.Uuid)
+	}
+	return uuids
+}
+
+func (job *Job) cancelled() {
+	job.wg.Wait()
+	job.Lock()
+	job.Status = CANCELLED
+	job.Unlock()
+	log.Infof("Cancelled job %v with %v failure(s), %v invalid, %v unchanged, progress: %v", job.ID, len(job.Failures), len(job.Invalid), job.Unchanged, job.Progress)
+}
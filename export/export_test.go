@@ -0,0 +1,337 @@
+package export
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func waitFor(t *testing.T, condition func() bool, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// newTestJob returns a job exporting the given docs one by one
+func newTestJob(id string, nrWorker int, uuids ...string) *Job {
+	docs := make(chan content.Stub, len(uuids))
+	for _, uuid := range uuids {
+		docs <- content.Stub{Uuid: uuid, Date: content.DefaultDate}
+	}
+	close(docs)
+	return &Job{ID: id, NrWorker: nrWorker, BatchSize: 1, DocIds: docs, Count: len(uuids), Status: STARTING}
+}
+
+// blockingExport holds each export until it is released, telling how many are running
+type blockingExport struct {
+	started chan string
+	release chan struct{}
+}
+
+func newBlockingExport() *blockingExport {
+	return &blockingExport{started: make(chan string, 10), release: make(chan struct{})}
+}
+
+func (b *blockingExport) export(ctx context.Context, tid string, docs []content.Stub) map[string]error {
+	b.started <- docs[0].Uuid
+	select {
+	case <-b.release:
+	case <-ctx.Done():
+	}
+	return nil
+}
+
+func (b *blockingExport) assertStarted(t *testing.T, count int) {
+	for i := 0; i < count; i++ {
+		select {
+		case <-b.started:
+		case <-time.After(2 * time.Second):
+			t.Fatalf("Export %v did not start", i+1)
+		}
+	}
+	select {
+	case uuid := <-b.started:
+		t.Fatalf("Unexpected export of %v", uuid)
+	case <-time.After(100 * time.Millisecond):
+	}
+}
+
+func acquired(s *Semaphore) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	return s.Acquire(ctx) == nil
+}
+
+func TestSemaphoreGrowsWhileSlotsAreHeld(t *testing.T) {
+	s := NewSemaphore(1)
+	require.True(t, acquired(s))
+	assert.False(t, acquired(s))
+
+	waiting := make(chan error)
+	go func() { waiting <- s.Acquire(context.Background()) }()
+	s.Resize(2)
+	select {
+	case err := <-waiting:
+		assert.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Waiting worker did not get the new slot")
+	}
+	assert.Equal(t, 2, s.Size())
+	assert.False(t, acquired(s))
+}
+
+func TestSemaphoreShrinksWhileSlotsAreHeld(t *testing.T) {
+	s := NewSemaphore(3)
+	for i := 0; i < 3; i++ {
+		require.True(t, acquired(s))
+	}
+	s.Resize(1)
+	assert.Equal(t, 1, s.Size())
+
+	// The running workers finish, no new one starts until fewer than the new size are running
+	s.Release()
+	assert.False(t, acquired(s))
+	s.Release()
+	assert.False(t, acquired(s))
+	s.Release()
+	assert.True(t, acquired(s))
+	assert.False(t, acquired(s))
+}
+
+func TestAdjustJobGrowsWorkersOfRunningJob(t *testing.T) {
+	service := NewFullExporter(1, 1, nil)
+	job := newTestJob("job1", 1, "uuid1", "uuid2", "uuid3")
+	ctx := service.AddJob(job)
+	export := newBlockingExport()
+	done := make(chan struct{})
+	go func() {
+		job.RunFullExport(ctx, "tid_1", export.export)
+		close(done)
+	}()
+	export.assertStarted(t, 1)
+
+	nrWorker := 3
+	adjusted, err := service.AdjustJob("job1", &nrWorker, nil)
+	require.NoError(t, err)
+	assert.Equal(t, 3, adjusted.NrWorker)
+	export.assertStarted(t, 2)
+
+	close(export.release)
+	<-done
+	finished, err := service.GetJob("job1")
+	require.NoError(t, err)
+	assert.Equal(t, FINISHED, finished.Status)
+	assert.Equal(t, 3, finished.Progress)
+}
+
+func TestAdjustJobShrinksWorkersOfRunningJob(t *testing.T) {
+	service := NewFullExporter(1, 1, nil)
+	job := newTestJob("job1", 2, "uuid1", "uuid2", "uuid3", "uuid4")
+	ctx := service.AddJob(job)
+	export := newBlockingExport()
+	done := make(chan struct{})
+	go func() {
+		job.RunFullExport(ctx, "tid_1", export.export)
+		close(done)
+	}()
+	export.assertStarted(t, 2)
+
+	nrWorker := 1
+	_, err := service.AdjustJob("job1", &nrWorker, nil)
+	require.NoError(t, err)
+	export.release <- struct{}{}
+	export.assertStarted(t, 0)
+	export.release <- struct{}{}
+	export.assertStarted(t, 1)
+
+	close(export.release)
+	<-done
+}
+
+func TestAdjustJobOfStartingJobAppliesToItsRun(t *testing.T) {
+	service := NewFullExporter(1, 1, nil)
+	job := newTestJob("job1", 1, "uuid1", "uuid2", "uuid3")
+	ctx := service.AddJob(job)
+
+	nrWorker, throttle := 3, 1
+	adjusted, err := service.AdjustJob("job1", &nrWorker, &throttle)
+	require.NoError(t, err)
+	assert.Equal(t, STARTING, adjusted.Status)
+	assert.Equal(t, 3, adjusted.NrWorker)
+	assert.Equal(t, 1, adjusted.ContentRetrievalThrottle)
+
+	export := newBlockingExport()
+	done := make(chan struct{})
+	go func() {
+		job.RunFullExport(ctx, "tid_1", export.export)
+		close(done)
+	}()
+	export.assertStarted(t, 3)
+	close(export.release)
+	<-done
+}
+
+func TestAdjustJobOfEndedJob(t *testing.T) {
+	service := NewFullExporter(1, 1, nil)
+	finished := newTestJob("finished", 1)
+	service.AddJob(finished)
+	finished.RunFullExport(context.Background(), "tid_1", newBlockingExport().export)
+
+	cancelled := newTestJob("cancelled", 1, "uuid1")
+	ctx := service.AddJob(cancelled)
+	require.NoError(t, service.CancelJob("cancelled"))
+	cancelled.RunFullExport(ctx, "tid_1", newBlockingExport().export)
+
+	nrWorker := 2
+	for id, status := range map[string]State{"finished": FINISHED, "cancelled": CANCELLED} {
+		job, err := service.GetJob(id)
+		require.NoError(t, err)
+		require.Equal(t, status, job.Status)
+
+		_, err = service.AdjustJob(id, &nrWorker, nil)
+		assert.True(t, errors.Is(err, ErrJobNotRunning), id)
+		job, _ = service.GetJob(id)
+		assert.Equal(t, 1, job.NrWorker, id)
+	}
+
+	_, err := service.AdjustJob("unknown", &nrWorker, nil)
+	assert.True(t, errors.Is(err, ErrJobNotFound))
+}
+
+// openBreaker returns a breaker of the upstream which stays open for the timeout
+func openBreaker(name string, openTimeout time.Duration) *content.CircuitBreaker {
+	breaker := content.NewCircuitBreaker(name, 1, openTimeout)
+	breaker.Failure()
+	return breaker
+}
+
+// jobStatus returns a function reading the current copy of the job
+func jobStatus(t *testing.T, service *Service, id string) func() *Job {
+	return func() *Job {
+		job, err := service.GetJob(id)
+		require.NoError(t, err)
+		return &job
+	}
+}
+
+// replayOne replays a single doc once the job is ready
+func replayOne(ctx context.Context, ready func() bool, done func(tid string, doc content.Stub, err error)) error {
+	if !ready() {
+		return ctx.Err()
+	}
+	done("tid_1", content.Stub{Uuid: "uuid1"}, nil)
+	return nil
+}
+
+func TestRunFullExportPausesWhileBreakerIsOpen(t *testing.T) {
+	service := NewFullExporter(1, 1, nil)
+	service.Breakers = []*content.CircuitBreaker{openBreaker("s3-writer", 1500*time.Millisecond)}
+	job := newTestJob("job1", 1, "uuid1")
+	ctx := service.AddJob(job)
+	export := newBlockingExport()
+	close(export.release)
+	done := make(chan struct{})
+	go func() {
+		job.RunFullExport(ctx, "tid_1", export.export)
+		close(done)
+	}()
+	status := jobStatus(t, service, "job1")
+
+	waitFor(t, func() bool { return status().Status == PAUSED }, 2*time.Second)
+	assert.Equal(t, []string{"s3-writer"}, status().PausedBy)
+	assert.Len(t, service.GetRunningJobs(), 1)
+	assert.Empty(t, export.started)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Job did not resume once the breaker let calls through")
+	}
+	assert.Equal(t, FINISHED, status().Status)
+	assert.Empty(t, status().PausedBy)
+	assert.Equal(t, 1, status().Progress)
+}
+
+func TestCancelFullExportPausedByBreaker(t *testing.T) {
+	service := NewFullExporter(1, 1, nil)
+	service.Breakers = []*content.CircuitBreaker{openBreaker("enriched-content", time.Hour)}
+	job := newTestJob("job1", 1, "uuid1")
+	ctx := service.AddJob(job)
+	export := newBlockingExport()
+	done := make(chan struct{})
+	go func() {
+		job.RunFullExport(ctx, "tid_1", export.export)
+		close(done)
+	}()
+	status := jobStatus(t, service, "job1")
+	waitFor(t, func() bool { return status().Status == PAUSED }, 2*time.Second)
+
+	require.NoError(t, service.CancelJob("job1"))
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Paused job was not cancelled")
+	}
+	assert.Equal(t, CANCELLED, status().Status)
+	assert.Equal(t, 0, status().Progress)
+	assert.Empty(t, export.started)
+}
+
+func TestRunReplayPausesWhileBreakerIsOpen(t *testing.T) {
+	service := NewFullExporter(1, 1, nil)
+	service.Breakers = []*content.CircuitBreaker{openBreaker("s3-writer", 1500*time.Millisecond)}
+	job := &Job{ID: "job1", Count: 1, Status: STARTING}
+	ctx := service.AddJob(job)
+	done := make(chan struct{})
+	go func() {
+		job.RunReplay(ctx, replayOne)
+		close(done)
+	}()
+	status := jobStatus(t, service, "job1")
+
+	waitFor(t, func() bool { return status().Status == PAUSED }, 2*time.Second)
+	assert.Equal(t, []string{"s3-writer"}, status().PausedBy)
+	assert.Equal(t, 0, status().Progress)
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Replay did not resume once the breaker let calls through")
+	}
+	assert.Equal(t, FINISHED, status().Status)
+	assert.Empty(t, status().PausedBy)
+	assert.Equal(t, 1, status().Progress)
+}
+
+func TestCancelReplayPausedByBreaker(t *testing.T) {
+	service := NewFullExporter(1, 1, nil)
+	service.Breakers = []*content.CircuitBreaker{openBreaker("s3-writer", time.Hour)}
+	job := &Job{ID: "job1", Count: 1, Status: STARTING}
+	ctx := service.AddJob(job)
+	done := make(chan struct{})
+	go func() {
+		job.RunReplay(ctx, replayOne)
+		close(done)
+	}()
+	status := jobStatus(t, service, "job1")
+	waitFor(t, func() bool { return status().Status == PAUSED }, 2*time.Second)
+
+	require.NoError(t, service.CancelJob("job1"))
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Paused replay was not cancelled")
+	}
+	assert.Equal(t, CANCELLED, status().Status)
+	assert.Equal(t, 0, status().Progress)
+	assert.Empty(t, status().ErrorMessage)
+}
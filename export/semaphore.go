@@ -0,0 +1,64 @@
+package export
+
+import (
+	"context"
+	"sync"
+)
+
+// Semaphore limits the number of concurrent workers. It can be resized while workers hold it:
+// when shrunk, the running workers finish and no new ones start until the number drops below the new size
+type Semaphore struct {
+	sync.Mutex
+	size    int
+	held    int
+	changed chan struct{}
+}
+
+func NewSemaphore(size int) *Semaphore {
+	return &Semaphore{size: size, changed: make(chan struct{})}
+}
+
+// Acquire blocks until a worker slot is free or the context is done
+func (s *Semaphore) Acquire(ctx context.Context) error {
+	for {
+		s.Lock()
+		if s.held < s.size {
+			s.held++
+			s.Unlock()
+			return nil
+		}
+		changed := s.changed
+		s.Unlock()
+
+		select {
+		case <-changed:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+	}
+}
+
+func (s *Semaphore) Release() {
+	s.Lock()
+	defer s.Unlock()
+	s.held--
+	s.notify()
+}
+
+func (s *Semaphore) Resize(size int) {
+	s.Lock()
+	defer s.Unlock()
+	s.size = size
+	s.notify()
+}
+
+func (s *Semaphore) Size() int {
+	s.Lock()
+	defer s.Unlock()
+	return s.size
+}
+
+func (s *Semaphore) notify() {
+	close(s.changed)
+	s.changed = make(chan struct{})
+}
@@ -1,7 +1,5 @@
 package export
 
-import "sync"
-
 type Locker struct {
 	Locked chan bool
 	Acked  chan struct{}
@@ -15,17 +13,3 @@ func NewLocker() *Locker {
 		Acked:  ackedCh,
 	}
 }
-
-type Terminator struct {
-	Quit             chan struct{}
-	Cleanup          sync.Once
-	ShutDownPrepared bool
-	ShutDown         bool
-}
-
-func NewTerminator() *Terminator {
-	quitCh := make(chan struct{}, 2)
-	return &Terminator{
-		Quit: quitCh,
-	}
-}
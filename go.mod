@@ -14,6 +14,7 @@ require (
 	github.com/gorilla/mux v1.4.1-0.20170704074345-ac112f7d75a0
 	github.com/hashicorp/go-version v0.0.0-20170202080759-03c5bf6be031 // indirect
 	github.com/jawher/mow.cli v0.0.0-20170802120632-82aefbee1e23
+	github.com/klauspost/compress v1.8.2
 	github.com/klauspost/cpuid v1.2.2 // indirect
 	github.com/kr/pretty v0.2.0 // indirect
 	github.com/onsi/ginkgo v1.11.0 // indirect
@@ -22,11 +23,12 @@ require (
 	github.com/pkg/errors v0.8.1-0.20170505043639-c605e284fe17
 	github.com/rcrowley/go-metrics v0.0.0-20181016184325-3113b8401b8a
 	github.com/samuel/go-zookeeper v0.0.0-20161028232340-1d7be4effb13 // indirect
-	github.com/sethgrid/pester v0.0.0-20160429172022-8053687f9965
 	github.com/sirupsen/logrus v1.0.4-0.20170822132746-89742aefa4b2
 	github.com/stretchr/testify v1.3.0
 	github.com/wvanbergen/kazoo-go v0.0.0-20171010154145-2da972bbd3ba // indirect
-	golang.org/x/time v0.0.0-20191024005414-555d28b269f0 // indirect
+	github.com/xeipuuv/gojsonschema v1.2.0
+	go.etcd.io/bbolt v1.3.5
+	golang.org/x/time v0.0.0-20191024005414-555d28b269f0
 	gopkg.in/airbrake/gobrake.v2 v2.0.9 // indirect
 	gopkg.in/check.v1 v1.0.0-20190902080502-41f04d3bba15 // indirect
 	gopkg.in/gemnasium/logrus-airbrake-hook.v2 v2.1.2 // indirect
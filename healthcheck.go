@@ -1,6 +1,7 @@
 package main
 
 import (
+	"fmt"
 	"net"
 	"net/http"
 	"time"
@@ -28,6 +29,7 @@ type healthConfig struct {
 	enrichedContentFetcher *content.EnrichedContentFetcher
 	s3Uploader             *content.S3Updater
 	queueHandler           *queue.KafkaListener
+	breakers               []*content.CircuitBreaker
 }
 
 func newHealthService(config *healthConfig) *healthService {
@@ -43,11 +45,10 @@ func newHealthService(config *healthConfig) *healthService {
 		Timeout:   3 * time.Second,
 	}
 	service := &healthService{config: config, client: httpClient}
-	service.checks = []health.Check{
-		service.MongoCheck(),
-		service.ReadEndpointCheck(),
-		service.S3WriterCheck(),
-	}
+	service.checks = []health.Check{service.MongoCheck()}
+	service.checks = append(service.checks, service.ReadEndpointChecks()...)
+	service.checks = append(service.checks, service.S3WriterCheck())
+	service.checks = append(service.checks, service.CircuitBreakerChecks()...)
 	if config.queueHandler != nil {
 		service.checks = append(service.checks, service.KafkaCheck())
 	}
@@ -78,6 +79,27 @@ func (service *healthService) ReadEndpointCheck() health.Check {
 	}
 }
 
+// ReadEndpointChecks reports each enriched content endpoint separately, so that a failing region shows up
+// even though the others keep the export going
+func (service *healthService) ReadEndpointChecks() []health.Check {
+	fetcher := service.config.enrichedContentFetcher
+	var checks []health.Check
+	for _, endpoint := range fetcher.Endpoints.Endpoints() {
+		endpoint := endpoint
+		checks = append(checks, health.Check{
+			Name:             "CheckConnectivityToApiPolicyComponent " + endpoint.BaseURL,
+			BusinessImpact:   "No Business Impact.",
+			PanicGuide:       "https://runbooks.in.ft.com/content-exporter",
+			Severity:         2,
+			TechnicalSummary: fmt.Sprintf("The service is unable to connect to Api Policy Component at %v. Contents are fetched from the other endpoints while this one is failing", endpoint.BaseURL),
+			Checker: func() (string, error) {
+				return fetcher.CheckEndpointHealth(service.client, endpoint)
+			},
+		})
+	}
+	return checks
+}
+
 func (service *healthService) S3WriterCheck() health.Check {
 	return health.Check{
 		Name:             "CheckConnectivityToContentRWS3",
@@ -91,6 +113,28 @@ func (service *healthService) S3WriterCheck() health.Check {
 	}
 }
 
+func (service *healthService) CircuitBreakerChecks() []health.Check {
+	var checks []health.Check
+	for _, breaker := range service.config.breakers {
+		breaker := breaker
+		checks = append(checks, health.Check{
+			Name:             "CheckCircuitBreakerOf " + breaker.Name,
+			BusinessImpact:   "No Business Impact.",
+			PanicGuide:       "https://runbooks.in.ft.com/content-exporter",
+			Severity:         2,
+			TechnicalSummary: fmt.Sprintf("Calls to %v failed repeatedly, so the exports are paused until it recovers", breaker.Name),
+			Checker: func() (string, error) {
+				state := breaker.State()
+				if state == content.BreakerOpen {
+					return fmt.Sprintf("Circuit breaker is %v", state), fmt.Errorf("Circuit breaker of %v is open since %v", breaker.Name, breaker.OpenedAt().Format(time.RFC3339))
+				}
+				return fmt.Sprintf("Circuit breaker is %v", state), nil
+			},
+		})
+	}
+	return checks
+}
+
 func (service *healthService) KafkaCheck() health.Check {
 	return health.Check{
 		Name:             "CheckConnectivityToKafka",
@@ -3,7 +3,6 @@ package main
 import (
 	"errors"
 	"fmt"
-	"io/ioutil"
 	standardlog "log"
 	"net"
 	"net/http"
@@ -22,19 +21,23 @@ import (
 	"github.com/Financial-Times/content-exporter/web"
 	health "github.com/Financial-Times/go-fthealth/v1_1"
 	"github.com/Financial-Times/http-handlers-go/httphandlers"
-	"github.com/Financial-Times/kafka-client-go/kafka"
 	status "github.com/Financial-Times/service-status-go/httphandlers"
 
 	"github.com/Shopify/sarama"
 	"github.com/gorilla/mux"
 	cli "github.com/jawher/mow.cli"
 	metrics "github.com/rcrowley/go-metrics"
-	"github.com/sethgrid/pester"
 	log "github.com/sirupsen/logrus"
 )
 
 const appDescription = "Exports content from DB and sends to S3"
 
+// Names of the upstream services, as used by the rate limits admin endpoint
+const (
+	enrichedContentUpstream = "enrichedContent"
+	s3WriterUpstream        = "s3Writer"
+)
+
 func init() {
 	f := &log.JSONFormatter{
 		TimestampFormat: time.RFC3339Nano,
@@ -73,15 +76,21 @@ func main() {
 	enrichedContentBaseURL := app.String(cli.StringOpt{
 		Name:   "enrichedContentBaseURL",
 		Value:  "http://localhost:8080",
-		Desc:   "Base URL to enriched content endpoint",
+		Desc:   "Base URL to enriched content endpoint. Several endpoints can be given separated by comma, e.g. one per region",
 		EnvVar: "ENRICHED_CONTENT_BASE_URL",
 	})
 	enrichedContentHealthURL := app.String(cli.StringOpt{
 		Name:   "enrichedContentHealthURL",
 		Value:  "http://localhost:8080/__gtg",
-		Desc:   "Health URL to enriched content endpoint",
+		Desc:   "Health URL to enriched content endpoint. Several URLs can be given separated by comma, in the order of the base URLs",
 		EnvVar: "ENRICHED_CONTENT_HEALTH_URL",
 	})
+	enrichedContentWeights := app.String(cli.StringOpt{
+		Name:   "enrichedContentWeights",
+		Value:  "",
+		Desc:   "Share of the calls sent to each enriched content endpoint separated by comma, e.g. 3,1. Defaults to 1 for each endpoint",
+		EnvVar: "ENRICHED_CONTENT_WEIGHTS",
+	})
 	s3WriterBaseURL := app.String(cli.StringOpt{
 		Name:   "s3WriterBaseURL",
 		Value:  "http://localhost:8080",
@@ -94,19 +103,66 @@ func main() {
 		Desc:   "Health URL to S3 writer endpoint",
 		EnvVar: "S3_WRITER_HEALTH_URL",
 	})
+	s3WriterContentEncoding := app.String(cli.StringOpt{
+		Name:   "s3WriterContentEncoding",
+		Value:  "",
+		Desc:   "Compression applied to payloads uploaded to the S3 writer: identity, gzip or zstd",
+		EnvVar: "S3_WRITER_CONTENT_ENCODING",
+	})
 	xPolicyHeaderValues := app.String(cli.StringOpt{
 		Name:   "xPolicyHeaderValues",
 		Desc:   "Values for X-Policy header separated by comma, e.g. INCLUDE_RICH_CONTENT,EXPAND_IMAGES",
 		EnvVar: "X_POLICY_HEADER_VALUES",
 	})
+	allowedXPolicyHeaderValues := app.String(cli.StringOpt{
+		Name:   "allowedXPolicyHeaderValues",
+		Desc:   "Values for X-Policy header separated by comma that FULL or TARGETED export requests are allowed to ask for instead of xPolicyHeaderValues",
+		EnvVar: "ALLOWED_X_POLICY_HEADER_VALUES",
+	})
 	authorization := app.String(cli.StringOpt{
 		Name:   "authorization",
 		Desc:   "Authorization for enrichedcontent endpoint, needed only when calling the endpoint via Varnish",
 		EnvVar: "AUTHORIZATION",
 	})
+	skipUnchanged := app.Bool(cli.BoolOpt{
+		Name:   "skipUnchanged",
+		Value:  false,
+		Desc:   "Flag to skip uploading content whose payload has not changed since the last export from the same source, with the same X-Policy header values and encoding",
+		EnvVar: "SKIP_UNCHANGED",
+	})
+	hashIndexPath := app.String(cli.StringOpt{
+		Name:   "hashIndexPath",
+		Value:  "",
+		Desc:   "Path of the local store keeping the hashes of exported payloads. Hashes are kept in memory if not set",
+		EnvVar: "HASH_INDEX_PATH",
+	})
+	conditionalUpload := app.Bool(cli.BoolOpt{
+		Name:   "conditionalUpload",
+		Value:  false,
+		Desc:   "Flag to check the version stored by the S3 writer and skip uploading unchanged content. Ignored when skipUnchanged is set",
+		EnvVar: "CONDITIONAL_UPLOAD",
+	})
+	transformationsConfig := app.String(cli.StringOpt{
+		Name:   "transformationsConfig",
+		Value:  "",
+		Desc:   "Path of the JSON file declaring the named transformations that can be selected per export job",
+		EnvVar: "TRANSFORMATIONS_CONFIG",
+	})
+	incrementalTransformation := app.String(cli.StringOpt{
+		Name:   "incrementalTransformation",
+		Value:  "",
+		Desc:   "Name of the transformation applied to content exported by the INCREMENTAL export",
+		EnvVar: "INCREMENTAL_TRANSFORMATION",
+	})
+	contentSchemasDir := app.String(cli.StringOpt{
+		Name:   "contentSchemasDir",
+		Value:  "",
+		Desc:   "Directory of JSON Schemas validating content before upload, named after the content type, e.g. Article.json. Validation is disabled if not set",
+		EnvVar: "CONTENT_SCHEMAS_DIR",
+	})
 	consumerAddrs := app.String(cli.StringOpt{
 		Name:   "kafka-addr",
-		Desc:   "Comma separated kafka hosts for message consuming.",
+		Desc:   "Comma separated kafka brokers for message consuming.",
 		EnvVar: "KAFKA_ADDRS",
 	})
 	consumerGroupID := app.String(cli.StringOpt{
@@ -119,18 +175,120 @@ func main() {
 		Desc:   "Kafka topic to read from.",
 		EnvVar: "TOPIC",
 	})
+	additionalTopics := app.String(cli.StringOpt{
+		Name:   "additionalTopics",
+		Value:  "",
+		Desc:   `Other Kafka topics to read from, each with its own message format, whitelist and delay in seconds - i.e. [{"topic": "PostMetadataPublicationEvents", "format": "metadata", "whitelist": ".*", "delay": 60}]`,
+		EnvVar: "ADDITIONAL_TOPICS",
+	})
+	kafkaVersion := app.String(cli.StringOpt{
+		Name:   "kafkaVersion",
+		Value:  "1.0.0",
+		Desc:   "Version of the Kafka brokers, at least 0.10.2 for consumer groups",
+		EnvVar: "KAFKA_VERSION",
+	})
+	kafkaSASLUser := app.String(cli.StringOpt{
+		Name:   "kafkaSASLUser",
+		Value:  "",
+		Desc:   "User authenticating to the Kafka brokers with SASL/PLAIN. SASL is disabled if not set",
+		EnvVar: "KAFKA_SASL_USER",
+	})
+	kafkaSASLPassword := app.String(cli.StringOpt{
+		Name:   "kafkaSASLPassword",
+		Value:  "",
+		Desc:   "Password authenticating to the Kafka brokers with SASL/PLAIN",
+		EnvVar: "KAFKA_SASL_PASSWORD",
+	})
+	kafkaTLS := app.Bool(cli.BoolOpt{
+		Name:   "kafkaTLS",
+		Value:  false,
+		Desc:   "Connect to the Kafka brokers over TLS",
+		EnvVar: "KAFKA_TLS",
+	})
 	delayForNotification := app.Int(cli.IntOpt{
 		Name:   "delayForNotification",
 		Value:  30,
-		Desc:   "Delay in seconds for notifications to being handled",
+		Desc:   "Delay in seconds for notifications to being handled. Events received meanwhile for the same content are coalesced into the latest one, extending the delay up to 4 times",
 		EnvVar: "DELAY_FOR_NOTIFICATION",
 	})
+	deadLetterStorePath := app.String(cli.StringOpt{
+		Name:   "deadLetterStorePath",
+		Value:  "",
+		Desc:   "Path of the file keeping the INCREMENTAL export notifications whose handling failed. If not set, they are kept in memory until the service restarts",
+		EnvVar: "DEAD_LETTER_STORE_PATH",
+	})
+	delayQueuePath := app.String(cli.StringOpt{
+		Name:   "delayQueuePath",
+		Value:  "/data/delay-queue.db",
+		Desc:   "Path of the file keeping the INCREMENTAL export notifications waiting for their delay, so they are handled after a restart. It must be on a persistent volume, the service does not start if its directory does not exist. If empty, they are kept in memory only",
+		EnvVar: "DELAY_QUEUE_PATH",
+	})
+	deleteInaccessibleContent := app.Bool(cli.BoolOpt{
+		Name:   "deleteInaccessibleContent",
+		Value:  false,
+		Desc:   "Flag to delete the exported content when an UPDATE notification is received for content that is forbidden or not found, instead of skipping it",
+		EnvVar: "DELETE_INACCESSIBLE_CONTENT",
+	})
 	contentRetrievalThrottle := app.Int(cli.IntOpt{
 		Name:   "contentRetrievalThrottle",
 		Value:  0,
 		Desc:   "Delay in milliseconds between content retrieval calls",
 		EnvVar: "CONTENT_RETRIEVAL_THROTTLE",
 	})
+	enrichedContentRateLimit := app.Int(cli.IntOpt{
+		Name:   "enrichedContentRateLimit",
+		Value:  0,
+		Desc:   "Maximum number of calls per second to the enriched content endpoints, shared by all export jobs and the INCREMENTAL export. 0 means no limit",
+		EnvVar: "ENRICHED_CONTENT_RATE_LIMIT",
+	})
+	s3WriterRateLimit := app.Int(cli.IntOpt{
+		Name:   "s3WriterRateLimit",
+		Value:  0,
+		Desc:   "Maximum number of calls per second to the S3 writer, shared by all export jobs and the INCREMENTAL export. 0 means no limit",
+		EnvVar: "S3_WRITER_RATE_LIMIT",
+	})
+	enrichedContentRetryPolicy := app.String(cli.StringOpt{
+		Name:   "enrichedContentRetryPolicy",
+		Value:  content.DefaultRetryPolicy,
+		Desc:   "Retries of the calls to the enriched content endpoints: maximum attempts, backoff base and cap, randomised fraction of the backoff and retryable status codes",
+		EnvVar: "ENRICHED_CONTENT_RETRY_POLICY",
+	})
+	s3WriterRetryPolicy := app.String(cli.StringOpt{
+		Name:   "s3WriterRetryPolicy",
+		Value:  content.DefaultRetryPolicy,
+		Desc:   "Retries of the calls to the S3 writer: maximum attempts, backoff base and cap, randomised fraction of the backoff and retryable status codes",
+		EnvVar: "S3_WRITER_RETRY_POLICY",
+	})
+	breakerFailureThreshold := app.Int(cli.IntOpt{
+		Name:   "breakerFailureThreshold",
+		Value:  20,
+		Desc:   "Number of consecutive failed calls to the enriched content endpoints or the S3 writer that pause the exports until the upstream recovers. 0 disables the circuit breakers",
+		EnvVar: "BREAKER_FAILURE_THRESHOLD",
+	})
+	breakerOpenTimeout := app.Int(cli.IntOpt{
+		Name:   "breakerOpenTimeout",
+		Value:  30,
+		Desc:   "Seconds to wait after the circuit breaker of an upstream opened before probing whether it recovered",
+		EnvVar: "BREAKER_OPEN_TIMEOUT",
+	})
+	fullExportWorkers := app.Int(cli.IntOpt{
+		Name:   "fullExportWorkers",
+		Value:  20,
+		Desc:   "Number of concurrent workers of FULL and TARGETED exports. It can be changed for a running job through PATCH /jobs/{jobID}",
+		EnvVar: "FULL_EXPORT_WORKERS",
+	})
+	batchSize := app.Int(cli.IntOpt{
+		Name:   "batchSize",
+		Value:  1,
+		Desc:   "Number of contents fetched together by FULL and TARGETED exports reading a source with batch calls, like store. Contents of the other sources are fetched one by one, each worker handling a single content at a time",
+		EnvVar: "BATCH_SIZE",
+	})
+	messageFormat := app.String(cli.StringOpt{
+		Name:   "messageFormat",
+		Value:  queue.PostPublicationFormat,
+		Desc:   "Format of the notification messages: post-publication ({ContentURI, Payload} events), cloudevents (CloudEvents JSON envelopes whose subject holds the content UUID), minimal ({uuid, action} events) or metadata (annotation changes of the contents given by contentUri or contentUris)",
+		EnvVar: "MESSAGE_FORMAT",
+	})
 	whitelist := app.String(cli.StringOpt{
 		Name:   "whitelist",
 		Desc:   `The whitelist for incoming notifications - i.e. ^http://.*-transformer-(pr|iw)-uk-.*\.svc\.ft\.com(:\d{2,5})?/content/[\w-]+.*$`,
@@ -165,6 +323,26 @@ func main() {
 			app.PrintHelp()
 			log.WithError(err).Fatal("Whitelist regex MUST compile!")
 		}
+		if _, err := queue.ParseTopicConfigs(*additionalTopics); err != nil {
+			app.PrintHelp()
+			log.WithError(err).Fatal("Additional topics are not set correctly")
+		}
+		if _, err := content.ParseEncoding(*s3WriterContentEncoding); err != nil {
+			app.PrintHelp()
+			log.WithError(err).Fatal("S3 writer content encoding is not set correctly")
+		}
+		if _, err := content.ParseEndpoints(*enrichedContentBaseURL, *enrichedContentHealthURL, *enrichedContentWeights); err != nil {
+			app.PrintHelp()
+			log.WithError(err).Fatal("Enriched content endpoints are not set correctly")
+		}
+		if _, err := content.ParseRetryPolicy(*enrichedContentRetryPolicy); err != nil {
+			app.PrintHelp()
+			log.WithError(err).Fatal("Enriched content retry policy is not set correctly")
+		}
+		if _, err := content.ParseRetryPolicy(*s3WriterRetryPolicy); err != nil {
+			app.PrintHelp()
+			log.WithError(err).Fatal("S3 writer retry policy is not set correctly")
+		}
 	}
 
 	app.Action = func() {
@@ -184,32 +362,134 @@ func main() {
 				KeepAlive: 30 * time.Second,
 			}).Dial,
 		}
-		c := &http.Client{
-			Transport: tr,
-			Timeout:   30 * time.Second,
+		rateLimiters := map[string]*content.RateLimiter{
+			enrichedContentUpstream: content.NewRateLimiter(*enrichedContentRateLimit),
+			s3WriterUpstream:        content.NewRateLimiter(*s3WriterRateLimit),
+		}
+		breakers := map[string]*content.CircuitBreaker{
+			enrichedContentUpstream: content.NewCircuitBreaker(enrichedContentUpstream, *breakerFailureThreshold, time.Duration(*breakerOpenTimeout)*time.Second),
+			s3WriterUpstream:        content.NewCircuitBreaker(s3WriterUpstream, *breakerFailureThreshold, time.Duration(*breakerOpenTimeout)*time.Second),
+		}
+		newClient := func(upstream, retryPolicy string) *content.RetryingClient {
+			// The timeout applies to each call once let through by the breaker and the rate limiter, which may hold it longer
+			c := &http.Client{
+				Transport: &content.BreakingTransport{
+					Transport: &content.RateLimitedTransport{
+						Transport: &content.TimeoutTransport{Transport: tr, Timeout: 30 * time.Second},
+						Limiter:   rateLimiters[upstream],
+					},
+					Breaker: breakers[upstream],
+				},
+			}
+			policy, _ := content.ParseRetryPolicy(retryPolicy)
+			return &content.RetryingClient{Client: c, Policy: policy}
 		}
-		client := pester.NewExtendedClient(c)
-		client.Backoff = pester.ExponentialBackoff
-		client.MaxRetries = 3
-		client.Concurrency = 1
 
+		endpoints, _ := content.ParseEndpoints(*enrichedContentBaseURL, *enrichedContentHealthURL, *enrichedContentWeights)
 		fetcher := &content.EnrichedContentFetcher{
-			Client:                   client,
-			EnrichedContentBaseURL:   *enrichedContentBaseURL,
-			EnrichedContentHealthURL: *enrichedContentHealthURL,
-			XPolicyHeaderValues:      *xPolicyHeaderValues,
-			Authorization:            *authorization,
+			Client:              newClient(enrichedContentUpstream, *enrichedContentRetryPolicy),
+			Endpoints:           content.NewEndpointPool(endpoints...),
+			XPolicyHeaderValues: *xPolicyHeaderValues,
+			Authorization:       *authorization,
 		}
-		uploader := &content.S3Updater{Client: client, S3WriterBaseURL: *s3WriterBaseURL, S3WriterHealthURL: *s3WriterHealthURL}
+		encoding, _ := content.ParseEncoding(*s3WriterContentEncoding)
+		uploader := &content.S3Updater{Client: newClient(s3WriterUpstream, *s3WriterRetryPolicy), S3WriterBaseURL: *s3WriterBaseURL, S3WriterHealthURL: *s3WriterHealthURL, Encoding: encoding}
 
 		exporter := content.NewExporter(fetcher, uploader)
-		fullExporter := export.NewFullExporter(20, exporter)
+		sources := map[string]content.Fetcher{
+			content.EnrichedSource: fetcher,
+			content.StoreSource:    content.NewMongoFetcher(mongo, "content"),
+		}
+		if *skipUnchanged {
+			if *hashIndexPath == "" {
+				exporter.HashIndex = content.NewInMemoryHashIndex()
+			} else {
+				hashIndex, err := content.NewBoltHashIndex(*hashIndexPath)
+				if err != nil {
+					log.WithError(err).Fatal("Cannot open hash index")
+				}
+				defer hashIndex.Close()
+				exporter.HashIndex = hashIndex
+			}
+		}
+		exporter.ConditionalUpload = *conditionalUpload
+		if *contentSchemasDir != "" {
+			validator, err := content.LoadSchemaValidator(*contentSchemasDir)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot load content schemas")
+			}
+			exporter.Validator = validator
+		}
+
+		transformations := make(map[string]content.TransformerChain)
+		if *transformationsConfig != "" {
+			var err error
+			transformations, err = content.LoadTransformations(*transformationsConfig)
+			if err != nil {
+				log.WithError(err).Fatal("Cannot load transformations")
+			}
+		}
+		fullExporter := export.NewFullExporter(*fullExportWorkers, *batchSize, exporter)
+		fullExporter.Breakers = []*content.CircuitBreaker{breakers[enrichedContentUpstream], breakers[s3WriterUpstream]}
 		locker := export.NewLocker()
 		var kafkaListener *queue.KafkaListener
+		var incremental web.ConcurrencyAdjuster
+		var deadLetters web.DeadLetterQueue
+		var replayer web.NotificationReplayer
 		if !(*isIncExportEnabled) {
 			log.Warn("INCREMENTAL export is not enabled")
 		} else {
-			kafkaListener = prepareIncrementalExport(logDebug, consumerAddrs, consumerGroupID, topic, whitelist, exporter, delayForNotification, locker, maxGoRoutines)
+			incExporter := exporter
+			if *incrementalTransformation != "" {
+				transformer, ok := transformations[*incrementalTransformation]
+				if !ok {
+					log.Fatalf("Unknown incremental transformation: %v", *incrementalTransformation)
+				}
+				incExporter = exporter.WithTransformer(transformer)
+			}
+			topicConfigs, _ := queue.ParseTopicConfigs(*additionalTopics)
+			topics := []string{*topic}
+			delays := []time.Duration{time.Duration(*delayForNotification) * time.Second}
+			for _, c := range topicConfigs {
+				topics = append(topics, c.Topic)
+				delays = append(delays, c.Delay)
+			}
+			consumerConfig := queue.GroupConsumerConfig{
+				Brokers:       strings.Split(*consumerAddrs, ","),
+				ConsumerGroup: *consumerGroupID,
+				Topics:        topics,
+				KafkaVersion:  *kafkaVersion,
+				SASLUser:      *kafkaSASLUser,
+				SASLPassword:  *kafkaSASLPassword,
+				TLS:           *kafkaTLS,
+				DrainTimeout:  queue.DrainTimeout(delays...),
+			}
+			kafkaListener = prepareIncrementalExport(logDebug, consumerConfig, messageFormat, whitelist, incExporter, delayForNotification, deleteInaccessibleContent, locker, maxGoRoutines)
+			kafkaListener.Breakers = fullExporter.Breakers
+			kafkaListener.Topics = queue.TopicConfigs(topicConfigs...)
+			if *deadLetterStorePath == "" {
+				kafkaListener.DeadLetters = queue.NewInMemoryDeadLetterStore()
+			} else {
+				deadLetterStore, err := queue.NewBoltDeadLetterStore(*deadLetterStorePath)
+				if err != nil {
+					log.WithError(err).Fatal("Cannot open dead letter store")
+				}
+				defer deadLetterStore.Close()
+				kafkaListener.DeadLetters = deadLetterStore
+			}
+			if *delayQueuePath == "" {
+				kafkaListener.Delayed = queue.NewInMemoryDelayQueue()
+			} else {
+				delayQueue, err := queue.NewBoltDelayQueue(*delayQueuePath)
+				if err != nil {
+					log.WithError(err).Fatal("Cannot open delay queue")
+				}
+				defer delayQueue.Close()
+				kafkaListener.Delayed = delayQueue
+			}
+			incremental = kafkaListener
+			deadLetters = kafkaListener
+			replayer = queue.NewReplayer(consumerConfig, kafkaListener)
 			go kafkaListener.ConsumeMessages()
 		}
 		go func() {
@@ -222,12 +502,14 @@ func main() {
 					enrichedContentFetcher: fetcher,
 					s3Uploader:             uploader,
 					queueHandler:           kafkaListener,
+					breakers:               fullExporter.Breakers,
 				})
 
-			serveEndpoints(*appSystemCode, *appName, *port, web.NewRequestHandler(fullExporter, content.NewMongoInquirer(mongo), locker, *isIncExportEnabled, *contentRetrievalThrottle), healthService)
+			serveEndpoints(*appSystemCode, *appName, *port, web.NewRequestHandler(fullExporter, content.NewMongoInquirer(mongo), locker, *isIncExportEnabled, *contentRetrievalThrottle, transformations, sources, content.ParseXPolicies(*allowedXPolicyHeaderValues)), web.NewAdminHandler(rateLimiters, incremental, deadLetters, replayer, fullExporter), healthService)
 		}()
 
 		waitForSignal()
+		fullExporter.Shutdown()
 		if *isIncExportEnabled {
 			kafkaListener.StopConsumingMessages()
 		}
@@ -241,22 +523,11 @@ func main() {
 		return
 	}
 }
-func prepareIncrementalExport(logDebug *bool, consumerAddrs *string, consumerGroupID *string, topic *string, whitelist *string, exporter *content.Exporter, delayForNotification *int, locker *export.Locker, maxGoRoutines *int) *queue.KafkaListener {
-	consumerGroupConfig := kafka.DefaultConsumerConfig()
-	consumerGroupConfig.ChannelBufferSize = 10
+func prepareIncrementalExport(logDebug *bool, consumerConfig queue.GroupConsumerConfig, messageFormat *string, whitelist *string, exporter *content.Exporter, delayForNotification *int, deleteInaccessibleContent *bool, locker *export.Locker, maxGoRoutines *int) *queue.KafkaListener {
 	if *logDebug {
 		sarama.Logger = standardlog.New(os.Stdout, "[sarama] ", standardlog.LstdFlags)
-	} else {
-		consumerGroupConfig.Zookeeper.Logger = standardlog.New(ioutil.Discard, "", 0)
 	}
-
-	kc := kafka.Config{
-		ZookeeperConnectionString: *consumerAddrs,
-		ConsumerGroup:             *consumerGroupID,
-		Topics:                    []string{*topic},
-		ConsumerGroupConfig:       consumerGroupConfig,
-	}
-	messageConsumer, err := kafka.NewConsumer(kc)
+	messageConsumer, err := queue.NewGroupConsumer(consumerConfig)
 	if err != nil {
 		log.WithError(err).Fatal("Cannot create Kafka client")
 	}
@@ -265,15 +536,18 @@ func prepareIncrementalExport(logDebug *bool, consumerAddrs *string, consumerGro
 		log.WithError(err).Fatal("Whitelist regex MUST compile!")
 	}
 
-	kafkaMessageHandler := queue.NewKafkaContentNotificationHandler(exporter, *delayForNotification)
-	kafkaMessageMapper := queue.NewKafkaMessageMapper(whitelistR)
-	kafkaListener := queue.NewKafkaListener(messageConsumer, kafkaMessageHandler, kafkaMessageMapper, locker, *maxGoRoutines)
+	kafkaMessageHandler := queue.NewKafkaContentNotificationHandler(exporter, *deleteInaccessibleContent)
+	kafkaMessageMapper, err := queue.NewMessageMapper(*messageFormat, whitelistR)
+	if err != nil {
+		log.WithError(err).Fatal("Cannot map notification messages")
+	}
+	kafkaListener := queue.NewKafkaListener(messageConsumer, kafkaMessageHandler, kafkaMessageMapper, locker, *maxGoRoutines, time.Duration(*delayForNotification)*time.Second)
 
 	return kafkaListener
 }
 
 func serveEndpoints(appSystemCode string, appName string, port string, requestHandler *web.RequestHandler,
-	healthService *healthService) {
+	adminHandler *web.AdminHandler, healthService *healthService) {
 
 	serveMux := http.NewServeMux()
 
@@ -285,7 +559,17 @@ func serveEndpoints(appSystemCode string, appName string, port string, requestHa
 	servicesRouter := mux.NewRouter()
 	servicesRouter.HandleFunc("/export", requestHandler.Export).Methods(http.MethodPost)
 	servicesRouter.HandleFunc("/jobs/{jobID}", requestHandler.GetJob).Methods(http.MethodGet)
+	servicesRouter.HandleFunc("/jobs/{jobID}", requestHandler.CancelJob).Methods(http.MethodDelete)
+	servicesRouter.HandleFunc("/jobs/{jobID}", requestHandler.AdjustJob).Methods(http.MethodPatch)
 	servicesRouter.HandleFunc("/jobs", requestHandler.GetRunningJobs).Methods(http.MethodGet)
+	servicesRouter.HandleFunc("/ratelimits", adminHandler.GetRateLimits).Methods(http.MethodGet)
+	servicesRouter.HandleFunc("/ratelimits/{upstream}", adminHandler.SetRateLimit).Methods(http.MethodPut)
+	servicesRouter.HandleFunc("/incremental", adminHandler.GetIncremental).Methods(http.MethodGet)
+	servicesRouter.HandleFunc("/incremental", adminHandler.AdjustIncremental).Methods(http.MethodPatch)
+	servicesRouter.HandleFunc("/incremental/replay", adminHandler.Replay).Methods(http.MethodPost)
+	servicesRouter.HandleFunc("/deadletters", adminHandler.GetDeadLetters).Methods(http.MethodGet)
+	servicesRouter.HandleFunc("/deadletters/{id}/replay", adminHandler.ReplayDeadLetter).Methods(http.MethodPost)
+	servicesRouter.HandleFunc("/deadletters/{id}", adminHandler.DiscardDeadLetter).Methods(http.MethodDelete)
 
 	var monitoringRouter http.Handler = servicesRouter
 	monitoringRouter = httphandlers.TransactionAwareRequestLoggingHandler(log.StandardLogger(), monitoringRouter)
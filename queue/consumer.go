@@ -0,0 +1,38 @@
+package queue
+
+import (
+	"strings"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+)
+
+// Consumer delivers the Kafka messages to the handler together with an ack, to be called once the message is fully handled.
+// The offset of a message is committed only after it and every message before it in its partition have been acked,
+// so messages in flight when the service stops are consumed again. Pause holds back the delivery of the messages until Resume
+type Consumer interface {
+	StartListening(messageHandler func(topic string, msg kafka.FTMessage, ack func()) error)
+	Pause()
+	Resume()
+	Shutdown()
+	ConnectivityCheck() error
+}
+
+// parseFTMessage reads the headers and body of a raw FT message
+func parseFTMessage(raw []byte) kafka.FTMessage {
+	msg := string(raw)
+	end := strings.Index(msg, "\r\n\r\n")
+	if end == -1 {
+		end = strings.Index(msg, "\n\n")
+	}
+	if end == -1 {
+		end = len(msg)
+	}
+	headers := make(map[string]string)
+	for _, line := range strings.Split(msg[:end], "\n") {
+		kv := strings.SplitN(strings.TrimSpace(line), ":", 2)
+		if len(kv) == 2 {
+			headers[strings.TrimSpace(kv[0])] = strings.TrimSpace(kv[1])
+		}
+	}
+	return kafka.NewFTMessage(headers, strings.TrimSpace(msg[end:]))
+}
@@ -0,0 +1,20 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseFTMessage(t *testing.T) {
+	msg := parseFTMessage([]byte("FTMSG/1.0\r\nX-Request-Id: tid_1\r\nMessage-Timestamp: 2017-08-04T10:12:41.123Z\r\n\r\n{\"ContentURI\":\"uri\"}\n"))
+	assert.Equal(t, "tid_1", msg.Headers["X-Request-Id"])
+	assert.Equal(t, "2017-08-04T10:12:41.123Z", msg.Headers["Message-Timestamp"])
+	assert.Equal(t, `{"ContentURI":"uri"}`, msg.Body)
+}
+
+func TestParseFTMessageWithUnixLineEndings(t *testing.T) {
+	msg := parseFTMessage([]byte("FTMSG/1.0\nX-Request-Id: tid_1\n\nbody"))
+	assert.Equal(t, "tid_1", msg.Headers["X-Request-Id"])
+	assert.Equal(t, "body", msg.Body)
+}
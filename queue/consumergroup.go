@@ -0,0 +1,287 @@
+package queue
+
+import (
+	"context"
+	"crypto/tls"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// drainPollInterval is how often a rebalance checks whether the messages in flight have been handled
+const drainPollInterval = 100 * time.Millisecond
+
+// drainHandlingTime is the time left to handle a notification once its delay has passed when draining
+const drainHandlingTime = 30 * time.Second
+
+// rebalanceMargin is the time left to the consumer to join the group again once drained
+const rebalanceMargin = 10 * time.Second
+
+// DrainTimeout is how long a rebalance waits for the messages in flight: the longest a notification may wait
+// for the longest of the delays, extended by coalesced events, and the time to handle it
+func DrainTimeout(delays ...time.Duration) time.Duration {
+	longest := time.Duration(0)
+	for _, delay := range delays {
+		if delay > longest {
+			longest = delay
+		}
+	}
+	return longest*maxDelayFactor + drainHandlingTime
+}
+
+// GroupConsumerConfig configures the consumption through Kafka's native consumer groups
+type GroupConsumerConfig struct {
+	Brokers       []string
+	ConsumerGroup string
+	Topics        []string
+	// KafkaVersion is the version of the brokers, at least 0.10.2 for consumer groups
+	KafkaVersion string
+	// SASLUser and SASLPassword enable SASL/PLAIN authentication when set
+	SASLUser     string
+	SASLPassword string
+	TLS          bool
+	// DrainTimeout bounds how long a rebalance waits for the messages in flight to be handled before releasing the partitions,
+	// see DrainTimeout
+	DrainTimeout time.Duration
+}
+
+// SaramaConfig returns the client configuration, consuming from the newest offset when the group has none committed.
+// The group waits for the members to drain their messages in flight when rebalancing
+func (c GroupConsumerConfig) SaramaConfig() (*sarama.Config, error) {
+	config := sarama.NewConfig()
+	version, err := sarama.ParseKafkaVersion(c.KafkaVersion)
+	if err != nil {
+		return nil, err
+	}
+	config.Version = version
+	config.ChannelBufferSize = 10
+	config.Consumer.Return.Errors = true
+	config.Consumer.Offsets.Initial = sarama.OffsetNewest
+	if timeout := c.DrainTimeout + rebalanceMargin; timeout > config.Consumer.Group.Rebalance.Timeout {
+		config.Consumer.Group.Rebalance.Timeout = timeout
+	}
+	if c.SASLUser != "" {
+		config.Net.SASL.Enable = true
+		config.Net.SASL.Mechanism = sarama.SASLTypePlaintext
+		config.Net.SASL.User = c.SASLUser
+		config.Net.SASL.Password = c.SASLPassword
+		config.Net.SASL.Handshake = true
+	}
+	if c.TLS {
+		config.Net.TLS.Enable = true
+		config.Net.TLS.Config = &tls.Config{}
+	}
+	return config, config.Validate()
+}
+
+// GroupConsumer consumes through Kafka's native consumer group protocol. On a rebalance it drains the messages in flight
+// of its partitions, so their offsets are committed before the partitions move to another consumer
+type GroupConsumer struct {
+	group        sarama.ConsumerGroup
+	client       sarama.Client
+	topics       []string
+	drainTimeout time.Duration
+	gate         *pauseGate
+	ctx          context.Context
+	cancel       context.CancelFunc
+	stopped      chan struct{}
+}
+
+func NewGroupConsumer(config GroupConsumerConfig) (*GroupConsumer, error) {
+	saramaConfig, err := config.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(config.Brokers, saramaConfig)
+	if err != nil {
+		return nil, err
+	}
+	group, err := sarama.NewConsumerGroupFromClient(config.ConsumerGroup, client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	consumer := newGroupConsumer(group, config.Topics, config.DrainTimeout)
+	consumer.client = client
+	return consumer, nil
+}
+
+func newGroupConsumer(group sarama.ConsumerGroup, topics []string, drainTimeout time.Duration) *GroupConsumer {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &GroupConsumer{
+		group:        group,
+		topics:       topics,
+		drainTimeout: drainTimeout,
+		gate:         &pauseGate{},
+		ctx:          ctx,
+		cancel:       cancel,
+		stopped:      make(chan struct{}),
+	}
+}
+
+func (c *GroupConsumer) StartListening(messageHandler func(topic string, msg kafka.FTMessage, ack func()) error) {
+	go func() {
+		for err := range c.group.Errors() {
+			log.WithError(err).Error("Error consuming Kafka messages")
+		}
+	}()
+
+	go func() {
+		defer close(c.stopped)
+		handler := &groupHandler{messageHandler: messageHandler, drainTimeout: c.drainTimeout, gate: c.gate, ctx: c.ctx}
+		// Consume returns at each rebalance, joining the group again for the new assignment
+		for c.ctx.Err() == nil {
+			if err := c.group.Consume(c.ctx, c.topics, handler); err != nil {
+				log.WithError(err).Error("Error joining Kafka consumer group")
+				select {
+				case <-time.After(time.Second):
+				case <-c.ctx.Done():
+				}
+			}
+		}
+	}()
+}
+
+// Pause holds back the delivery of the messages. Rebalances still go through meanwhile
+func (c *GroupConsumer) Pause() {
+	c.gate.pause()
+}
+
+// Resume delivers the messages again
+func (c *GroupConsumer) Resume() {
+	c.gate.resume()
+}
+
+func (c *GroupConsumer) Shutdown() {
+	c.cancel()
+	<-c.stopped
+	if err := c.group.Close(); err != nil {
+		log.WithError(err).Error("Error closing the consumer")
+	}
+}
+
+// ConnectivityCheck refreshes the metadata of the consumed topics from the brokers
+func (c *GroupConsumer) ConnectivityCheck() error {
+	return c.client.RefreshMetadata(c.topics...)
+}
+
+// groupHandler hands the messages of the claimed partitions to the message handler.
+// Each session tracks its own offsets, so acks arriving after a rebalance do not mark offsets of a later session
+type groupHandler struct {
+	messageHandler func(topic string, msg kafka.FTMessage, ack func()) error
+	drainTimeout   time.Duration
+	gate           *pauseGate
+	ctx            context.Context
+	sync.Mutex
+	offsets *OffsetTracker
+}
+
+func (h *groupHandler) Setup(session sarama.ConsumerGroupSession) error {
+	h.Lock()
+	defer h.Unlock()
+	h.offsets = NewOffsetTracker()
+	log.Infof("Kafka consumer group session %v started, claimed partitions: %v", session.GenerationID(), session.Claims())
+	return nil
+}
+
+func (h *groupHandler) sessionOffsets() *OffsetTracker {
+	h.Lock()
+	defer h.Unlock()
+	return h.offsets
+}
+
+func (h *groupHandler) ConsumeClaim(session sarama.ConsumerGroupSession, claim sarama.ConsumerGroupClaim) error {
+	offsets := h.sessionOffsets()
+	for message := range claim.Messages() {
+		// A message held back by the pause is not tracked, the next owner of the partition consumes it after a rebalance
+		if !h.gate.wait(session.Context()) {
+			return nil
+		}
+		offsets.Track(message.Topic, message.Partition, message.Offset)
+		if err := h.messageHandler(message.Topic, parseFTMessage(message.Value), ack(session, offsets, message)); err != nil {
+			log.WithError(err).WithField("partition", message.Partition).WithField("offset", message.Offset).Warn("Error processing message")
+		}
+	}
+	return nil
+}
+
+// Cleanup drains the messages in flight before the session commits its offsets and releases the partitions.
+// Messages not handled within the drain timeout, or when the consumer shuts down, are consumed again by the next owner of their partition
+func (h *groupHandler) Cleanup(session sarama.ConsumerGroupSession) error {
+	offsets := h.sessionOffsets()
+	deadline := time.Now().Add(h.drainTimeout)
+	for pending := inFlight(offsets, session.Claims()); pending > 0; pending = inFlight(offsets, session.Claims()) {
+		if h.ctx.Err() != nil || time.Now().After(deadline) {
+			log.Warnf("Kafka consumer group session %v ended with %v message(s) still in flight", session.GenerationID(), pending)
+			return nil
+		}
+		time.Sleep(drainPollInterval)
+	}
+	log.Infof("Kafka consumer group session %v drained", session.GenerationID())
+	return nil
+}
+
+func inFlight(offsets *OffsetTracker, claims map[string][]int32) int {
+	count := 0
+	for topic, partitions := range claims {
+		for _, partition := range partitions {
+			count += offsets.InFlight(topic, partition)
+		}
+	}
+	return count
+}
+
+// ack marks the offset following the low watermark of the partition once the message is handled,
+// as Kafka expects the offset of the next message to consume
+func ack(session sarama.ConsumerGroupSession, offsets *OffsetTracker, message *sarama.ConsumerMessage) func() {
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			if watermark, moved := offsets.Done(message.Topic, message.Partition, message.Offset); moved {
+				session.MarkOffset(message.Topic, message.Partition, watermark+1, "")
+			}
+		})
+	}
+}
+
+// pauseGate holds back the messages while paused
+type pauseGate struct {
+	sync.Mutex
+	resumed chan struct{}
+}
+
+func (g *pauseGate) pause() {
+	g.Lock()
+	defer g.Unlock()
+	if g.resumed == nil {
+		g.resumed = make(chan struct{})
+	}
+}
+
+func (g *pauseGate) resume() {
+	g.Lock()
+	defer g.Unlock()
+	if g.resumed != nil {
+		close(g.resumed)
+		g.resumed = nil
+	}
+}
+
+// wait blocks while paused, returning false if the context is done meanwhile
+func (g *pauseGate) wait(ctx context.Context) bool {
+	g.Lock()
+	resumed := g.resumed
+	g.Unlock()
+	if resumed == nil {
+		return true
+	}
+	select {
+	case <-resumed:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
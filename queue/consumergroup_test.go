@@ -0,0 +1,302 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/Shopify/sarama"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeSession struct {
+	sync.Mutex
+	ctx    context.Context
+	claims map[string][]int32
+	marked map[int32]int64
+}
+
+func (s *fakeSession) Claims() map[string][]int32 { return s.claims }
+func (s *fakeSession) MemberID() string           { return "member" }
+func (s *fakeSession) GenerationID() int32        { return 1 }
+func (s *fakeSession) ResetOffset(topic string, partition int32, offset int64, metadata string) {
+}
+func (s *fakeSession) MarkMessage(msg *sarama.ConsumerMessage, metadata string) {}
+func (s *fakeSession) Context() context.Context                                 { return s.ctx }
+
+func (s *fakeSession) MarkOffset(topic string, partition int32, offset int64, metadata string) {
+	s.Lock()
+	defer s.Unlock()
+	s.marked[partition] = offset
+}
+
+func (s *fakeSession) Marked() map[int32]int64 {
+	s.Lock()
+	defer s.Unlock()
+	marked := make(map[int32]int64)
+	for partition, offset := range s.marked {
+		marked[partition] = offset
+	}
+	return marked
+}
+
+type fakeClaim struct {
+	partition int32
+	messages  chan *sarama.ConsumerMessage
+	closeOnce sync.Once
+}
+
+// close ends the claim, as a rebalance or the shutdown of the consumer does
+func (c *fakeClaim) close() {
+	c.closeOnce.Do(func() { close(c.messages) })
+}
+
+func (c *fakeClaim) Topic() string                            { return "topic" }
+func (c *fakeClaim) Partition() int32                         { return c.partition }
+func (c *fakeClaim) InitialOffset() int64                     { return 0 }
+func (c *fakeClaim) HighWaterMarkOffset() int64               { return 0 }
+func (c *fakeClaim) Messages() <-chan *sarama.ConsumerMessage { return c.messages }
+
+func (c *fakeClaim) send(offset int64, tid string) {
+	msg := kafka.NewFTMessage(map[string]string{"X-Request-Id": tid}, "body")
+	c.messages <- &sarama.ConsumerMessage{Topic: "topic", Partition: c.partition, Offset: offset, Value: []byte(msg.Build())}
+}
+
+// fakeGeneration is a session of the in-memory consumer group, ending once the messages of its claim are closed
+type fakeGeneration struct {
+	session *fakeSession
+	claim   *fakeClaim
+	ended   chan struct{}
+	// rebalance cancels the context of the session, as a rebalance does before closing the claims
+	rebalance context.CancelFunc
+}
+
+func newFakeGeneration(partition int32) *fakeGeneration {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &fakeGeneration{
+		rebalance: cancel,
+		session:   &fakeSession{ctx: ctx, claims: map[string][]int32{"topic": {partition}}, marked: make(map[int32]int64)},
+		claim:     &fakeClaim{partition: partition, messages: make(chan *sarama.ConsumerMessage, 10)},
+		ended:     make(chan struct{}),
+	}
+}
+
+// fakeConsumerGroup runs the generations given to it one by one, as rebalances would
+type fakeConsumerGroup struct {
+	generations chan *fakeGeneration
+	errors      chan error
+}
+
+func newFakeConsumerGroup() *fakeConsumerGroup {
+	return &fakeConsumerGroup{generations: make(chan *fakeGeneration, 1), errors: make(chan error)}
+}
+
+func (g *fakeConsumerGroup) Consume(ctx context.Context, topics []string, handler sarama.ConsumerGroupHandler) error {
+	var generation *fakeGeneration
+	select {
+	case generation = <-g.generations:
+	case <-ctx.Done():
+		return nil
+	}
+	defer close(generation.ended)
+	go func() {
+		select {
+		case <-ctx.Done():
+			generation.claim.close()
+		case <-generation.ended:
+		}
+	}()
+	if err := handler.Setup(generation.session); err != nil {
+		return err
+	}
+	handler.ConsumeClaim(generation.session, generation.claim)
+	return handler.Cleanup(generation.session)
+}
+
+func (g *fakeConsumerGroup) Errors() <-chan error { return g.errors }
+
+func (g *fakeConsumerGroup) Close() error {
+	close(g.errors)
+	return nil
+}
+
+type ackingHandler struct {
+	sync.Mutex
+	acks map[string]func()
+}
+
+func (h *ackingHandler) handle(topic string, msg kafka.FTMessage, ack func()) error {
+	h.Lock()
+	defer h.Unlock()
+	h.acks[msg.Headers["X-Request-Id"]] = ack
+	return nil
+}
+
+func (h *ackingHandler) received(tid string) bool {
+	h.Lock()
+	defer h.Unlock()
+	return h.acks[tid] != nil
+}
+
+func (h *ackingHandler) ack(t *testing.T, tid string) {
+	waitFor(t, func() bool {
+		h.Lock()
+		defer h.Unlock()
+		return h.acks[tid] != nil
+	}, 2*time.Second)
+	h.Lock()
+	ack := h.acks[tid]
+	h.Unlock()
+	ack()
+}
+
+func startFakeGroupConsumer(drainTimeout time.Duration) (*GroupConsumer, *fakeConsumerGroup, *ackingHandler) {
+	group := newFakeConsumerGroup()
+	consumer := newGroupConsumer(group, []string{"topic"}, drainTimeout)
+	handler := &ackingHandler{acks: make(map[string]func())}
+	consumer.StartListening(handler.handle)
+	return consumer, group, handler
+}
+
+func TestGroupConsumerMarksOffsetsOnlyUpToHandledMessages(t *testing.T) {
+	consumer, group, handler := startFakeGroupConsumer(time.Second)
+	defer consumer.Shutdown()
+	generation := newFakeGeneration(0)
+	group.generations <- generation
+
+	generation.claim.send(10, "tid_1")
+	generation.claim.send(11, "tid_2")
+	generation.claim.send(12, "tid_3")
+
+	handler.ack(t, "tid_2")
+	assert.Empty(t, generation.session.Marked())
+	handler.ack(t, "tid_1")
+	assert.Equal(t, map[int32]int64{0: 12}, generation.session.Marked())
+	handler.ack(t, "tid_3")
+	assert.Equal(t, map[int32]int64{0: 13}, generation.session.Marked())
+}
+
+func TestGroupConsumerDrainsMessagesInFlightOnRebalance(t *testing.T) {
+	consumer, group, handler := startFakeGroupConsumer(time.Minute)
+	defer consumer.Shutdown()
+	generation := newFakeGeneration(0)
+	group.generations <- generation
+
+	generation.claim.send(10, "tid_1")
+	handler.ack(t, "tid_1")
+	generation.claim.send(11, "tid_2")
+	generation.claim.close()
+
+	select {
+	case <-generation.ended:
+		t.Fatal("Session ended before the message in flight was handled")
+	case <-time.After(300 * time.Millisecond):
+	}
+	handler.ack(t, "tid_2")
+
+	select {
+	case <-generation.ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Session did not end once drained")
+	}
+	assert.Equal(t, map[int32]int64{0: 12}, generation.session.Marked())
+}
+
+func TestGroupConsumerReleasesPartitionsAfterDrainTimeout(t *testing.T) {
+	consumer, group, handler := startFakeGroupConsumer(200 * time.Millisecond)
+	defer consumer.Shutdown()
+	generation := newFakeGeneration(0)
+	group.generations <- generation
+
+	generation.claim.send(10, "tid_1")
+	generation.claim.close()
+
+	select {
+	case <-generation.ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Session did not end after the drain timeout")
+	}
+	assert.Empty(t, generation.session.Marked())
+
+	next := newFakeGeneration(0)
+	group.generations <- next
+	handler.ack(t, "tid_1")
+	assert.Empty(t, next.session.Marked(), "acks of a previous session must not mark offsets of the next one")
+}
+
+func TestGroupConsumerStopsOnShutdown(t *testing.T) {
+	consumer, _, _ := startFakeGroupConsumer(time.Minute)
+	stopped := make(chan struct{})
+	go func() {
+		consumer.Shutdown()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Consumer did not stop")
+	}
+}
+
+func TestGroupConsumerHoldsBackMessagesWhilePaused(t *testing.T) {
+	consumer, group, handler := startFakeGroupConsumer(time.Minute)
+	defer consumer.Shutdown()
+	generation := newFakeGeneration(0)
+	group.generations <- generation
+
+	consumer.Pause()
+	generation.claim.send(10, "tid_1")
+	time.Sleep(200 * time.Millisecond)
+	assert.False(t, handler.received("tid_1"))
+
+	consumer.Resume()
+	handler.ack(t, "tid_1")
+	assert.Equal(t, map[int32]int64{0: 11}, generation.session.Marked())
+}
+
+func TestGroupConsumerRebalancesWhilePaused(t *testing.T) {
+	consumer, group, handler := startFakeGroupConsumer(time.Minute)
+	defer consumer.Shutdown()
+	generation := newFakeGeneration(0)
+	group.generations <- generation
+
+	consumer.Pause()
+	generation.claim.send(10, "tid_1")
+	time.Sleep(100 * time.Millisecond)
+	generation.rebalance()
+
+	select {
+	case <-generation.ended:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Paused session did not end on rebalance")
+	}
+	assert.False(t, handler.received("tid_1"))
+	assert.Empty(t, generation.session.Marked())
+}
+
+func TestDrainTimeoutCoversLongestDelay(t *testing.T) {
+	assert.Equal(t, 4*time.Minute+drainHandlingTime, DrainTimeout(30*time.Second, time.Minute, 0))
+	assert.Equal(t, drainHandlingTime, DrainTimeout())
+}
+
+func TestSaramaConfig(t *testing.T) {
+	config, err := GroupConsumerConfig{KafkaVersion: "1.0.0", SASLUser: "user", SASLPassword: "pass", TLS: true}.SaramaConfig()
+	require.NoError(t, err)
+	assert.Equal(t, sarama.V1_0_0_0, config.Version)
+	assert.True(t, config.Net.SASL.Enable)
+	assert.Equal(t, "user", config.Net.SASL.User)
+	assert.True(t, config.Net.TLS.Enable)
+	assert.Equal(t, sarama.OffsetNewest, config.Consumer.Offsets.Initial)
+	assert.Equal(t, time.Minute, config.Consumer.Group.Rebalance.Timeout)
+
+	config, err = GroupConsumerConfig{KafkaVersion: "1.0.0", DrainTimeout: 150 * time.Second}.SaramaConfig()
+	require.NoError(t, err)
+	assert.Equal(t, 150*time.Second+rebalanceMargin, config.Consumer.Group.Rebalance.Timeout)
+
+	_, err = GroupConsumerConfig{KafkaVersion: "not a version"}.SaramaConfig()
+	assert.Error(t, err)
+}
@@ -0,0 +1,164 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	bolt "go.etcd.io/bbolt"
+)
+
+var deadLetterBucket = []byte("dead-letters")
+
+// ErrDeadLetterNotFound is returned when no dead letter is stored with the given id
+var ErrDeadLetterNotFound = errors.New("Dead letter not found")
+
+// DeadLetter is a notification whose handling failed, kept with its original message to be replayed later.
+// Attempts counts the calls made by the failed handlings of the notification, its replays included
+type DeadLetter struct {
+	ID       string          `json:"id"`
+	Message  kafka.FTMessage `json:"message"`
+	Topic    string          `json:"topic,omitempty"`
+	Uuid     string          `json:"uuid"`
+	EvType   EventType       `json:"eventType"`
+	Error    string          `json:"error"`
+	Attempts int             `json:"attempts,omitempty"`
+	FailedAt time.Time       `json:"failedAt"`
+}
+
+// DeadLetterStore keeps the failed notifications. List returns them in the order they failed
+type DeadLetterStore interface {
+	Add(letter DeadLetter) error
+	List() ([]DeadLetter, error)
+	Get(id string) (DeadLetter, error)
+	Remove(id string) error
+}
+
+func sortDeadLetters(letters []DeadLetter) {
+	sort.Slice(letters, func(i, j int) bool {
+		return letters[i].FailedAt.Before(letters[j].FailedAt)
+	})
+}
+
+// InMemoryDeadLetterStore keeps the dead letters until the service restarts
+type InMemoryDeadLetterStore struct {
+	sync.RWMutex
+	letters map[string]DeadLetter
+}
+
+func NewInMemoryDeadLetterStore() *InMemoryDeadLetterStore {
+	return &InMemoryDeadLetterStore{letters: make(map[string]DeadLetter)}
+}
+
+func (s *InMemoryDeadLetterStore) Add(letter DeadLetter) error {
+	s.Lock()
+	defer s.Unlock()
+	s.letters[letter.ID] = letter
+	return nil
+}
+
+func (s *InMemoryDeadLetterStore) List() ([]DeadLetter, error) {
+	s.RLock()
+	defer s.RUnlock()
+	letters := make([]DeadLetter, 0, len(s.letters))
+	for _, letter := range s.letters {
+		letters = append(letters, letter)
+	}
+	sortDeadLetters(letters)
+	return letters, nil
+}
+
+func (s *InMemoryDeadLetterStore) Get(id string) (DeadLetter, error) {
+	s.RLock()
+	defer s.RUnlock()
+	letter, ok := s.letters[id]
+	if !ok {
+		return DeadLetter{}, ErrDeadLetterNotFound
+	}
+	return letter, nil
+}
+
+func (s *InMemoryDeadLetterStore) Remove(id string) error {
+	s.Lock()
+	defer s.Unlock()
+	if _, ok := s.letters[id]; !ok {
+		return ErrDeadLetterNotFound
+	}
+	delete(s.letters, id)
+	return nil
+}
+
+// BoltDeadLetterStore keeps the dead letters in a local file, so they survive restarts and can be inspected offline
+type BoltDeadLetterStore struct {
+	db *bolt.DB
+}
+
+func NewBoltDeadLetterStore(path string) (*BoltDeadLetterStore, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(deadLetterBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDeadLetterStore{db: db}, nil
+}
+
+func (s *BoltDeadLetterStore) Add(letter DeadLetter) error {
+	value, err := json.Marshal(letter)
+	if err != nil {
+		return err
+	}
+	return s.db.Update(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLetterBucket).Put([]byte(letter.ID), value)
+	})
+}
+
+func (s *BoltDeadLetterStore) List() ([]DeadLetter, error) {
+	letters := make([]DeadLetter, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(deadLetterBucket).ForEach(func(_, value []byte) error {
+			var letter DeadLetter
+			if err := json.Unmarshal(value, &letter); err != nil {
+				return err
+			}
+			letters = append(letters, letter)
+			return nil
+		})
+	})
+	sortDeadLetters(letters)
+	return letters, err
+}
+
+func (s *BoltDeadLetterStore) Get(id string) (letter DeadLetter, err error) {
+	err = s.db.View(func(tx *bolt.Tx) error {
+		value := tx.Bucket(deadLetterBucket).Get([]byte(id))
+		if value == nil {
+			return ErrDeadLetterNotFound
+		}
+		return json.Unmarshal(value, &letter)
+	})
+	return
+}
+
+func (s *BoltDeadLetterStore) Remove(id string) error {
+	return s.db.Update(func(tx *bolt.Tx) error {
+		bucket := tx.Bucket(deadLetterBucket)
+		if bucket.Get([]byte(id)) == nil {
+			return ErrDeadLetterNotFound
+		}
+		return bucket.Delete([]byte(id))
+	})
+}
+
+func (s *BoltDeadLetterStore) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,173 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/content-exporter/export"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func testDeadLetterStore(t *testing.T, store DeadLetterStore) {
+	now := time.Now()
+	second := DeadLetter{ID: "2", Uuid: "uuid2", Error: "err2", FailedAt: now}
+	first := DeadLetter{ID: "1", Uuid: "uuid1", EvType: UPDATE, Error: "err1", Attempts: 4, FailedAt: now.Add(-time.Minute),
+		Message: kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1234"}, "body")}
+	require.NoError(t, store.Add(second))
+	require.NoError(t, store.Add(first))
+
+	letters, err := store.List()
+	require.NoError(t, err)
+	require.Len(t, letters, 2)
+	assert.Equal(t, "1", letters[0].ID)
+	assert.Equal(t, "2", letters[1].ID)
+
+	letter, err := store.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, first.Message, letter.Message)
+	assert.Equal(t, 4, letter.Attempts)
+
+	require.NoError(t, store.Remove("1"))
+	_, err = store.Get("1")
+	assert.Equal(t, ErrDeadLetterNotFound, err)
+	assert.Equal(t, ErrDeadLetterNotFound, store.Remove("1"))
+}
+
+func TestInMemoryDeadLetterStore(t *testing.T) {
+	testDeadLetterStore(t, NewInMemoryDeadLetterStore())
+}
+
+func TestBoltDeadLetterStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-exporter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	store, err := NewBoltDeadLetterStore(filepath.Join(dir, "deadletters.db"))
+	require.NoError(t, err)
+	defer store.Close()
+	testDeadLetterStore(t, store)
+}
+
+func TestKafkaListenerStoresFailedNotificationAsDeadLetter(t *testing.T) {
+	listener := NewKafkaListener(nil, nil, nil, export.NewLocker(), 1, 0)
+	listener.DeadLetters = NewInMemoryDeadLetterStore()
+	msg := kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1234"}, "body")
+	n := &Notification{Stub: content.Stub{Uuid: "uuid1"}, EvType: UPDATE, Tid: "tid_1234", Message: msg}
+
+	listener.failed(n, fmt.Errorf("UPDATE ERROR: %w", &content.AttemptsError{Attempts: 3, Err: errors.New("S3 down")}))
+
+	letters, err := listener.ListDeadLetters()
+	require.NoError(t, err)
+	require.Len(t, letters, 1)
+	assert.Equal(t, msg, letters[0].Message)
+	assert.Equal(t, "uuid1", letters[0].Uuid)
+	assert.Equal(t, UPDATE, letters[0].EvType)
+	assert.Equal(t, "UPDATE ERROR: S3 down", letters[0].Error)
+	assert.Equal(t, 3, letters[0].Attempts)
+
+	assert.NoError(t, listener.DiscardDeadLetter(letters[0].ID))
+	letters, _ = listener.ListDeadLetters()
+	assert.Empty(t, letters)
+}
+
+func TestKafkaListenerAddsUpAttemptsOfReplayedDeadLetter(t *testing.T) {
+	listener, _ := newTestListener(0)
+	listener.DeadLetters = NewInMemoryDeadLetterStore()
+	require.NoError(t, listener.DeadLetters.Add(DeadLetter{ID: "1", Uuid: testUUID, EvType: UPDATE, Error: "S3 down", Attempts: 4}))
+	n := &Notification{Stub: content.Stub{Uuid: testUUID}, EvType: UPDATE, Tid: "tid_1", DeadLetterID: "1"}
+
+	listener.failed(n, fmt.Errorf("UPDATE ERROR: %w", &content.AttemptsError{Attempts: 3, Err: errors.New("S3 down")}))
+
+	letter, err := listener.DeadLetters.Get("1")
+	require.NoError(t, err)
+	assert.Equal(t, 7, letter.Attempts)
+}
+
+// failingNotificationHandler fails the notifications while failing is set
+type failingNotificationHandler struct {
+	recordingNotificationHandler
+	failing bool
+}
+
+func (f *failingNotificationHandler) HandleContentNotification(ctx context.Context, n *Notification) error {
+	f.recordingNotificationHandler.HandleContentNotification(ctx, n)
+	f.Lock()
+	defer f.Unlock()
+	if f.failing {
+		return errors.New("S3 down")
+	}
+	return nil
+}
+
+func (f *failingNotificationHandler) setFailing(failing bool) {
+	f.Lock()
+	defer f.Unlock()
+	f.failing = failing
+}
+
+func TestKafkaListenerKeepsDeadLetterUntilReplaySucceeds(t *testing.T) {
+	listener, _ := newTestListener(0)
+	handler := &failingNotificationHandler{failing: true}
+	listener.ContentNotificationHandler = handler
+	listener.DeadLetters = NewInMemoryDeadLetterStore()
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.handleMessage("content", message("tid_1", testUUID+" UPDATE"), nil))
+	waitFor(t, func() bool { letters, _ := listener.ListDeadLetters(); return len(letters) == 1 }, 2*time.Second)
+	letters, _ := listener.ListDeadLetters()
+	id, failedAt := letters[0].ID, letters[0].FailedAt
+	assert.Equal(t, 1, letters[0].Attempts)
+
+	require.NoError(t, listener.ReplayDeadLetter(id))
+	waitFor(t, func() bool { return len(handler.Handled()) == 2 }, 2*time.Second)
+	waitFor(t, func() bool {
+		letters, _ := listener.ListDeadLetters()
+		return len(letters) == 1 && letters[0].FailedAt.After(failedAt)
+	}, 2*time.Second)
+	letters, _ = listener.ListDeadLetters()
+	assert.Equal(t, id, letters[0].ID)
+	assert.Equal(t, 2, letters[0].Attempts)
+
+	handler.setFailing(false)
+	require.NoError(t, listener.ReplayDeadLetter(id))
+	waitFor(t, func() bool { letters, _ := listener.ListDeadLetters(); return len(letters) == 0 }, 2*time.Second)
+	assert.Len(t, handler.Handled(), 3)
+}
+
+func TestKafkaListenerReplaysOnlyContentOfDeadLetter(t *testing.T) {
+	listener, handler := newTestListener(0)
+	listener.Topics = TopicConfigs(TopicConfig{Topic: "annotations", MessageMapper: NewMetadataMessageMapper(regexp.MustCompile(".*")), Delay: 0})
+	listener.DeadLetters = NewInMemoryDeadLetterStore()
+	go listener.handleNotifications()
+	defer listener.cancel()
+	body := `{"contentUris": ["http://annotations-rw/content/` + testUUID + `", "http://annotations-rw/content/` + otherTestUUID + `"]}`
+	require.NoError(t, listener.DeadLetters.Add(DeadLetter{ID: "1", Message: message("tid_1", body), Topic: "annotations", Uuid: otherTestUUID, EvType: UPDATE}))
+
+	require.NoError(t, listener.ReplayDeadLetter("1"))
+	waitFor(t, func() bool { letters, _ := listener.ListDeadLetters(); return len(letters) == 0 }, 2*time.Second)
+	require.Len(t, handler.Handled(), 1)
+	assert.Equal(t, otherTestUUID, handler.Handled()[0].Stub.Uuid)
+	assert.Equal(t, "1", handler.Handled()[0].DeadLetterID)
+}
+
+func TestKafkaListenerDoesNotReplayDeadLetterOfUnmappedContent(t *testing.T) {
+	listener, handler := newTestListener(0)
+	listener.DeadLetters = NewInMemoryDeadLetterStore()
+	require.NoError(t, listener.DeadLetters.Add(DeadLetter{ID: "1", Message: message("tid_1", testUUID+" UPDATE"), Uuid: otherTestUUID, EvType: UPDATE}))
+
+	assert.Error(t, listener.ReplayDeadLetter("1"))
+	assert.Empty(t, handler.Handled())
+	_, err := listener.DeadLetters.Get("1")
+	assert.NoError(t, err)
+}
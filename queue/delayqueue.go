@@ -0,0 +1,239 @@
+package queue
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"sort"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	delayQueueBucket      = []byte("delay-queue")
+	delayQueueIndexBucket = []byte("delay-queue-index")
+)
+
+// DelayedNotification is a notification waiting for its delay to pass before being handled. A UUID has several
+// of them when an UPDATE is received while a DELETE is pending, the ID telling them apart
+type DelayedNotification struct {
+	ID           string        `json:"id,omitempty"`
+	Notification *Notification `json:"notification"`
+	Received     time.Time     `json:"received"`
+	Due          time.Time     `json:"due"`
+	Coalesced    int           `json:"coalesced,omitempty"`
+}
+
+// DelayQueue keeps the notifications waiting for their delay, one per ID, so they are not lost when the service restarts.
+// List returns them in the order they are due
+type DelayQueue interface {
+	Schedule(delayed DelayedNotification) error
+	Remove(id string) error
+	List() ([]DelayedNotification, error)
+}
+
+// InMemoryDelayQueue keeps the delayed notifications until the service restarts
+type InMemoryDelayQueue struct {
+	sync.RWMutex
+	delayed map[string]DelayedNotification
+}
+
+func NewInMemoryDelayQueue() *InMemoryDelayQueue {
+	return &InMemoryDelayQueue{delayed: make(map[string]DelayedNotification)}
+}
+
+func (q *InMemoryDelayQueue) Schedule(delayed DelayedNotification) error {
+	q.Lock()
+	defer q.Unlock()
+	q.delayed[delayed.key()] = delayed
+	return nil
+}
+
+func (q *InMemoryDelayQueue) Remove(id string) error {
+	q.Lock()
+	defer q.Unlock()
+	delete(q.delayed, id)
+	return nil
+}
+
+func (q *InMemoryDelayQueue) List() ([]DelayedNotification, error) {
+	q.RLock()
+	defer q.RUnlock()
+	delayed := make([]DelayedNotification, 0, len(q.delayed))
+	for _, d := range q.delayed {
+		delayed = append(delayed, d)
+	}
+	sort.Slice(delayed, func(i, j int) bool {
+		return delayed[i].Due.Before(delayed[j].Due)
+	})
+	return delayed, nil
+}
+
+// key identifies the delayed notification in the queue. Notifications persisted before they had an ID are keyed by UUID
+func (d DelayedNotification) key() string {
+	if d.ID == "" {
+		return d.Notification.Stub.Uuid
+	}
+	return d.ID
+}
+
+// delayedWrite is a change of the delay queue: the notification scheduled with the ID, or its removal when nil
+type delayedWrite struct {
+	id      string
+	delayed *DelayedNotification
+}
+
+// delayQueueWriter applies the changes of the delay queue on its own goroutine, so the listener does not wait for the disk
+// while holding its lock. The changes are applied in order, only the last one of each ID when several are waiting
+type delayQueueWriter struct {
+	sync.Mutex
+	writes []delayedWrite
+	queued chan struct{}
+}
+
+func newDelayQueueWriter() *delayQueueWriter {
+	return &delayQueueWriter{queued: make(chan struct{}, 1)}
+}
+
+func (w *delayQueueWriter) schedule(delayed DelayedNotification) {
+	w.add(delayedWrite{id: delayed.key(), delayed: &delayed})
+}
+
+func (w *delayQueueWriter) remove(id string) {
+	w.add(delayedWrite{id: id})
+}
+
+func (w *delayQueueWriter) add(write delayedWrite) {
+	w.Lock()
+	w.writes = append(w.writes, write)
+	w.Unlock()
+	select {
+	case w.queued <- struct{}{}:
+	default:
+	}
+}
+
+// run applies the changes to the queue until the context is done, applying the ones left before returning
+func (w *delayQueueWriter) run(ctx context.Context, queue DelayQueue) {
+	for {
+		select {
+		case <-w.queued:
+			w.flush(queue)
+		case <-ctx.Done():
+			w.flush(queue)
+			return
+		}
+	}
+}
+
+func (w *delayQueueWriter) flush(queue DelayQueue) {
+	w.Lock()
+	writes := w.writes
+	w.writes = nil
+	w.Unlock()
+	last := make(map[string]int, len(writes))
+	for i, write := range writes {
+		last[write.id] = i
+	}
+	for i, write := range writes {
+		if last[write.id] != i {
+			continue
+		}
+		if write.delayed == nil {
+			if err := queue.Remove(write.id); err != nil {
+				log.WithField("delayed_id", write.id).WithError(err).Error("Failed to remove handled notification from the delay queue, it is handled again after a restart")
+			}
+		} else if err := queue.Schedule(*write.delayed); err != nil {
+			log.WithField("transaction_id", write.delayed.Notification.Tid).WithField("uuid", write.delayed.Notification.Stub.Uuid).WithError(err).Error("Failed to persist delayed notification, it is lost if the service restarts")
+		}
+	}
+}
+
+// BoltDelayQueue keeps the delayed notifications in a local file, keyed by due time so they are listed in order.
+// An index by ID finds the notification replaced when the same one is scheduled again
+type BoltDelayQueue struct {
+	db *bolt.DB
+}
+
+func NewBoltDelayQueue(path string) (*BoltDelayQueue, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		if _, err := tx.CreateBucketIfNotExists(delayQueueBucket); err != nil {
+			return err
+		}
+		_, err := tx.CreateBucketIfNotExists(delayQueueIndexBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &BoltDelayQueue{db: db}, nil
+}
+
+// dueKey orders the notifications by due time, the ID making the key unique
+func dueKey(due time.Time, id string) []byte {
+	key := make([]byte, 8, 8+len(id))
+	binary.BigEndian.PutUint64(key, uint64(due.UnixNano()))
+	return append(key, id...)
+}
+
+func (q *BoltDelayQueue) Schedule(delayed DelayedNotification) error {
+	value, err := json.Marshal(delayed)
+	if err != nil {
+		return err
+	}
+	id := []byte(delayed.key())
+	key := dueKey(delayed.Due, delayed.key())
+	return q.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(delayQueueIndexBucket)
+		if previous := index.Get(id); previous != nil {
+			if err := tx.Bucket(delayQueueBucket).Delete(previous); err != nil {
+				return err
+			}
+		}
+		if err := index.Put(id, key); err != nil {
+			return err
+		}
+		return tx.Bucket(delayQueueBucket).Put(key, value)
+	})
+}
+
+func (q *BoltDelayQueue) Remove(id string) error {
+	return q.db.Update(func(tx *bolt.Tx) error {
+		index := tx.Bucket(delayQueueIndexBucket)
+		key := index.Get([]byte(id))
+		if key == nil {
+			return nil
+		}
+		if err := tx.Bucket(delayQueueBucket).Delete(key); err != nil {
+			return err
+		}
+		return index.Delete([]byte(id))
+	})
+}
+
+func (q *BoltDelayQueue) List() ([]DelayedNotification, error) {
+	delayed := make([]DelayedNotification, 0)
+	err := q.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(delayQueueBucket).ForEach(func(_, value []byte) error {
+			var d DelayedNotification
+			if err := json.Unmarshal(value, &d); err != nil {
+				return err
+			}
+			delayed = append(delayed, d)
+			return nil
+		})
+	})
+	return delayed, err
+}
+
+func (q *BoltDelayQueue) Close() error {
+	return q.db.Close()
+}
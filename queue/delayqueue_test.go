@@ -0,0 +1,210 @@
+package queue
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// delayedNotification is the pending notification of the UUID, rescheduled when given another one of the same UUID
+func delayedNotification(uuid, tid string, evType EventType, due time.Time) DelayedNotification {
+	return DelayedNotification{
+		ID: uuid,
+		Notification: &Notification{
+			Stub:    content.Stub{Uuid: uuid},
+			EvType:  evType,
+			Tid:     tid,
+			Message: kafka.NewFTMessage(map[string]string{"X-Request-Id": tid}, uuid+" "+string(evType)),
+		},
+		Received: due.Add(-time.Minute),
+		Due:      due,
+	}
+}
+
+func testDelayQueue(t *testing.T, queue DelayQueue) {
+	now := time.Now().Round(0)
+	require.NoError(t, queue.Schedule(delayedNotification("uuid1", "tid_1", UPDATE, now.Add(time.Minute))))
+	require.NoError(t, queue.Schedule(delayedNotification("uuid2", "tid_2", UPDATE, now.Add(30*time.Second))))
+	require.NoError(t, queue.Schedule(delayedNotification("uuid1", "tid_3", DELETE, now)))
+
+	delayed, err := queue.List()
+	require.NoError(t, err)
+	require.Len(t, delayed, 2)
+	assert.Equal(t, "tid_3", delayed[0].Notification.Tid)
+	assert.Equal(t, DELETE, delayed[0].Notification.EvType)
+	assert.True(t, now.Equal(delayed[0].Due))
+	assert.Equal(t, "tid_2", delayed[1].Notification.Tid)
+	assert.Equal(t, "uuid2 UPDATE", delayed[1].Notification.Message.Body)
+
+	// Another pending notification of the same UUID is kept along
+	update := delayedNotification("uuid1", "tid_4", UPDATE, now.Add(2*time.Minute))
+	update.ID = "uuid1-2"
+	require.NoError(t, queue.Schedule(update))
+	delayed, err = queue.List()
+	require.NoError(t, err)
+	require.Len(t, delayed, 3)
+	assert.Equal(t, "tid_4", delayed[2].Notification.Tid)
+	require.NoError(t, queue.Remove("uuid1-2"))
+
+	require.NoError(t, queue.Remove("uuid1"))
+	require.NoError(t, queue.Remove("uuid3"))
+	delayed, err = queue.List()
+	require.NoError(t, err)
+	require.Len(t, delayed, 1)
+	assert.Equal(t, "uuid2", delayed[0].Notification.Stub.Uuid)
+}
+
+func TestInMemoryDelayQueue(t *testing.T) {
+	testDelayQueue(t, NewInMemoryDelayQueue())
+}
+
+func TestBoltDelayQueue(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-exporter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	queue, err := NewBoltDelayQueue(filepath.Join(dir, "delay-queue.db"))
+	require.NoError(t, err)
+	testDelayQueue(t, queue)
+	require.NoError(t, queue.Close())
+
+	reopened, err := NewBoltDelayQueue(filepath.Join(dir, "delay-queue.db"))
+	require.NoError(t, err)
+	defer reopened.Close()
+	delayed, err := reopened.List()
+	require.NoError(t, err)
+	assert.Len(t, delayed, 1)
+}
+
+func TestDelayQueueWriterAppliesLastChangeOfEachUUID(t *testing.T) {
+	queue := NewInMemoryDelayQueue()
+	require.NoError(t, queue.Schedule(delayedNotification("uuid3", "tid_0", UPDATE, time.Now())))
+	writer := newDelayQueueWriter()
+	writer.schedule(delayedNotification("uuid1", "tid_1", UPDATE, time.Now()))
+	writer.schedule(delayedNotification("uuid2", "tid_2", UPDATE, time.Now()))
+	writer.schedule(delayedNotification("uuid1", "tid_3", DELETE, time.Now()))
+	writer.remove("uuid2")
+	writer.remove("uuid3")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	writer.run(ctx, queue)
+
+	delayed, err := queue.List()
+	require.NoError(t, err)
+	require.Len(t, delayed, 1)
+	assert.Equal(t, "tid_3", delayed[0].Notification.Tid)
+}
+
+func TestKafkaListenerKeepsDelayedNotificationsUntilHandled(t *testing.T) {
+	listener, handler := newTestListener(100 * time.Millisecond)
+	listener.Delayed = NewInMemoryDelayQueue()
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 UPDATE")))
+	waitFor(t, func() bool {
+		delayed, _ := listener.Delayed.List()
+		return len(delayed) == 1
+	}, time.Second)
+	delayed, err := listener.Delayed.List()
+	require.NoError(t, err)
+	assert.Equal(t, "tid_1", delayed[0].Notification.Tid)
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	waitFor(t, func() bool {
+		delayed, _ := listener.Delayed.List()
+		return len(delayed) == 0
+	}, 2*time.Second)
+}
+
+func TestKafkaListenerKeepsDelayedNotificationsOnShutdown(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	listener.Delayed = NewInMemoryDelayQueue()
+	stopped := make(chan struct{})
+	go func() {
+		listener.handleNotifications()
+		close(stopped)
+	}()
+
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 UPDATE")))
+	time.Sleep(100 * time.Millisecond)
+	listener.cancel()
+	<-stopped
+
+	assert.Empty(t, handler.Handled())
+	delayed, err := listener.Delayed.List()
+	require.NoError(t, err)
+	assert.Len(t, delayed, 1)
+}
+
+func TestKafkaListenerRestoresDelayedNotifications(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	listener.Delayed = NewInMemoryDelayQueue()
+	require.NoError(t, listener.Delayed.Schedule(delayedNotification("uuid1", "tid_1", UPDATE, time.Now().Add(-time.Second))))
+	require.NoError(t, listener.Delayed.Schedule(delayedNotification("uuid2", "tid_2", UPDATE, time.Now().Add(time.Hour))))
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	listener.restoreDelayed()
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	assert.Equal(t, "tid_1", handler.Handled()[0].Tid)
+
+	// An UPDATE consumed again for a restored notification is coalesced with it
+	require.NoError(t, listener.HandleMessage(message("tid_3", "uuid2 UPDATE")))
+	listener.RLock()
+	defer listener.RUnlock()
+	assert.Len(t, listener.pending, 1)
+	assert.Equal(t, "tid_3", listener.pending["uuid2"].latest.Tid)
+}
+
+func TestKafkaListenerRestoresPendingDeleteAndUpdateOfSameUUID(t *testing.T) {
+	dir, err := ioutil.TempDir("", "content-exporter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+	queue, err := NewBoltDelayQueue(filepath.Join(dir, "delay-queue.db"))
+	require.NoError(t, err)
+
+	// The DELETE is still pending when the UPDATE is received, the service stopping before handling either
+	listener, _ := newTestListener(time.Minute)
+	listener.Delayed = queue
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 DELETE")))
+	require.NoError(t, listener.HandleMessage(message("tid_2", "uuid1 UPDATE")))
+	listener.cancel()
+	listener.delayedWrites.run(listener.ctx, queue)
+	require.NoError(t, queue.Close())
+
+	queue, err = NewBoltDelayQueue(filepath.Join(dir, "delay-queue.db"))
+	require.NoError(t, err)
+	defer queue.Close()
+	delayed, err := queue.List()
+	require.NoError(t, err)
+	require.Len(t, delayed, 2)
+
+	restarted, handler := newTestListener(time.Minute)
+	restarted.Delayed = queue
+	go restarted.handleNotifications()
+	defer restarted.cancel()
+	restarted.restoreDelayed()
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	assert.Equal(t, "tid_1", handler.Handled()[0].Tid)
+	waitFor(t, func() bool {
+		delayed, _ := queue.List()
+		return len(delayed) == 1
+	}, 2*time.Second)
+	delayed, err = queue.List()
+	require.NoError(t, err)
+	assert.Equal(t, "tid_2", delayed[0].Notification.Tid)
+	restarted.RLock()
+	defer restarted.RUnlock()
+	assert.Equal(t, "tid_2", restarted.pending["uuid1"].latest.Tid)
+}
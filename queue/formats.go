@@ -0,0 +1,171 @@
+package queue
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	log "github.com/sirupsen/logrus"
+)
+
+// Formats of the notification messages, each understood by its own mapper
+const (
+	PostPublicationFormat = "post-publication"
+	CloudEventsFormat     = "cloudevents"
+	MinimalFormat         = "minimal"
+	MetadataFormat        = "metadata"
+)
+
+// ErrUnknownMessageFormat is returned when no mapper understands the requested message format
+var ErrUnknownMessageFormat = errors.New("Unknown message format")
+
+// NewMessageMapper returns the mapper of the given message format. The whitelist applies to the URIs of the events
+func NewMessageMapper(format string, whitelistR *regexp.Regexp) (MessageMapper, error) {
+	switch format {
+	case PostPublicationFormat, "":
+		return NewKafkaMessageMapper(whitelistR), nil
+	case CloudEventsFormat:
+		return NewCloudEventsMapper(whitelistR), nil
+	case MinimalFormat:
+		return NewMinimalMessageMapper(), nil
+	case MetadataFormat:
+		return NewMetadataMessageMapper(whitelistR), nil
+	}
+	return nil, fmt.Errorf("%w: %v", ErrUnknownMessageFormat, format)
+}
+
+func isSynthetic(tid string) bool {
+	return strings.HasPrefix(tid, "SYNTH")
+}
+
+// CloudEventsMapper maps CloudEvents JSON envelopes. The subject holds the content UUID, the source is matched
+// against the whitelist and event types ending with "deleted" or "delete" are DELETE events, the others UPDATE events.
+// The data, when it is a JSON object, is the content itself. Without it, like with string data or data_base64,
+// the date of the content and whether it can be distributed are read from the exported content
+type CloudEventsMapper struct {
+	WhiteListRegex *regexp.Regexp
+}
+
+func NewCloudEventsMapper(whitelistR *regexp.Regexp) *CloudEventsMapper {
+	return &CloudEventsMapper{WhiteListRegex: whitelistR}
+}
+
+type cloudEvent struct {
+	SpecVersion string          `json:"specversion"`
+	ID          string          `json:"id"`
+	Type        string          `json:"type"`
+	Source      string          `json:"source"`
+	Subject     string          `json:"subject"`
+	Data        json.RawMessage `json:"data"`
+}
+
+// dataObject returns the data of the event when it is a JSON object, nil otherwise
+func (ev cloudEvent) dataObject() map[string]interface{} {
+	data := bytes.TrimSpace(ev.Data)
+	if len(data) == 0 || data[0] != '{' {
+		return nil
+	}
+	var object map[string]interface{}
+	if err := json.Unmarshal(data, &object); err != nil {
+		return nil
+	}
+	return object
+}
+
+func (m *CloudEventsMapper) MapNotification(msg kafka.FTMessage) (*Notification, error) {
+	var ev cloudEvent
+	if err := json.Unmarshal([]byte(msg.Body), &ev); err != nil {
+		log.WithField("transaction_id", msg.Headers["X-Request-Id"]).WithField("msg", msg.Body).WithError(err).Warn("Skipping event.")
+		return nil, err
+	}
+	tid := msg.Headers["X-Request-Id"]
+	if tid == "" {
+		tid = ev.ID
+	}
+	if ev.SpecVersion == "" || ev.Type == "" {
+		log.WithField("transaction_id", tid).WithField("msg", msg.Body).Warn("Skipping event: Not a CloudEvent.")
+		return nil, fmt.Errorf("Message is not a CloudEvent")
+	}
+	if isSynthetic(tid) {
+		log.WithField("transaction_id", tid).WithField("source", ev.Source).Info("Skipping event: Synthetic transaction ID.")
+		return nil, nil
+	}
+	if !m.WhiteListRegex.MatchString(ev.Source) {
+		log.WithField("transaction_id", tid).WithField("source", ev.Source).Info("Skipping event: It is not in the whitelist.")
+		return nil, nil
+	}
+	uuid := UUIDRegexp.FindString(ev.Subject)
+	if uuid == "" {
+		log.WithField("transaction_id", tid).WithField("msg", msg.Body).Warn("Skipping event: Cannot build notification for message.")
+		return nil, fmt.Errorf("Subject does not contain a UUID")
+	}
+
+	evType := UPDATE
+	eventType := strings.ToLower(ev.Type)
+	if strings.HasSuffix(eventType, "deleted") || strings.HasSuffix(eventType, "delete") {
+		evType = DELETE
+	}
+	data := ev.dataObject()
+	date := content.UnknownDate
+	if data != nil {
+		date = content.GetDateOrDefault(data)
+	}
+	n := &Notification{
+		Stub:   content.Stub{Uuid: uuid, Date: date},
+		EvType: evType,
+		Tid:    tid,
+	}
+	if canBeDistributed, ok := data["canBeDistributed"].(string); ok {
+		n.Stub.CanBeDistributed = &canBeDistributed
+		if canBeDistributed != canBeDistributedYes {
+			log.WithField("transaction_id", tid).WithField("uuid", uuid).Warn("Skipping event: Content cannot be distributed.")
+			return nil, nil
+		}
+	}
+	return n, nil
+}
+
+// MinimalMessageMapper maps events like {"uuid": "...", "action": "UPDATE"}. They carry no URI, so no whitelist applies,
+// and no date: it is read from the exported content
+type MinimalMessageMapper struct{}
+
+func NewMinimalMessageMapper() *MinimalMessageMapper {
+	return &MinimalMessageMapper{}
+}
+
+type minimalEvent struct {
+	UUID   string `json:"uuid"`
+	Action string `json:"action"`
+}
+
+func (m *MinimalMessageMapper) MapNotification(msg kafka.FTMessage) (*Notification, error) {
+	tid := msg.Headers["X-Request-Id"]
+	var ev minimalEvent
+	if err := json.Unmarshal([]byte(msg.Body), &ev); err != nil {
+		log.WithField("transaction_id", tid).WithField("msg", msg.Body).WithError(err).Warn("Skipping event.")
+		return nil, err
+	}
+	if isSynthetic(tid) {
+		log.WithField("transaction_id", tid).WithField("uuid", ev.UUID).Info("Skipping event: Synthetic transaction ID.")
+		return nil, nil
+	}
+	if UUIDRegexp.FindString(ev.UUID) != ev.UUID || ev.UUID == "" {
+		log.WithField("transaction_id", tid).WithField("msg", msg.Body).Warn("Skipping event: Cannot build notification for message.")
+		return nil, fmt.Errorf("Invalid UUID: %v", ev.UUID)
+	}
+	evType := EventType(strings.ToUpper(ev.Action))
+	if evType != UPDATE && evType != DELETE {
+		log.WithField("transaction_id", tid).WithField("msg", msg.Body).Warn("Skipping event: Cannot build notification for message.")
+		return nil, fmt.Errorf("Unknown action: %v", ev.Action)
+	}
+	return &Notification{
+		Stub:   content.Stub{Uuid: ev.UUID, Date: content.UnknownDate},
+		EvType: evType,
+		Tid:    tid,
+	}, nil
+}
@@ -0,0 +1,134 @@
+package queue
+
+import (
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const testUUID = "e0e7ba2c-5b2a-4f4e-a6a1-4b3b8b2d1f0c"
+
+func TestNewMessageMapper(t *testing.T) {
+	whitelist := regexp.MustCompile(".*")
+	for format, expected := range map[string]MessageMapper{
+		"":                    &KafkaMessageMapper{},
+		PostPublicationFormat: &KafkaMessageMapper{},
+		CloudEventsFormat:     &CloudEventsMapper{},
+		MinimalFormat:         &MinimalMessageMapper{},
+		MetadataFormat:        &MetadataMessageMapper{},
+	} {
+		mapper, err := NewMessageMapper(format, whitelist)
+		require.NoError(t, err)
+		assert.IsType(t, expected, mapper, format)
+	}
+
+	_, err := NewMessageMapper("avro", whitelist)
+	assert.True(t, errors.Is(err, ErrUnknownMessageFormat))
+}
+
+func TestCloudEventsMapperMapsUpdate(t *testing.T) {
+	mapper := NewCloudEventsMapper(regexp.MustCompile("^/upp/content"))
+	body := `{"specversion":"1.0","id":"event_1","type":"com.ft.content.published","source":"/upp/content","subject":"` + testUUID + `",
+		"data":{"publishedDate":"2020-01-30T10:00:00Z","canBeDistributed":"yes"}}`
+
+	n, err := mapper.MapNotification(kafka.NewFTMessage(map[string]string{}, body))
+	require.NoError(t, err)
+	assert.Equal(t, UPDATE, n.EvType)
+	assert.Equal(t, "event_1", n.Tid)
+	assert.Equal(t, testUUID, n.Stub.Uuid)
+	assert.Equal(t, "2020-01-30", n.Stub.Date)
+}
+
+func TestCloudEventsMapperMapsDelete(t *testing.T) {
+	mapper := NewCloudEventsMapper(regexp.MustCompile("^/upp/content"))
+	body := `{"specversion":"1.0","id":"event_1","type":"com.ft.content.deleted","source":"/upp/content","subject":"/content/` + testUUID + `"}`
+
+	n, err := mapper.MapNotification(kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1"}, body))
+	require.NoError(t, err)
+	assert.Equal(t, DELETE, n.EvType)
+	assert.Equal(t, "tid_1", n.Tid)
+	assert.Equal(t, testUUID, n.Stub.Uuid)
+	assert.Equal(t, content.UnknownDate, n.Stub.Date)
+}
+
+func TestCloudEventsMapperMapsEventsWithDataOtherThanObject(t *testing.T) {
+	mapper := NewCloudEventsMapper(regexp.MustCompile("^/upp/content"))
+	for name, data := range map[string]string{
+		"string data": `"data":"<xml/>"`,
+		"base64 data": `"data_base64":"eyJwdWJsaXNoZWREYXRlIjoiMjAyMC0wMS0zMFQxMDowMDowMFoifQ=="`,
+		"null data":   `"data":null`,
+	} {
+		body := `{"specversion":"1.0","id":"event_1","type":"com.ft.content.published","source":"/upp/content","subject":"` + testUUID + `",` + data + `}`
+		n, err := mapper.MapNotification(kafka.NewFTMessage(map[string]string{}, body))
+		require.NoError(t, err, name)
+		assert.Equal(t, UPDATE, n.EvType, name)
+		assert.Equal(t, testUUID, n.Stub.Uuid, name)
+		assert.Equal(t, content.UnknownDate, n.Stub.Date, name)
+		assert.Nil(t, n.Stub.CanBeDistributed, name)
+	}
+}
+
+func TestCloudEventsMapperSkipsEvents(t *testing.T) {
+	mapper := NewCloudEventsMapper(regexp.MustCompile("^/upp/content"))
+	for name, body := range map[string]string{
+		"not whitelisted":          `{"specversion":"1.0","type":"com.ft.content.published","source":"/other","subject":"` + testUUID + `"}`,
+		"cannot be distributed":    `{"specversion":"1.0","type":"com.ft.content.published","source":"/upp/content","subject":"` + testUUID + `","data":{"canBeDistributed":"verify"}}`,
+		"synthetic transaction id": `{"specversion":"1.0","id":"SYNTH_1","type":"com.ft.content.published","source":"/upp/content","subject":"` + testUUID + `"}`,
+	} {
+		n, err := mapper.MapNotification(kafka.NewFTMessage(map[string]string{}, body))
+		assert.NoError(t, err, name)
+		assert.Nil(t, n, name)
+	}
+}
+
+func TestCloudEventsMapperRejectsInvalidEvents(t *testing.T) {
+	mapper := NewCloudEventsMapper(regexp.MustCompile(".*"))
+	for name, body := range map[string]string{
+		"not json":        `{`,
+		"not cloud event": `{"ContentURI":"http://upp-content-validator.svc.ft.com/content/` + testUUID + `"}`,
+		"no uuid":         `{"specversion":"1.0","type":"com.ft.content.published","source":"/upp/content","subject":"content"}`,
+	} {
+		n, err := mapper.MapNotification(kafka.NewFTMessage(map[string]string{}, body))
+		assert.Error(t, err, name)
+		assert.Nil(t, n, name)
+	}
+}
+
+func TestMinimalMessageMapper(t *testing.T) {
+	mapper := NewMinimalMessageMapper()
+
+	n, err := mapper.MapNotification(kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1"}, `{"uuid":"`+testUUID+`","action":"update"}`))
+	require.NoError(t, err)
+	assert.Equal(t, UPDATE, n.EvType)
+	assert.Equal(t, "tid_1", n.Tid)
+	assert.Equal(t, testUUID, n.Stub.Uuid)
+	assert.Equal(t, content.UnknownDate, n.Stub.Date)
+	assert.Nil(t, n.Stub.CanBeDistributed)
+
+	n, err = mapper.MapNotification(kafka.NewFTMessage(map[string]string{}, `{"uuid":"`+testUUID+`","action":"DELETE"}`))
+	require.NoError(t, err)
+	assert.Equal(t, DELETE, n.EvType)
+
+	n, err = mapper.MapNotification(kafka.NewFTMessage(map[string]string{"X-Request-Id": "SYNTH_1"}, `{"uuid":"`+testUUID+`","action":"UPDATE"}`))
+	assert.NoError(t, err)
+	assert.Nil(t, n)
+}
+
+func TestMinimalMessageMapperRejectsInvalidEvents(t *testing.T) {
+	mapper := NewMinimalMessageMapper()
+	for name, body := range map[string]string{
+		"not json":       `{`,
+		"invalid uuid":   `{"uuid":"not-a-uuid","action":"UPDATE"}`,
+		"padded uuid":    `{"uuid":"x` + testUUID + `","action":"UPDATE"}`,
+		"unknown action": `{"uuid":"` + testUUID + `","action":"PUBLISH"}`,
+	} {
+		n, err := mapper.MapNotification(kafka.NewFTMessage(map[string]string{}, body))
+		assert.Error(t, err, name)
+		assert.Nil(t, n, name)
+	}
+}
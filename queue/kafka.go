@@ -1,12 +1,17 @@
 package queue
 
 import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
 	"sync"
 	"time"
 
+	"github.com/Financial-Times/content-exporter/content"
 	"github.com/Financial-Times/content-exporter/export"
 	"github.com/Financial-Times/kafka-client-go/kafka"
-	"errors"
+	"github.com/pborman/uuid"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -14,29 +19,87 @@ type MessageHandler interface {
 	HandleMessage(queueMsg kafka.FTMessage) error
 }
 
+// Notifications are spread over at least minShards shards, so that the concurrency can be raised at runtime
+const minShards = 256
+
+// maxDelayFactor bounds how far repeated events can push back the handling of a UUID, in multiples of the delay
+const maxDelayFactor = 4
+
+// pendingNotification is the latest notification received for a UUID, waiting for its delay to pass.
+// Events received meanwhile replace it, so only the last one is handled
+type pendingNotification struct {
+	// id tells apart the pending notifications of a UUID in the delay queue
+	id        string
+	uuid      string
+	latest    *Notification
+	received  time.Time
+	due       time.Time
+	coalesced int
+	started   bool
+	// index of the notification in the due order of its shard, -1 while it is not there
+	index int
+	// next is the notification of the same UUID received after this one started or while it is a DELETE,
+	// added to the shard once this one has started
+	next *pendingNotification
+	// acks of the messages of the notification and of the ones coalesced into it, given the outcome of the handling
+	acks []func(error)
+}
+
+func (p *pendingNotification) ack(err error) {
+	for _, ack := range p.acks {
+		ack(err)
+	}
+}
+
 type KafkaListener struct {
-	messageConsumer kafka.Consumer
+	messageConsumer Consumer
 	*export.Locker
 	sync.RWMutex
-	paused bool
-	*export.Terminator
-	received                   chan *Notification
-	pending                    map[string]*Notification
+	paused                     bool
+	ctx                        context.Context
+	cancel                     context.CancelFunc
+	stopped                    chan struct{}
+	pending                    map[string]*pendingNotification
+	delay                      time.Duration
+	shards                     []*shard
 	ContentNotificationHandler ContentNotificationHandler
 	MessageMapper              MessageMapper
-	worker                     chan struct{}
+	// Breakers of the upstreams the notifications are exported to. Handling pauses while any of them is open
+	Breakers []*content.CircuitBreaker
+	// DeadLetters keeps the notifications whose handling failed, so they can be replayed
+	DeadLetters DeadLetterStore
+	// Delayed keeps the notifications waiting for their delay, so they are handled after a restart
+	Delayed       DelayQueue
+	delayedWrites *delayQueueWriter
+	// Topics maps the messages of each topic with their own mapper and delay. The MessageMapper and the delay
+	// of the listener apply to the other messages
+	Topics  map[string]TopicConfig
+	workers *export.Semaphore
 }
 
-func NewKafkaListener(messageConsumer kafka.Consumer, notificationHandler *KafkaContentNotificationHandler, messageMapper *KafkaMessageMapper, locker *export.Locker, maxGoRoutines int) *KafkaListener {
+func NewKafkaListener(messageConsumer Consumer, notificationHandler *KafkaContentNotificationHandler, messageMapper MessageMapper, locker *export.Locker, maxGoRoutines int, delay time.Duration) *KafkaListener {
+	ctx, cancel := context.WithCancel(context.Background())
+	count := minShards
+	if maxGoRoutines > count {
+		count = maxGoRoutines
+	}
+	shards := make([]*shard, count)
+	for i := range shards {
+		shards[i] = newShard()
+	}
 	return &KafkaListener{
 		messageConsumer:            messageConsumer,
 		Locker:                     locker,
-		received:                   make(chan *Notification, 1),
-		pending:                    make(map[string]*Notification),
-		Terminator:                 export.NewTerminator(),
+		ctx:                        ctx,
+		cancel:                     cancel,
+		stopped:                    make(chan struct{}),
+		pending:                    make(map[string]*pendingNotification),
+		delayedWrites:              newDelayQueueWriter(),
+		delay:                      delay,
+		shards:                     shards,
 		ContentNotificationHandler: notificationHandler,
 		MessageMapper:              messageMapper,
-		worker:                     make(chan struct{}, maxGoRoutines),
+		workers:                    export.NewSemaphore(maxGoRoutines),
 	}
 }
 
@@ -46,6 +109,9 @@ func (h *KafkaListener) resumeConsuming() {
 	log.Debugf("DEBUG resumeConsuming")
 	if h.paused {
 		h.paused = false
+		if h.messageConsumer != nil {
+			h.messageConsumer.Resume()
+		}
 	}
 }
 
@@ -55,17 +121,60 @@ func (h *KafkaListener) pauseConsuming() {
 	log.Debugf("DEBUG pauseConsuming")
 	if !h.paused {
 		h.paused = true
+		if h.messageConsumer != nil {
+			h.messageConsumer.Pause()
+		}
 	}
 }
 
-func (h *KafkaListener) ConsumeMessages() {
-	h.messageConsumer.StartListening(h.HandleMessage)
-	go h.handleNotifications()
+func (h *KafkaListener) isPaused() bool {
+	h.RLock()
+	defer h.RUnlock()
+	return h.paused
+}
 
-	defer func() {
-		h.Terminator.ShutDownPrepared = true
-		h.TerminatePendingNotifications()
+// waitWhilePaused blocks until consuming is resumed, returning false if the listener is stopped meanwhile
+func (h *KafkaListener) waitWhilePaused() bool {
+	for h.isPaused() {
+		select {
+		case <-h.ctx.Done():
+			return false
+		case <-time.After(time.Millisecond * 500):
+		}
+	}
+	return true
+}
+
+// waitForBreakers blocks while any of the breakers is open, returning false if the listener is stopped meanwhile
+func (h *KafkaListener) waitForBreakers(tid string) bool {
+	open := content.OpenBreakers(h.Breakers)
+	if len(open) == 0 {
+		return true
+	}
+	log.WithField("transaction_id", tid).Warnf("PAUSED handling notification, circuit breaker of %v is open", open)
+	for len(open) != 0 {
+		select {
+		case <-h.ctx.Done():
+			return false
+		case <-time.After(content.BreakerPollInterval):
+		}
+		open = content.OpenBreakers(h.Breakers)
+	}
+	log.WithField("transaction_id", tid).Info("Circuit breakers closed. Resuming handling notification")
+	return true
+}
+
+func (h *KafkaListener) ConsumeMessages() {
+	handled := make(chan struct{})
+	go func() {
+		h.handleNotifications()
+		close(handled)
 	}()
+	h.restoreDelayed()
+	h.messageConsumer.StartListening(h.handleMessage)
+
+	defer close(h.stopped)
+	defer func() { <-handled }()
 	defer h.messageConsumer.Shutdown()
 
 	for {
@@ -83,101 +192,423 @@ func (h *KafkaListener) ConsumeMessages() {
 			} else {
 				h.resumeConsuming()
 			}
-		case <-h.Quit:
+		case <-h.ctx.Done():
 			log.Infof("QUIT signal received...")
 			return
 		}
 	}
 }
 
+// StopConsumingMessages cancels the pending notifications and waits for the consumption to stop
 func (h *KafkaListener) StopConsumingMessages() {
-	h.Quit <- struct{}{}
-	for {
-		if h.ShutDown {
-			return
-		}
-		time.Sleep(time.Millisecond * 100)
-	}
+	h.cancel()
+	<-h.stopped
 }
 
+// HandleMessage handles a message which has no offset to commit
 func (h *KafkaListener) HandleMessage(msg kafka.FTMessage) error {
-	if h.ShutDownPrepared {
-		h.Cleanup.Do(func() {
-			close(h.received)
-		})
+	return h.handleMessage("", msg, nil)
+}
+
+// handleMessage hands the notifications of the message to the shards. The message is acked once its notifications,
+// or the ones they are coalesced into, have been handled. Messages which are not mapped to a notification are acked right away
+func (h *KafkaListener) handleMessage(topic string, msg kafka.FTMessage, ack func()) error {
+	if h.ctx.Err() != nil {
 		return errors.New("Service is shutdown")
 	}
 
-	tid := msg.Headers["X-Request-Id"]
+	notifications, err := mapNotifications(h.mapperOf(topic), msg)
+	if len(notifications) == 0 {
+		if ack != nil {
+			ack()
+		}
+		return err
+	}
+	var handled func(error)
+	if ack != nil {
+		handled = func(error) { ack() }
+	}
+	if enqueueErr := h.enqueue(topic, msg, notifications, handled); enqueueErr != nil {
+		return enqueueErr
+	}
+	return err
+}
 
-	if h.paused {
-		log.WithField("transaction_id", tid).Info("PAUSED handling message")
-		for h.paused {
-			if h.ShutDownPrepared {
-				h.Cleanup.Do(func() {
-					close(h.received)
-				})
-				return errors.New("Service is shutdown")
-			}
-			time.Sleep(time.Millisecond * 500)
+// enqueue registers the notifications of the message as pending in their shards.
+// ack is called with the first failure, if any, once every notification has been handled
+func (h *KafkaListener) enqueue(topic string, msg kafka.FTMessage, notifications []*Notification, ack func(error)) error {
+	if h.ctx.Err() != nil {
+		log.WithField("transaction_id", msg.Headers["X-Request-Id"]).Error("Notification handling is terminated")
+		return errors.New("Notification handling is terminated")
+	}
+	ack = ackAfter(len(notifications), ack)
+	for _, n := range notifications {
+		n.Message = msg
+		n.Topic = topic
+		h.addPending(n, ack)
+	}
+	return nil
+}
+
+// ackAfter acks a message concerning several contents once the notification of each of them has been handled,
+// with the first failure if any
+func ackAfter(count int, ack func(error)) func(error) {
+	if ack == nil || count == 1 {
+		return ack
+	}
+	var lock sync.Mutex
+	remaining := count
+	var failure error
+	return func(err error) {
+		lock.Lock()
+		defer lock.Unlock()
+		if failure == nil {
+			failure = err
+		}
+		if remaining--; remaining == 0 {
+			ack(failure)
 		}
-		log.WithField("transaction_id", tid).Info("PAUSE finished. Resuming handling messages")
 	}
+}
 
-	n, err := h.MessageMapper.MapNotification(msg)
-	if n == nil {
-		return err
+// addPending registers the notification, replacing the one pending for the same UUID if its handling has not started
+func (h *KafkaListener) addPending(n *Notification, ack func(error)) {
+	now := time.Now()
+	due := now
+	delay := delayOf(h.Topics, n.Topic, h.delay)
+	if n.EvType == UPDATE {
+		due = now.Add(delay)
 	}
+
 	h.Lock()
-	h.pending[n.Tid] = n
-	h.Unlock()
+	defer h.Unlock()
+	p, ok := h.pending[n.Stub.Uuid]
+	// An UPDATE never replaces a pending DELETE, it is handled after it instead. The DELETE would be lost
+	// to a metadata change, and the content would stay exported
+	if ok && !p.started && !(p.latest.EvType == DELETE && n.EvType == UPDATE) {
+		// An UPDATE from a topic with a shorter delay does not bring forward the pending notification
+		if n.EvType == UPDATE && due.Before(p.due) {
+			due = p.due
+		} else if max := p.received.Add(delay * maxDelayFactor); due.After(max) {
+			due = max
+		}
+		// Events of some formats do not carry the date of the content, the one already known is kept
+		if n.Stub.Date == content.UnknownDate && p.latest.Stub.Date != content.UnknownDate {
+			n.Stub.Date = p.latest.Stub.Date
+		}
+		// The dead letter being replayed is removed once the notification replacing it is handled
+		if n.DeadLetterID == "" {
+			n.DeadLetterID = p.latest.DeadLetterID
+		}
+		p.latest = n
+		p.due = due
+		p.coalesced++
+		if ack != nil {
+			p.acks = append(p.acks, ack)
+		}
+		h.shardOf(p.uuid).fix(p)
+		log.WithField("transaction_id", n.Tid).WithField("uuid", n.Stub.Uuid).Infof("%v event coalesced with %v pending event(s), handling it at %v", n.EvType, p.coalesced, due.Format(time.RFC3339))
+		h.schedule(p)
+		return
+	}
+	p = &pendingNotification{id: uuid.New(), uuid: n.Stub.Uuid, latest: n, received: now, due: due}
+	if ack != nil {
+		p.acks = append(p.acks, ack)
+	}
+	h.add(p)
+	if n.EvType == UPDATE {
+		log.WithField("transaction_id", n.Tid).WithField("uuid", n.Stub.Uuid).Infof("UPDATE event received. Waiting configured delay - %v", delay)
+	}
+	h.schedule(p)
+}
+
+// add registers a new pending notification in its shard. It is called with the lock held. A notification of a UUID
+// whose previous one has not started yet waits behind it, so the events of a content are never applied out of order
+func (h *KafkaListener) add(p *pendingNotification) {
+	p.index = -1
+	previous, ok := h.pending[p.uuid]
+	h.pending[p.uuid] = p
+	if ok && !previous.started {
+		previous.next = p
+		return
+	}
+	h.shardOf(p.uuid).push(p)
+}
+
+func (h *KafkaListener) shardOf(uuid string) *shard {
+	return h.shards[shardOf(uuid, len(h.shards))]
+}
+
+// schedule queues the pending notification to be persisted in the delay queue. It is called with the lock held,
+// so the delay queue follows the order in which the notifications of a UUID are received and handled
+func (h *KafkaListener) schedule(p *pendingNotification) {
+	if h.Delayed == nil {
+		return
+	}
+	h.delayedWrites.schedule(DelayedNotification{ID: p.id, Notification: p.latest, Received: p.received, Due: p.due, Coalesced: p.coalesced})
+}
+
+// restoreDelayed hands over again the notifications which were waiting for their delay when the service stopped.
+// Their Kafka messages may be consumed again too, being coalesced with the restored notifications
+func (h *KafkaListener) restoreDelayed() {
+	if h.Delayed == nil {
+		return
+	}
+	delayed, err := h.Delayed.List()
+	if err != nil {
+		log.WithError(err).Error("Failed to read delayed notifications, they are not handled")
+		return
+	}
+	// The notifications of a UUID are added in the order they were received, so they are handled in that order again
+	sort.SliceStable(delayed, func(i, j int) bool {
+		return delayed[i].Received.Before(delayed[j].Received)
+	})
+	for _, d := range delayed {
+		h.Lock()
+		p := &pendingNotification{id: d.key(), uuid: d.Notification.Stub.Uuid, latest: d.Notification, received: d.Received, due: d.Due, coalesced: d.Coalesced}
+		h.add(p)
+		h.Unlock()
+		log.WithField("transaction_id", d.Notification.Tid).WithField("uuid", p.uuid).Infof("Restored delayed %v event, handling it at %v", d.Notification.EvType, d.Due.Format(time.RFC3339))
+	}
+	if len(delayed) > 0 {
+		log.Infof("Restored %v delayed notification(s)", len(delayed))
+	}
+}
+
+// nextDue waits for the first pending notification of the shard to be due, marks it as started and returns it
+// with its latest notification. Returns nil if the listener is stopped meanwhile
+func (h *KafkaListener) nextDue(s *shard) (*pendingNotification, *Notification) {
+	for {
+		h.Lock()
+		p := s.head()
+		var wait time.Duration
+		if p != nil {
+			if wait = time.Until(p.due); wait <= 0 {
+				s.pop()
+				p.started = true
+				n := p.latest
+				h.Unlock()
+				return p, n
+			}
+		}
+		h.Unlock()
+
+		// The shard is woken up when a notification is added or its due time changes, as it may become the first one
+		if !h.sleep(s, p != nil, wait) {
+			return nil, nil
+		}
+	}
+}
+
+// sleep waits for the shard to be woken up, or for the given time if timed. Returns false if the listener is stopped meanwhile
+func (h *KafkaListener) sleep(s *shard, timed bool, wait time.Duration) bool {
+	var due <-chan time.Time
+	if timed {
+		timer := time.NewTimer(wait)
+		defer timer.Stop()
+		due = timer.C
+	}
 	select {
-	case h.received <- n:
-	case <-n.Quit:
-		log.WithField("transaction_id", tid).WithField("uuid", n.Stub.Uuid).Error("Notification handling is terminated")
-		return errors.New("Notification handling is terminated")
+	case <-due:
+	case <-s.wake:
+	case <-h.ctx.Done():
+		return false
+	}
+	return true
+}
 
+func (h *KafkaListener) donePending(p *pendingNotification) {
+	h.Lock()
+	defer h.Unlock()
+	if h.Delayed != nil {
+		h.delayedWrites.remove(p.id)
 	}
-	if h.ShutDownPrepared {
-		h.Cleanup.Do(func() {
-			close(h.received)
-		})
+	if h.pending[p.uuid] == p {
+		delete(h.pending, p.uuid)
 	}
-	return err
 }
 
+// handleNotifications handles the notifications of each shard as they are due, one at a time. The notifications
+// of a UUID always go to the same shard and are never applied out of order, while the ones of other UUIDs are not
+// held back by their delay
 func (h *KafkaListener) handleNotifications() {
 	log.Info("Started handling notifications")
-	for n := range h.received {
-		if h.paused {
+	var wg sync.WaitGroup
+	defer func() {
+		wg.Wait()
+		log.Info("Stopped handling notifications")
+	}()
+	if h.Delayed != nil {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			h.delayedWrites.run(h.ctx, h.Delayed)
+		}()
+	}
+	for _, s := range h.shards {
+		wg.Add(1)
+		go func(s *shard) {
+			defer wg.Done()
+			h.handleShard(s)
+		}(s)
+	}
+	<-h.ctx.Done()
+}
+
+func (h *KafkaListener) handleShard(s *shard) {
+	for {
+		p, n := h.nextDue(s)
+		if n == nil {
+			return
+		}
+		if h.isPaused() {
 			log.WithField("transaction_id", n.Tid).Info("PAUSED handling notification")
-			for h.paused {
-				time.Sleep(time.Millisecond * 500)
+			if !h.waitWhilePaused() {
+				return
 			}
 			log.WithField("transaction_id", n.Tid).Info("PAUSE finished. Resuming handling notification")
 		}
-		h.worker <- struct{}{}
-		go func(notification *Notification) {
-			defer func() { <-h.worker }()
-			if err := h.ContentNotificationHandler.HandleContentNotification(notification); err != nil {
-				log.WithField("transaction_id", notification.Tid).WithField("uuid", notification.Stub.Uuid).WithError(err).Error("Failed notification handling")
-			}
-			h.Lock()
-			delete(h.pending, notification.Tid)
-			h.Unlock()
-		}(n)
+		if !h.waitForBreakers(n.Tid) {
+			return
+		}
+		if err := h.workers.Acquire(h.ctx); err != nil {
+			return
+		}
+		err := h.ContentNotificationHandler.HandleContentNotification(h.ctx, n)
+		if err != nil {
+			h.failed(n, err)
+		} else if n.DeadLetterID != "" {
+			h.replayed(n)
+		}
+		h.workers.Release()
+		// Notifications interrupted by the shutdown stay in the delay queue and are not acked, to be handled again.
+		// Failed notifications are kept as dead letters, so they are done too
+		if h.ctx.Err() != nil {
+			return
+		}
+		h.donePending(p)
+		p.ack(err)
 	}
-	log.Info("Stopped handling notifications")
-	h.ShutDown = true
 }
 
-func (h *KafkaListener) TerminatePendingNotifications() {
-	h.RLock()
-	for _, n := range h.pending {
-		n.Quit <- struct{}{}
-		close(n.Quit)
+// failed logs the failure and keeps the notification as a dead letter, unless the listener is stopping.
+// A replayed dead letter failing again is updated in place, adding up the attempts
+func (h *KafkaListener) failed(n *Notification, err error) {
+	logEntry := log.WithField("transaction_id", n.Tid).WithField("uuid", n.Stub.Uuid)
+	id := n.DeadLetterID
+	if id == "" {
+		id = uuid.New()
+	}
+	letter := DeadLetter{
+		ID:       id,
+		Message:  n.Message,
+		Topic:    n.Topic,
+		Uuid:     n.Stub.Uuid,
+		EvType:   n.EvType,
+		Error:    err.Error(),
+		FailedAt: time.Now(),
+	}
+	// A failure before any call, like a mapping or validation error, is still an attempt
+	letter.Attempts = 1
+	var attemptsErr *content.AttemptsError
+	if errors.As(err, &attemptsErr) && attemptsErr.Attempts > 0 {
+		letter.Attempts = attemptsErr.Attempts
+	}
+	logEntry.WithField("attempts", letter.Attempts).WithError(err).Error("Failed notification handling")
+
+	if h.DeadLetters == nil || h.ctx.Err() != nil {
+		return
+	}
+	if n.DeadLetterID != "" {
+		if previous, err := h.DeadLetters.Get(n.DeadLetterID); err == nil {
+			letter.Attempts += previous.Attempts
+		}
+	}
+	if err := h.DeadLetters.Add(letter); err != nil {
+		logEntry.WithError(err).Error("Failed to store dead letter, notification is lost")
+		return
+	}
+	logEntry.WithField("dead_letter_id", letter.ID).Info("Notification stored as dead letter")
+}
+
+// replayed removes the dead letter the notification replayed successfully
+func (h *KafkaListener) replayed(n *Notification) {
+	if h.DeadLetters == nil {
+		return
+	}
+	logEntry := log.WithField("transaction_id", n.Tid).WithField("uuid", n.Stub.Uuid).WithField("dead_letter_id", n.DeadLetterID)
+	if err := h.DeadLetters.Remove(n.DeadLetterID); err != nil {
+		logEntry.WithError(err).Error("Failed to remove replayed dead letter")
+		return
+	}
+	logEntry.Info("Dead letter replayed")
+}
+
+// ListDeadLetters returns the notifications whose handling failed
+func (h *KafkaListener) ListDeadLetters() ([]DeadLetter, error) {
+	if h.DeadLetters == nil {
+		return []DeadLetter{}, nil
+	}
+	return h.DeadLetters.List()
+}
+
+// ReplayDeadLetter hands the notification of the dead letter to the listener again, mapped from its original message.
+// Messages concerning several contents only replay the content which failed. The dead letter is removed once
+// the notification has been handled, and updated if the handling fails again
+func (h *KafkaListener) ReplayDeadLetter(id string) error {
+	if h.DeadLetters == nil {
+		return ErrDeadLetterNotFound
+	}
+	letter, err := h.DeadLetters.Get(id)
+	if err != nil {
+		return err
+	}
+	notifications, err := mapNotifications(h.mapperOf(letter.Topic), letter.Message)
+	var replayed []*Notification
+	for _, n := range notifications {
+		if n.Stub.Uuid == letter.Uuid {
+			n.DeadLetterID = id
+			replayed = append(replayed, n)
+			break
+		}
+	}
+	if len(replayed) == 0 {
+		if err == nil {
+			err = fmt.Errorf("Message is not mapped to a notification of %v anymore", letter.Uuid)
+		}
+		return err
+	}
+	log.WithField("transaction_id", letter.Message.Headers["X-Request-Id"]).WithField("uuid", letter.Uuid).Infof("Replaying dead letter %v", id)
+	go func() {
+		if err := h.enqueue(letter.Topic, letter.Message, replayed, nil); err != nil {
+			log.WithField("uuid", letter.Uuid).WithError(err).Error("Failed to replay dead letter")
+		}
+	}()
+	return nil
+}
+
+// DiscardDeadLetter removes the dead letter without replaying it
+func (h *KafkaListener) DiscardDeadLetter(id string) error {
+	if h.DeadLetters == nil {
+		return ErrDeadLetterNotFound
+	}
+	return h.DeadLetters.Remove(id)
+}
+
+// Concurrency returns the maximum number of notifications handled at once
+func (h *KafkaListener) Concurrency() int {
+	return h.workers.Size()
+}
+
+// SetConcurrency changes the maximum number of notifications handled at once, without stopping the consumption.
+// It cannot go beyond the number of shards, fixed at startup to the larger of maxGoRoutines and 256
+func (h *KafkaListener) SetConcurrency(maxGoRoutines int) {
+	if maxGoRoutines > len(h.shards) {
+		log.Warnf("INCREMENTAL export concurrency of %v is capped to the %v shards", maxGoRoutines, len(h.shards))
+		maxGoRoutines = len(h.shards)
 	}
-	h.RUnlock()
+	h.workers.Resize(maxGoRoutines)
+	log.Infof("INCREMENTAL export concurrency set to %v", maxGoRoutines)
 }
 
 func (h *KafkaListener) CheckHealth() (string, error) {
@@ -0,0 +1,382 @@
+package queue
+
+import (
+	"context"
+	"regexp"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/content-exporter/export"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type recordingNotificationHandler struct {
+	sync.Mutex
+	handled []*Notification
+}
+
+func (r *recordingNotificationHandler) HandleContentNotification(ctx context.Context, n *Notification) error {
+	r.Lock()
+	defer r.Unlock()
+	r.handled = append(r.handled, n)
+	return nil
+}
+
+func (r *recordingNotificationHandler) Handled() []*Notification {
+	r.Lock()
+	defer r.Unlock()
+	return append([]*Notification(nil), r.handled...)
+}
+
+// bodyMapper maps the messages to notifications of the uuid and event type given in the body as "uuid EVENT".
+// Other messages are skipped
+type bodyMapper struct{}
+
+func (bodyMapper) MapNotification(msg kafka.FTMessage) (*Notification, error) {
+	var uuid, evType string
+	for i, c := range msg.Body {
+		if c == ' ' {
+			uuid, evType = msg.Body[:i], msg.Body[i+1:]
+		}
+	}
+	if uuid == "" {
+		return nil, nil
+	}
+	return &Notification{Stub: content.Stub{Uuid: uuid}, EvType: EventType(evType), Tid: msg.Headers["X-Request-Id"]}, nil
+}
+
+// datedMapper maps the messages as bodyMapper does, giving the notifications a date
+type datedMapper struct {
+	date string
+}
+
+func (m datedMapper) MapNotification(msg kafka.FTMessage) (*Notification, error) {
+	n, err := bodyMapper{}.MapNotification(msg)
+	if n != nil {
+		n.Stub.Date = m.date
+	}
+	return n, err
+}
+
+func newTestListener(delay time.Duration) (*KafkaListener, *recordingNotificationHandler) {
+	listener := NewKafkaListener(nil, nil, nil, export.NewLocker(), 10, delay)
+	handler := &recordingNotificationHandler{}
+	listener.ContentNotificationHandler = handler
+	listener.MessageMapper = bodyMapper{}
+	return listener, handler
+}
+
+func waitFor(t *testing.T, condition func() bool, timeout time.Duration) {
+	deadline := time.Now().Add(timeout)
+	for !condition() {
+		if time.Now().After(deadline) {
+			t.Fatal("Condition not met in time")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func message(tid, body string) kafka.FTMessage {
+	return kafka.NewFTMessage(map[string]string{"X-Request-Id": tid}, body)
+}
+
+func TestKafkaListenerCoalescesNotificationsOfSameUUID(t *testing.T) {
+	listener, handler := newTestListener(200 * time.Millisecond)
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 UPDATE")))
+	require.NoError(t, listener.HandleMessage(message("tid_2", "uuid2 UPDATE")))
+	require.NoError(t, listener.HandleMessage(message("tid_3", "uuid1 UPDATE")))
+	require.NoError(t, listener.HandleMessage(message("tid_4", "uuid1 UPDATE")))
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 2 }, 2*time.Second)
+	time.Sleep(300 * time.Millisecond)
+	handled := handler.Handled()
+	require.Len(t, handled, 2)
+	tids := []string{handled[0].Tid, handled[1].Tid}
+	assert.ElementsMatch(t, []string{"tid_2", "tid_4"}, tids)
+
+	listener.RLock()
+	defer listener.RUnlock()
+	assert.Empty(t, listener.pending)
+}
+
+func TestKafkaListenerExtendsDelayOfCoalescedNotification(t *testing.T) {
+	listener, handler := newTestListener(300 * time.Millisecond)
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 UPDATE")))
+	time.Sleep(200 * time.Millisecond)
+	require.NoError(t, listener.HandleMessage(message("tid_2", "uuid1 UPDATE")))
+	time.Sleep(200 * time.Millisecond)
+	assert.Empty(t, handler.Handled())
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	assert.Equal(t, "tid_2", handler.Handled()[0].Tid)
+}
+
+func TestKafkaListenerDeleteReplacesPendingUpdate(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 UPDATE")))
+	require.NoError(t, listener.HandleMessage(message("tid_2", "uuid1 DELETE")))
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 3*time.Second)
+	assert.Equal(t, DELETE, handler.Handled()[0].EvType)
+}
+
+func TestKafkaListenerHandlesMetadataUpdateAfterPendingDelete(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	listener.Topics = TopicConfigs(TopicConfig{Topic: "annotations", MessageMapper: NewMetadataMessageMapper(regexp.MustCompile(".*")), Delay: 0})
+	defer listener.cancel()
+
+	require.NoError(t, listener.handleMessage("content", message("tid_1", testUUID+" DELETE"), nil))
+	go listener.handleMessage("annotations", message("tid_2", `{"contentUri": "http://annotations-rw/content/`+testUUID+`"}`), nil)
+	waitFor(t, func() bool {
+		listener.RLock()
+		defer listener.RUnlock()
+		return listener.pending[testUUID].latest.EvType == UPDATE
+	}, 2*time.Second)
+	go listener.handleNotifications()
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 2 }, 2*time.Second)
+	assert.Equal(t, DELETE, handler.Handled()[0].EvType)
+	assert.Equal(t, "tid_1", handler.Handled()[0].Tid)
+	assert.Equal(t, UPDATE, handler.Handled()[1].EvType)
+	assert.Equal(t, "tid_2", handler.Handled()[1].Tid)
+}
+
+func TestKafkaListenerMapsMessagesWithMapperAndDelayOfTheirTopic(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	listener.Topics = TopicConfigs(TopicConfig{Topic: "annotations", MessageMapper: NewMinimalMessageMapper(), Delay: 0})
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.handleMessage("annotations", message("tid_1", `{"uuid": "`+testUUID+`", "action": "UPDATE"}`), nil))
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	assert.Equal(t, testUUID, handler.Handled()[0].Stub.Uuid)
+	assert.Equal(t, "annotations", handler.Handled()[0].Topic)
+}
+
+func TestKafkaListenerDoesNotBringForwardPendingUpdateWithShorterDelayOfTopic(t *testing.T) {
+	listener, handler := newTestListener(300 * time.Millisecond)
+	listener.Topics = TopicConfigs(TopicConfig{Topic: "annotations", MessageMapper: bodyMapper{}, Delay: 0})
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.handleMessage("content", message("tid_1", "uuid1 UPDATE"), nil))
+	require.NoError(t, listener.handleMessage("annotations", message("tid_2", "uuid1 UPDATE"), nil))
+	time.Sleep(150 * time.Millisecond)
+	assert.Empty(t, handler.Handled())
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	assert.Equal(t, "tid_2", handler.Handled()[0].Tid)
+}
+
+func TestKafkaListenerStopsWaitingDelayWhenCancelled(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	stopped := make(chan struct{})
+	go func() {
+		listener.handleNotifications()
+		close(stopped)
+	}()
+
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 UPDATE")))
+	time.Sleep(100 * time.Millisecond)
+	listener.cancel()
+
+	select {
+	case <-stopped:
+	case <-time.After(3 * time.Second):
+		t.Fatal("Notification handling did not stop")
+	}
+	assert.Empty(t, handler.Handled())
+}
+
+type blockingNotificationHandler struct {
+	recordingNotificationHandler
+	release chan struct{}
+}
+
+func (b *blockingNotificationHandler) HandleContentNotification(ctx context.Context, n *Notification) error {
+	if n.EvType == UPDATE {
+		<-b.release
+	}
+	return b.recordingNotificationHandler.HandleContentNotification(ctx, n)
+}
+
+func TestKafkaListenerHandlesNotificationsOfSameUUIDInArrivalOrder(t *testing.T) {
+	listener, _ := newTestListener(0)
+	handler := &blockingNotificationHandler{release: make(chan struct{})}
+	listener.ContentNotificationHandler = handler
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 UPDATE")))
+	waitFor(t, func() bool {
+		listener.Lock()
+		defer listener.Unlock()
+		p, ok := listener.pending["uuid1"]
+		return ok && p.started
+	}, 2*time.Second)
+	require.NoError(t, listener.HandleMessage(message("tid_2", "uuid1 DELETE")))
+	require.NoError(t, listener.HandleMessage(message("tid_3", "uuid2 DELETE")))
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	close(handler.release)
+	waitFor(t, func() bool { return len(handler.Handled()) == 3 }, 2*time.Second)
+
+	handled := handler.Handled()
+	assert.Equal(t, "tid_3", handled[0].Tid)
+	assert.Equal(t, "tid_1", handled[1].Tid)
+	assert.Equal(t, "tid_2", handled[2].Tid)
+}
+
+type ackCounter struct {
+	sync.Mutex
+	acked map[string]int
+}
+
+func (c *ackCounter) ack(tid string) func() {
+	return func() {
+		c.Lock()
+		defer c.Unlock()
+		c.acked[tid]++
+	}
+}
+
+func (c *ackCounter) Acked() map[string]int {
+	c.Lock()
+	defer c.Unlock()
+	acked := make(map[string]int)
+	for tid, count := range c.acked {
+		acked[tid] = count
+	}
+	return acked
+}
+
+func TestKafkaListenerAcksMessagesOnceHandled(t *testing.T) {
+	listener, handler := newTestListener(200 * time.Millisecond)
+	acks := &ackCounter{acked: make(map[string]int)}
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.handleMessage("", message("tid_1", "uuid1 UPDATE"), acks.ack("tid_1")))
+	require.NoError(t, listener.handleMessage("", message("tid_2", "uuid1 UPDATE"), acks.ack("tid_2")))
+	require.NoError(t, listener.handleMessage("", message("tid_3", "skipped"), acks.ack("tid_3")))
+	assert.Equal(t, map[string]int{"tid_3": 1}, acks.Acked())
+
+	waitFor(t, func() bool { return len(acks.Acked()) == 3 }, 2*time.Second)
+	assert.Len(t, handler.Handled(), 1)
+	assert.Equal(t, map[string]int{"tid_1": 1, "tid_2": 1, "tid_3": 1}, acks.Acked())
+}
+
+func TestKafkaListenerAcksMessageOfSeveralContentsOnceAllHandled(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	listener.Topics = TopicConfigs(TopicConfig{Topic: "annotations", MessageMapper: NewMetadataMessageMapper(regexp.MustCompile(".*")), Delay: 0})
+	acks := &ackCounter{acked: make(map[string]int)}
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.handleMessage("content", message("tid_1", testUUID+" UPDATE"), acks.ack("tid_1")))
+	body := `{"contentUris": ["http://annotations-rw/content/` + testUUID + `", "http://annotations-rw/content/` + otherTestUUID + `"]}`
+	require.NoError(t, listener.handleMessage("annotations", message("tid_2", body), acks.ack("tid_2")))
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	assert.Equal(t, otherTestUUID, handler.Handled()[0].Stub.Uuid)
+	assert.Empty(t, acks.Acked())
+
+	listener.RLock()
+	p := listener.pending[testUUID]
+	listener.RUnlock()
+	assert.Equal(t, "tid_2", p.latest.Tid)
+}
+
+func TestKafkaListenerKeepsDateOfPendingNotificationWhenCoalescingMetadataChange(t *testing.T) {
+	listener, handler := newTestListener(200 * time.Millisecond)
+	listener.MessageMapper = datedMapper{date: "2020-01-30"}
+	listener.Topics = TopicConfigs(TopicConfig{Topic: "annotations", MessageMapper: NewMetadataMessageMapper(regexp.MustCompile(".*")), Delay: 0})
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	require.NoError(t, listener.handleMessage("content", message("tid_1", testUUID+" UPDATE"), nil))
+	require.NoError(t, listener.handleMessage("annotations", message("tid_2", `{"contentUri": "http://annotations-rw/content/`+testUUID+`"}`), nil))
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	assert.Equal(t, "tid_2", handler.Handled()[0].Tid)
+	assert.Equal(t, "2020-01-30", handler.Handled()[0].Stub.Date)
+}
+
+func TestKafkaListenerDoesNotAckMessagesInterruptedByShutdown(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	acks := &ackCounter{acked: make(map[string]int)}
+	stopped := make(chan struct{})
+	go func() {
+		listener.handleNotifications()
+		close(stopped)
+	}()
+
+	require.NoError(t, listener.handleMessage("", message("tid_1", "uuid1 UPDATE"), acks.ack("tid_1")))
+	time.Sleep(100 * time.Millisecond)
+	listener.cancel()
+	<-stopped
+
+	assert.Empty(t, handler.Handled())
+	assert.Empty(t, acks.Acked())
+}
+
+func TestKafkaListenerDoesNotHoldBackNotificationsOfOtherUUIDsOfTheShard(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	other := "uuid0"
+	for i := 1; shardOf(other, len(listener.shards)) != shardOf("uuid1", len(listener.shards)); i++ {
+		other = "uuid1-" + strconv.Itoa(i)
+	}
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 UPDATE")))
+	require.NoError(t, listener.HandleMessage(message("tid_2", other+" DELETE")))
+
+	waitFor(t, func() bool { return len(handler.Handled()) == 1 }, 2*time.Second)
+	assert.Equal(t, "tid_2", handler.Handled()[0].Tid)
+}
+
+func TestKafkaListenerDoesNotBlockWhileNotificationsWaitForTheirDelay(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	go listener.handleNotifications()
+	defer listener.cancel()
+
+	received := make(chan struct{})
+	go func() {
+		defer close(received)
+		for i := 0; i < 1000; i++ {
+			require.NoError(t, listener.HandleMessage(message("tid_"+strconv.Itoa(i), "uuid"+strconv.Itoa(i)+" UPDATE")))
+		}
+	}()
+	select {
+	case <-received:
+	case <-time.After(2 * time.Second):
+		t.Fatal("Receiving notifications is blocked")
+	}
+	assert.Empty(t, handler.Handled())
+}
+
+func TestKafkaListenerCapsConcurrencyToShards(t *testing.T) {
+	listener, _ := newTestListener(0)
+	assert.Equal(t, 10, listener.Concurrency())
+
+	listener.SetConcurrency(100)
+	assert.Equal(t, 100, listener.Concurrency())
+	listener.SetConcurrency(minShards + 1)
+	assert.Equal(t, minShards, listener.Concurrency())
+}
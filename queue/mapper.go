@@ -4,10 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"regexp"
-	"strings"
 
 	"github.com/Financial-Times/content-exporter/content"
-	"github.com/Financial-Times/content-exporter/export"
 	"github.com/Financial-Times/kafka-client-go/kafka"
 	log "github.com/sirupsen/logrus"
 )
@@ -21,6 +19,24 @@ type MessageMapper interface {
 	MapNotification(msg kafka.FTMessage) (*Notification, error)
 }
 
+// MultiMessageMapper maps messages which may concern several contents, like metadata changes, to a notification per content
+type MultiMessageMapper interface {
+	MessageMapper
+	MapNotifications(msg kafka.FTMessage) ([]*Notification, error)
+}
+
+// mapNotifications maps the message to the notifications of every content it concerns
+func mapNotifications(mapper MessageMapper, msg kafka.FTMessage) ([]*Notification, error) {
+	if multi, ok := mapper.(MultiMessageMapper); ok {
+		return multi.MapNotifications(msg)
+	}
+	n, err := mapper.MapNotification(msg)
+	if n == nil {
+		return nil, err
+	}
+	return []*Notification{n}, err
+}
+
 type KafkaMessageMapper struct {
 	WhiteListRegex *regexp.Regexp
 }
@@ -82,9 +98,8 @@ func (e event) mapNotification(tid string) (*Notification, error) {
 			Date:             content.GetDateOrDefault(payload),
 			CanBeDistributed: canBeDistributed,
 		},
-		EvType:     evType,
-		Terminator: export.NewTerminator(),
-		Tid:        tid,
+		EvType: evType,
+		Tid:    tid,
 	}, nil
 }
 
@@ -97,7 +112,7 @@ func (h *KafkaMessageMapper) MapNotification(msg kafka.FTMessage) (*Notification
 		return nil, err
 	}
 
-	if strings.HasPrefix(tid, "SYNTH") {
+	if isSynthetic(tid) {
 		log.WithField("transaction_id", tid).WithField("contentUri", pubEvent.ContentURI).Info("Skipping event: Synthetic transaction ID.")
 		return nil, nil
 	}
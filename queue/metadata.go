@@ -0,0 +1,83 @@
+package queue
+
+import (
+	"encoding/json"
+	"errors"
+	"regexp"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	log "github.com/sirupsen/logrus"
+)
+
+// MetadataMessageMapper maps the changes of the annotations of contents to UPDATE events of the contents, as the annotations
+// are part of the exported enriched content. Each content URI is matched against the whitelist
+type MetadataMessageMapper struct {
+	WhiteListRegex *regexp.Regexp
+}
+
+func NewMetadataMessageMapper(whitelistR *regexp.Regexp) *MetadataMessageMapper {
+	return &MetadataMessageMapper{WhiteListRegex: whitelistR}
+}
+
+// metadataEvent is a change of annotations. ContentURI is the content annotated,
+// ContentURIs the contents concerned by a change of several annotations at once, like a concept merge
+type metadataEvent struct {
+	ContentURI  string   `json:"contentUri"`
+	ContentURIs []string `json:"contentUris"`
+}
+
+// MapNotification maps the event to the notification of the first content it concerns. MapNotifications maps them all
+func (m *MetadataMessageMapper) MapNotification(msg kafka.FTMessage) (*Notification, error) {
+	notifications, err := m.MapNotifications(msg)
+	if len(notifications) == 0 {
+		return nil, err
+	}
+	return notifications[0], err
+}
+
+func (m *MetadataMessageMapper) MapNotifications(msg kafka.FTMessage) ([]*Notification, error) {
+	tid := msg.Headers["X-Request-Id"]
+	var ev metadataEvent
+	if err := json.Unmarshal([]byte(msg.Body), &ev); err != nil {
+		log.WithField("transaction_id", tid).WithField("msg", msg.Body).WithError(err).Warn("Skipping event.")
+		return nil, err
+	}
+	uris := ev.ContentURIs
+	if ev.ContentURI != "" {
+		uris = append([]string{ev.ContentURI}, uris...)
+	}
+	if len(uris) == 0 {
+		log.WithField("transaction_id", tid).WithField("msg", msg.Body).Warn("Skipping event: Cannot build notification for message.")
+		return nil, errors.New("Event does not concern any content")
+	}
+	if isSynthetic(tid) {
+		log.WithField("transaction_id", tid).WithField("contentUri", uris[0]).Info("Skipping event: Synthetic transaction ID.")
+		return nil, nil
+	}
+
+	var notifications []*Notification
+	seen := make(map[string]bool)
+	for _, uri := range uris {
+		if !m.WhiteListRegex.MatchString(uri) {
+			log.WithField("transaction_id", tid).WithField("contentUri", uri).Info("Skipping content: It is not in the whitelist.")
+			continue
+		}
+		uuid := UUIDRegexp.FindString(uri)
+		if uuid == "" {
+			log.WithField("transaction_id", tid).WithField("contentUri", uri).Warn("Skipping content: ContentURI does not contain a UUID.")
+			continue
+		}
+		if seen[uuid] {
+			continue
+		}
+		seen[uuid] = true
+		// The date of the content is not part of the event, it is read from the exported content
+		notifications = append(notifications, &Notification{
+			Stub:   content.Stub{Uuid: uuid, Date: content.UnknownDate},
+			EvType: UPDATE,
+			Tid:    tid,
+		})
+	}
+	return notifications, nil
+}
@@ -0,0 +1,62 @@
+package queue
+
+import (
+	"regexp"
+	"testing"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+const otherTestUUID = "7c2b1f9e-0d3a-4c5e-8f6a-2b1c3d4e5f60"
+
+func TestMetadataMessageMapperMapsAnnotatedContentToUpdate(t *testing.T) {
+	mapper := NewMetadataMessageMapper(regexp.MustCompile(".*"))
+	body := `{"contentUri": "http://annotations-rw/content/` + testUUID + `", "payload": {"annotations": []}}`
+
+	notifications, err := mapper.MapNotifications(kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1"}, body))
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	assert.Equal(t, UPDATE, notifications[0].EvType)
+	assert.Equal(t, "tid_1", notifications[0].Tid)
+	assert.Equal(t, content.Stub{Uuid: testUUID, Date: content.UnknownDate}, notifications[0].Stub)
+}
+
+func TestMetadataMessageMapperMapsEveryContentOnce(t *testing.T) {
+	mapper := NewMetadataMessageMapper(regexp.MustCompile("/content/"))
+	body := `{"contentUris": ["http://annotations-rw/content/` + testUUID + `", "http://annotations-rw/content/` + otherTestUUID + `",
+		"http://annotations-rw/content/` + testUUID + `", "http://annotations-rw/concepts/` + otherTestUUID + `", "http://annotations-rw/content/none"]}`
+
+	notifications, err := mapper.MapNotifications(kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1"}, body))
+	require.NoError(t, err)
+	require.Len(t, notifications, 2)
+	assert.Equal(t, testUUID, notifications[0].Stub.Uuid)
+	assert.Equal(t, otherTestUUID, notifications[1].Stub.Uuid)
+
+	n, err := mapper.MapNotification(kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1"}, body))
+	require.NoError(t, err)
+	assert.Equal(t, testUUID, n.Stub.Uuid)
+}
+
+func TestMetadataMessageMapperSkipsEvents(t *testing.T) {
+	mapper := NewMetadataMessageMapper(regexp.MustCompile("/content/"))
+	for _, msg := range []kafka.FTMessage{
+		kafka.NewFTMessage(map[string]string{"X-Request-Id": "SYNTH_tid"}, `{"contentUri": "http://annotations-rw/content/`+testUUID+`"}`),
+		kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1"}, `{"contentUri": "http://annotations-rw/concepts/`+testUUID+`"}`),
+	} {
+		notifications, err := mapper.MapNotifications(msg)
+		assert.NoError(t, err)
+		assert.Empty(t, notifications)
+	}
+}
+
+func TestMetadataMessageMapperRejectsInvalidEvents(t *testing.T) {
+	mapper := NewMetadataMessageMapper(regexp.MustCompile(".*"))
+	for _, body := range []string{`not json`, `{"payload": {}}`} {
+		notifications, err := mapper.MapNotifications(kafka.NewFTMessage(map[string]string{}, body))
+		assert.Error(t, err, body)
+		assert.Empty(t, notifications)
+	}
+}
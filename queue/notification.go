@@ -1,12 +1,11 @@
 package queue
 
 import (
+	"context"
 	"fmt"
-	"time"
 
 	"github.com/Financial-Times/content-exporter/content"
-	"github.com/Financial-Times/content-exporter/export"
-	"github.com/pkg/errors"
+	"github.com/Financial-Times/kafka-client-go/kafka"
 	log "github.com/sirupsen/logrus"
 )
 
@@ -16,45 +15,53 @@ const UPDATE EventType = "UPDATE"
 const DELETE EventType = "DELETE"
 
 type Notification struct {
-	Stub   content.Stub
-	EvType EventType
-	Tid    string
-	*export.Terminator
+	Stub    content.Stub
+	EvType  EventType
+	Tid     string
+	Message kafka.FTMessage
+	// Topic the message was consumed from, empty for messages handed over otherwise
+	Topic string
+	// DeadLetterID is the dead letter the notification replays, removed once the notification is handled
+	DeadLetterID string
 }
 
 type ContentNotificationHandler interface {
-	HandleContentNotification(n *Notification) error
+	HandleContentNotification(ctx context.Context, n *Notification) error
 }
 
 type KafkaContentNotificationHandler struct {
-	ContentExporter *content.Exporter
-	Delay           int
+	ContentExporter    *content.Exporter
+	DeleteInaccessible bool
 }
 
-func NewKafkaContentNotificationHandler(exporter *content.Exporter, delayForNotification int) *KafkaContentNotificationHandler {
+func NewKafkaContentNotificationHandler(exporter *content.Exporter, deleteInaccessible bool) *KafkaContentNotificationHandler {
 	return &KafkaContentNotificationHandler{
-		ContentExporter: exporter,
-		Delay:           delayForNotification,
+		ContentExporter:    exporter,
+		DeleteInaccessible: deleteInaccessible,
 	}
 }
 
-func (h *KafkaContentNotificationHandler) HandleContentNotification(n *Notification) error {
+// HandleContentNotification exports or deletes the content. The delay of UPDATE events is waited by the KafkaListener beforehand
+func (h *KafkaContentNotificationHandler) HandleContentNotification(ctx context.Context, n *Notification) error {
 	logEntry := log.WithField("transaction_id", n.Tid).WithField("uuid", n.Stub.Uuid)
 	if n.EvType == UPDATE {
-		logEntry.Infof("UPDATE event received. Waiting configured delay - %v second(s)", h.Delay)
-
-		select {
-		case <-time.After(time.Duration(h.Delay) * time.Second):
-		case <-n.Quit:
-			err := errors.New("Shutdown signalled, delay waiting for UPDATE event terminated abruptly")
-			return err
-		}
-		if err := h.ContentExporter.HandleContent(n.Tid, n.Stub); err != nil {
-			return fmt.Errorf("UPDATE ERROR: %v", err)
+		logEntry.Info("UPDATE event handled")
+		if err := h.ContentExporter.HandleContent(ctx, n.Tid, n.Stub); err != nil {
+			if err == content.ErrUnchanged {
+				logEntry.Info("UPDATE skipped: content has not changed since last export")
+				return nil
+			}
+			if content.IsInaccessible(err) {
+				return h.handleInaccessibleContent(ctx, n, err)
+			}
+			if err == content.ErrNotDistributable {
+				return h.deleteUndistributableContent(ctx, n)
+			}
+			return fmt.Errorf("UPDATE ERROR: %w", err)
 		}
 	} else if n.EvType == DELETE {
 		logEntry.Info("DELETE event received")
-		if err := h.ContentExporter.Updater.Delete(n.Stub.Uuid, n.Tid); err != nil {
+		if err := h.ContentExporter.DeleteContent(ctx, n.Tid, n.Stub.Uuid); err != nil {
 			if err == content.ErrNotFound {
 				logEntry.Warnf("DELETE WARN: %v", err)
 				return nil
@@ -64,3 +71,27 @@ func (h *KafkaContentNotificationHandler) HandleContentNotification(n *Notificat
 	}
 	return nil
 }
+
+// deleteUndistributableContent deletes the exported content which cannot be distributed anymore
+func (h *KafkaContentNotificationHandler) deleteUndistributableContent(ctx context.Context, n *Notification) error {
+	log.WithField("transaction_id", n.Tid).WithField("uuid", n.Stub.Uuid).Warn("UPDATE turned into DELETE: content cannot be distributed")
+	if err := h.ContentExporter.DeleteContent(ctx, n.Tid, n.Stub.Uuid); err != nil && err != content.ErrNotFound {
+		return fmt.Errorf("DELETE ERROR: %v", err)
+	}
+	return nil
+}
+
+// handleInaccessibleContent skips content which is forbidden or not found anymore,
+// or deletes it when configured so, not to keep exported content which cannot be read
+func (h *KafkaContentNotificationHandler) handleInaccessibleContent(ctx context.Context, n *Notification, cause error) error {
+	logEntry := log.WithField("transaction_id", n.Tid).WithField("uuid", n.Stub.Uuid)
+	if !h.DeleteInaccessible {
+		logEntry.Warnf("UPDATE skipped: %v", cause)
+		return nil
+	}
+	logEntry.Warnf("UPDATE turned into DELETE: %v", cause)
+	if err := h.ContentExporter.DeleteContent(ctx, n.Tid, n.Stub.Uuid); err != nil && err != content.ErrNotFound {
+		return fmt.Errorf("DELETE ERROR: %v", err)
+	}
+	return nil
+}
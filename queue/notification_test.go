@@ -1,21 +1,23 @@
 package queue
 
 import (
+	"context"
+	"regexp"
 	"testing"
-	"time"
 
 	"github.com/Financial-Times/content-exporter/content"
-	"github.com/Financial-Times/content-exporter/export"
+	"github.com/Financial-Times/kafka-client-go/kafka"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
+	"github.com/stretchr/testify/require"
 )
 
 type mockFetcher struct {
 	mock.Mock
 }
 
-func (m *mockFetcher) GetContent(uuid, tid string) ([]byte, error) {
+func (m *mockFetcher) GetContent(ctx context.Context, uuid, tid string) ([]byte, error) {
 	args := m.Called(uuid, tid)
 	return args.Get(0).([]byte), args.Error(1)
 }
@@ -24,27 +26,32 @@ type mockUpdater struct {
 	mock.Mock
 }
 
-func (m *mockUpdater) Upload(content []byte, tid, uuid, date string) error {
+func (m *mockUpdater) Upload(ctx context.Context, content []byte, tid, uuid, date string) error {
 	args := m.Called(content, tid, uuid, date)
 	return args.Error(0)
 }
 
-func (m *mockUpdater) Delete(uuid, tid string) error {
+func (m *mockUpdater) Delete(ctx context.Context, uuid, tid string) error {
 	args := m.Called(uuid, tid)
 	return args.Error(0)
 }
 
+func (m *mockUpdater) IsCurrent(ctx context.Context, content []byte, tid, uuid, date string) (bool, error) {
+	args := m.Called(content, tid, uuid, date)
+	return args.Bool(0), args.Error(1)
+}
+
 func TestKafkaContentNotificationHandlerHandleUpdateSuccessfully(t *testing.T) {
 	fetcher := new(mockFetcher)
 	updater := new(mockUpdater)
-	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: UPDATE, Terminator: export.NewTerminator()}
-	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater), 0)
+	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: UPDATE}
+	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater))
 
 	var testData []byte
 	fetcher.On("GetContent", n.Stub.Uuid, n.Tid).Return(testData, nil)
 	updater.On("Upload", testData, n.Tid, n.Stub.Uuid, n.Stub.Date).Return(nil)
 
-	err := contentNotificationHandler.HandleContentNotification(n)
+	err := contentNotificationHandler.HandleContentNotification(context.Background(), n)
 
 	assert.NoError(t, err)
 	fetcher.AssertExpectations(t)
@@ -54,12 +61,12 @@ func TestKafkaContentNotificationHandlerHandleUpdateSuccessfully(t *testing.T) {
 func TestKafkaContentNotificationHandlerHandleUpdateWithError(t *testing.T) {
 	fetcher := new(mockFetcher)
 	updater := new(mockUpdater)
-	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: UPDATE, Terminator: export.NewTerminator()}
-	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater), 0)
+	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: UPDATE}
+	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater))
 	var testData []byte
 	fetcher.On("GetContent", n.Stub.Uuid, n.Tid).Return(testData, errors.New("Fetcher err"))
 
-	err := contentNotificationHandler.HandleContentNotification(n)
+	err := contentNotificationHandler.HandleContentNotification(context.Background(), n)
 
 	assert.Error(t, err)
 	assert.Equal(t, "UPDATE ERROR: Error getting content for uuid1: Fetcher err", err.Error())
@@ -67,19 +74,51 @@ func TestKafkaContentNotificationHandlerHandleUpdateWithError(t *testing.T) {
 	updater.AssertExpectations(t)
 }
 
-func TestKafkaContentNotificationHandlerHandleUpdateWithQuitSignal(t *testing.T) {
+func TestKafkaContentNotificationHandlerHandleUnchangedUpdate(t *testing.T) {
 	fetcher := new(mockFetcher)
 	updater := new(mockUpdater)
-	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: UPDATE, Terminator: export.NewTerminator()}
-	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater), 30)
-	go func() {
-		time.Sleep(500 * time.Millisecond)
-		n.Quit <- struct{}{}
-	}()
-	err := contentNotificationHandler.HandleContentNotification(n)
+	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: UPDATE}
+	exporter := content.NewExporter(fetcher, updater)
+	exporter.HashIndex = content.NewInMemoryHashIndex()
+	contentNotificationHandler := NewContentNotificationHandler(exporter)
+	testData := []byte("payload")
+	exporter.HashIndex.Put(n.Stub.Uuid, content.Hash(testData))
+	fetcher.On("GetContent", n.Stub.Uuid, n.Tid).Return(testData, nil)
 
-	assert.Error(t, err)
-	assert.Equal(t, "Shutdown signalled, delay waiting for UPDATE event terminated abruptly", err.Error())
+	err := contentNotificationHandler.HandleContentNotification(context.Background(), n)
+
+	assert.NoError(t, err)
+	fetcher.AssertExpectations(t)
+	updater.AssertExpectations(t)
+}
+
+func TestKafkaContentNotificationHandlerHandleUpdateSkipsForbiddenContent(t *testing.T) {
+	fetcher := new(mockFetcher)
+	updater := new(mockUpdater)
+	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: UPDATE}
+	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater))
+	var testData []byte
+	fetcher.On("GetContent", n.Stub.Uuid, n.Tid).Return(testData, content.ErrForbidden)
+
+	err := contentNotificationHandler.HandleContentNotification(context.Background(), n)
+
+	assert.NoError(t, err)
+	fetcher.AssertExpectations(t)
+	updater.AssertExpectations(t)
+}
+
+func TestKafkaContentNotificationHandlerHandleUpdateDeletesContentNotFound(t *testing.T) {
+	fetcher := new(mockFetcher)
+	updater := new(mockUpdater)
+	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: UPDATE}
+	contentNotificationHandler := NewKafkaContentNotificationHandler(content.NewExporter(fetcher, updater), true)
+	var testData []byte
+	fetcher.On("GetContent", n.Stub.Uuid, n.Tid).Return(testData, content.ErrContentNotFound)
+	updater.On("Delete", n.Stub.Uuid, n.Tid).Return(nil)
+
+	err := contentNotificationHandler.HandleContentNotification(context.Background(), n)
+
+	assert.NoError(t, err)
 	fetcher.AssertExpectations(t)
 	updater.AssertExpectations(t)
 }
@@ -87,11 +126,11 @@ func TestKafkaContentNotificationHandlerHandleUpdateWithQuitSignal(t *testing.T)
 func TestKafkaContentNotificationHandlerHandleDeleteSuccessfully(t *testing.T) {
 	fetcher := new(mockFetcher)
 	updater := new(mockUpdater)
-	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: DELETE, Terminator: export.NewTerminator()}
-	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater), 0)
+	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: DELETE}
+	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater))
 	updater.On("Delete", n.Stub.Uuid, n.Tid).Return(nil)
 
-	err := contentNotificationHandler.HandleContentNotification(n)
+	err := contentNotificationHandler.HandleContentNotification(context.Background(), n)
 
 	assert.NoError(t, err)
 	fetcher.AssertExpectations(t)
@@ -101,11 +140,11 @@ func TestKafkaContentNotificationHandlerHandleDeleteSuccessfully(t *testing.T) {
 func TestKafkaContentNotificationHandlerHandleDeleteWithError(t *testing.T) {
 	fetcher := new(mockFetcher)
 	updater := new(mockUpdater)
-	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: DELETE, Terminator: export.NewTerminator()}
-	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater), 0)
+	n := &Notification{Stub: content.Stub{Date: "aDate", Uuid: "uuid1"}, Tid: "tid_1234", EvType: DELETE}
+	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater))
 	updater.On("Delete", n.Stub.Uuid, n.Tid).Return(errors.New("Updater err"))
 
-	err := contentNotificationHandler.HandleContentNotification(n)
+	err := contentNotificationHandler.HandleContentNotification(context.Background(), n)
 
 	assert.Error(t, err)
 	assert.Equal(t, "DELETE ERROR: Updater err", err.Error())
@@ -113,6 +152,53 @@ func TestKafkaContentNotificationHandlerHandleDeleteWithError(t *testing.T) {
 	updater.AssertExpectations(t)
 }
 
-func NewContentNotificationHandler(exporter *content.Exporter, delay int) ContentNotificationHandler {
-	return NewKafkaContentNotificationHandler(exporter, delay)
+func NewContentNotificationHandler(exporter *content.Exporter) ContentNotificationHandler {
+	return NewKafkaContentNotificationHandler(exporter, false)
+}
+
+func TestKafkaContentNotificationHandlerDeletesContentOfMetadataChangeWhichCannotBeDistributed(t *testing.T) {
+	fetcher := new(mockFetcher)
+	updater := new(mockUpdater)
+	msg := kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1234"}, `{"contentUri": "http://annotations-rw/content/`+testUUID+`"}`)
+	notifications, err := NewMetadataMessageMapper(regexp.MustCompile(".*")).MapNotifications(msg)
+	require.NoError(t, err)
+	require.Len(t, notifications, 1)
+	contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater))
+
+	fetcher.On("GetContent", testUUID, "tid_1234").Return([]byte(`{"uuid": "`+testUUID+`", "canBeDistributed": "verify"}`), nil)
+	updater.On("Delete", testUUID, "tid_1234").Return(nil)
+
+	err = contentNotificationHandler.HandleContentNotification(context.Background(), notifications[0])
+
+	assert.NoError(t, err)
+	fetcher.AssertExpectations(t)
+	updater.AssertExpectations(t)
+	updater.AssertNotCalled(t, "Upload", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func TestKafkaContentNotificationHandlerDeletesContentOfEventsWithoutDistributionWhichCannotBeDistributed(t *testing.T) {
+	for name, mapped := range map[string]struct {
+		mapper MessageMapper
+		body   string
+	}{
+		"minimal":                 {NewMinimalMessageMapper(), `{"uuid": "` + testUUID + `", "action": "UPDATE"}`},
+		"cloudevents string data": {NewCloudEventsMapper(regexp.MustCompile(".*")), `{"specversion":"1.0","type":"com.ft.content.published","source":"/upp/content","subject":"` + testUUID + `","data":"<xml/>"}`},
+	} {
+		fetcher := new(mockFetcher)
+		updater := new(mockUpdater)
+		n, err := mapped.mapper.MapNotification(kafka.NewFTMessage(map[string]string{"X-Request-Id": "tid_1234"}, mapped.body))
+		require.NoError(t, err, name)
+		require.NotNil(t, n, name)
+		contentNotificationHandler := NewContentNotificationHandler(content.NewExporter(fetcher, updater))
+
+		fetcher.On("GetContent", testUUID, "tid_1234").Return([]byte(`{"uuid": "`+testUUID+`", "canBeDistributed": "no"}`), nil)
+		updater.On("Delete", testUUID, "tid_1234").Return(nil)
+
+		err = contentNotificationHandler.HandleContentNotification(context.Background(), n)
+
+		assert.NoError(t, err, name)
+		fetcher.AssertExpectations(t)
+		updater.AssertExpectations(t)
+		updater.AssertNotCalled(t, "Upload", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	}
 }
@@ -0,0 +1,75 @@
+package queue
+
+import (
+	"sort"
+	"sync"
+)
+
+type topicPartition struct {
+	topic     string
+	partition int32
+}
+
+// partitionOffsets holds the offsets of a partition delivered but not committed yet, in ascending order
+type partitionOffsets struct {
+	inFlight []int64
+	done     map[int64]bool
+}
+
+// OffsetTracker keeps the messages in flight per partition, so that offsets are committed only up to the low watermark:
+// the last offset of the partition before which every message has been handled
+type OffsetTracker struct {
+	sync.Mutex
+	partitions map[topicPartition]*partitionOffsets
+}
+
+func NewOffsetTracker() *OffsetTracker {
+	return &OffsetTracker{partitions: make(map[topicPartition]*partitionOffsets)}
+}
+
+// Track registers a message delivered to the handler. Messages delivered again after a rebalance are tracked once
+func (t *OffsetTracker) Track(topic string, partition int32, offset int64) {
+	t.Lock()
+	defer t.Unlock()
+	key := topicPartition{topic, partition}
+	p, ok := t.partitions[key]
+	if !ok {
+		p = &partitionOffsets{done: make(map[int64]bool)}
+		t.partitions[key] = p
+	}
+	i := sort.Search(len(p.inFlight), func(i int) bool { return p.inFlight[i] >= offset })
+	if i < len(p.inFlight) && p.inFlight[i] == offset {
+		return
+	}
+	p.inFlight = append(p.inFlight, 0)
+	copy(p.inFlight[i+1:], p.inFlight[i:])
+	p.inFlight[i] = offset
+}
+
+// Done marks the message handled. Returns the new low watermark of the partition, or false if it did not move
+func (t *OffsetTracker) Done(topic string, partition int32, offset int64) (int64, bool) {
+	t.Lock()
+	defer t.Unlock()
+	p, ok := t.partitions[topicPartition{topic, partition}]
+	if !ok {
+		return 0, false
+	}
+	p.done[offset] = true
+	watermark, moved := int64(0), false
+	for len(p.inFlight) > 0 && p.done[p.inFlight[0]] {
+		watermark, moved = p.inFlight[0], true
+		delete(p.done, watermark)
+		p.inFlight = p.inFlight[1:]
+	}
+	return watermark, moved
+}
+
+// InFlight returns the number of messages of the partition not committed yet
+func (t *OffsetTracker) InFlight(topic string, partition int32) int {
+	t.Lock()
+	defer t.Unlock()
+	if p, ok := t.partitions[topicPartition{topic, partition}]; ok {
+		return len(p.inFlight)
+	}
+	return 0
+}
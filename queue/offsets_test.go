@@ -0,0 +1,56 @@
+package queue
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffsetTrackerMovesWatermarkOnlyPastHandledMessages(t *testing.T) {
+	tracker := NewOffsetTracker()
+	tracker.Track("topic", 0, 10)
+	tracker.Track("topic", 0, 11)
+	tracker.Track("topic", 0, 12)
+
+	_, moved := tracker.Done("topic", 0, 11)
+	assert.False(t, moved)
+	_, moved = tracker.Done("topic", 0, 12)
+	assert.False(t, moved)
+
+	watermark, moved := tracker.Done("topic", 0, 10)
+	assert.True(t, moved)
+	assert.Equal(t, int64(12), watermark)
+	assert.Equal(t, 0, tracker.InFlight("topic", 0))
+}
+
+func TestOffsetTrackerKeepsPartitionsApart(t *testing.T) {
+	tracker := NewOffsetTracker()
+	tracker.Track("topic", 0, 10)
+	tracker.Track("topic", 1, 5)
+	tracker.Track("other", 0, 3)
+
+	watermark, moved := tracker.Done("topic", 1, 5)
+	assert.True(t, moved)
+	assert.Equal(t, int64(5), watermark)
+	assert.Equal(t, 1, tracker.InFlight("topic", 0))
+	assert.Equal(t, 1, tracker.InFlight("other", 0))
+}
+
+func TestOffsetTrackerTracksRedeliveredMessagesOnce(t *testing.T) {
+	tracker := NewOffsetTracker()
+	tracker.Track("topic", 0, 11)
+	tracker.Track("topic", 0, 10)
+	tracker.Track("topic", 0, 11)
+	assert.Equal(t, 2, tracker.InFlight("topic", 0))
+
+	_, moved := tracker.Done("topic", 0, 11)
+	assert.False(t, moved)
+	watermark, moved := tracker.Done("topic", 0, 10)
+	assert.True(t, moved)
+	assert.Equal(t, int64(11), watermark)
+}
+
+func TestOffsetTrackerIgnoresUntrackedPartition(t *testing.T) {
+	_, moved := NewOffsetTracker().Done("topic", 0, 10)
+	assert.False(t, moved)
+}
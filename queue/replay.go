@@ -0,0 +1,225 @@
+package queue
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/Shopify/sarama"
+	log "github.com/sirupsen/logrus"
+)
+
+// offsetResolver finds the partitions of a topic and their offsets by timestamp, as sarama.Client does
+type offsetResolver interface {
+	Partitions(topic string) ([]int32, error)
+	GetOffset(topic string, partition int32, time int64) (int64, error)
+}
+
+// replayIdleTimeout is how long a replay waits for the next message of a partition before considering its range exhausted,
+// the remaining offsets having been removed by compaction
+const replayIdleTimeout = 10 * time.Second
+
+// notificationQueue hands notifications over to the shards of the INCREMENTAL export, as KafkaListener does
+type notificationQueue interface {
+	mapperOf(topic string) MessageMapper
+	enqueue(topic string, msg kafka.FTMessage, notifications []*Notification, ack func(error)) error
+}
+
+// Replayer re-processes the notifications received since a point in time. Each replay uses its own consumer,
+// outside of the consumer group, so the offsets of the INCREMENTAL export are left untouched. The notifications
+// are handed over to the INCREMENTAL export, so they are coalesced and ordered with the ones consumed meanwhile
+type Replayer struct {
+	config GroupConsumerConfig
+	queue  notificationQueue
+}
+
+func NewReplayer(config GroupConsumerConfig, listener *KafkaListener) *Replayer {
+	return &Replayer{
+		config: config,
+		queue:  listener,
+	}
+}
+
+// PartitionRange is the offsets of a partition replayed, From included and To excluded
+type PartitionRange struct {
+	Topic     string `json:"topic"`
+	Partition int32  `json:"partition"`
+	From      int64  `json:"from"`
+	To        int64  `json:"to"`
+}
+
+// ReplayPlan is the messages to replay, from the first one at or after Since up to the last one received when the plan was made
+type ReplayPlan struct {
+	Since      time.Time
+	Partitions []PartitionRange
+	Count      int
+	consumer   sarama.Consumer
+	closer     func() error
+	replayer   *Replayer
+}
+
+// PlanReplay connects a new consumer and resolves the offsets of the messages received since the given time
+func (r *Replayer) PlanReplay(since time.Time) (*ReplayPlan, error) {
+	config, err := r.config.SaramaConfig()
+	if err != nil {
+		return nil, err
+	}
+	client, err := sarama.NewClient(r.config.Brokers, config)
+	if err != nil {
+		return nil, err
+	}
+	consumer, err := sarama.NewConsumerFromClient(client)
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+	plan, err := r.plan(client, consumer, since)
+	if err != nil {
+		consumer.Close()
+		client.Close()
+		return nil, err
+	}
+	plan.closer = func() error {
+		consumer.Close()
+		return client.Close()
+	}
+	return plan, nil
+}
+
+func (r *Replayer) plan(resolver offsetResolver, consumer sarama.Consumer, since time.Time) (*ReplayPlan, error) {
+	plan := &ReplayPlan{Since: since, consumer: consumer, replayer: r}
+	for _, topic := range r.config.Topics {
+		partitions, err := resolver.Partitions(topic)
+		if err != nil {
+			return nil, fmt.Errorf("Failed to read partitions of %v: %v", topic, err)
+		}
+		for _, partition := range partitions {
+			to, err := resolver.GetOffset(topic, partition, sarama.OffsetNewest)
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read newest offset of %v/%v: %v", topic, partition, err)
+			}
+			// A partition without messages since the timestamp resolves to the newest offset
+			from, err := resolver.GetOffset(topic, partition, since.UnixNano()/int64(time.Millisecond))
+			if err != nil {
+				return nil, fmt.Errorf("Failed to read offset of %v/%v at %v: %v", topic, partition, since.Format(time.RFC3339), err)
+			}
+			if from < 0 || from >= to {
+				continue
+			}
+			plan.Partitions = append(plan.Partitions, PartitionRange{Topic: topic, Partition: partition, From: from, To: to})
+			plan.Count += int(to - from)
+		}
+	}
+	return plan, nil
+}
+
+// Run hands the messages of the plan over to the INCREMENTAL export, partitions in parallel and each partition in order,
+// and waits for their notifications to be handled. ready is called before handing over each message and returns false
+// once the replay is cancelled. done reports the outcome of each message, messages skipped by the mapper with an empty stub
+func (p *ReplayPlan) Run(ctx context.Context, ready func() bool, done func(tid string, doc content.Stub, err error)) error {
+	if p.closer != nil {
+		defer p.closer()
+	}
+	var wg, handled sync.WaitGroup
+	errs := make(chan error, len(p.Partitions))
+	for _, partition := range p.Partitions {
+		wg.Add(1)
+		go func(partition PartitionRange) {
+			defer wg.Done()
+			if err := p.replayPartition(ctx, partition, ready, done, &handled); err != nil {
+				errs <- err
+			}
+		}(partition)
+	}
+	wg.Wait()
+	close(errs)
+	allHandled := make(chan struct{})
+	go func() {
+		handled.Wait()
+		close(allHandled)
+	}()
+	select {
+	case <-allHandled:
+	case <-ctx.Done():
+	}
+	return <-errs
+}
+
+func (p *ReplayPlan) replayPartition(ctx context.Context, partition PartitionRange, ready func() bool, done func(tid string, doc content.Stub, err error), handled *sync.WaitGroup) error {
+	pc, err := p.consumer.ConsumePartition(partition.Topic, partition.Partition, partition.From)
+	if err == sarama.ErrOffsetOutOfRange {
+		// The first messages of the range have expired meanwhile, the replay starts from the oldest one left
+		log.Warnf("Messages of %v/%v from offset %v have expired, replaying from the oldest one", partition.Topic, partition.Partition, partition.From)
+		pc, err = p.consumer.ConsumePartition(partition.Topic, partition.Partition, sarama.OffsetOldest)
+	}
+	if err != nil {
+		return fmt.Errorf("Failed to consume %v/%v: %v", partition.Topic, partition.Partition, err)
+	}
+	defer pc.Close()
+	for {
+		select {
+		case message := <-pc.Messages():
+			if message.Offset >= partition.To {
+				return nil
+			}
+			msg := parseFTMessage(message.Value)
+			tid := msg.Headers["X-Request-Id"]
+			notifications, err := mapNotifications(p.replayer.queue.mapperOf(partition.Topic), msg)
+			if len(notifications) == 0 {
+				if err != nil {
+					log.WithField("transaction_id", tid).WithError(err).Info("Replayed message skipped")
+				}
+				done(tid, content.Stub{}, nil)
+			} else if !ready() {
+				return nil
+			} else if err := p.replayNotifications(partition.Topic, msg, notifications, done, handled); err != nil {
+				return err
+			}
+			// Compaction may have removed the last offsets of the range, the partition is exhausted once its high-water mark is reached
+			if message.Offset >= partition.To-1 || message.Offset+1 >= pc.HighWaterMarkOffset() {
+				return nil
+			}
+		case err := <-pc.Errors():
+			return fmt.Errorf("Failed to consume %v/%v: %v", partition.Topic, partition.Partition, err.Err)
+		case <-time.After(replayIdleTimeout):
+			log.Infof("No message of %v/%v before offset %v left to replay", partition.Topic, partition.Partition, partition.To)
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+	}
+}
+
+// replayNotifications hands the notifications of a message over to the INCREMENTAL export, reporting a single outcome
+// for the message once they are handled: the first failure if any
+func (p *ReplayPlan) replayNotifications(topic string, msg kafka.FTMessage, notifications []*Notification, done func(tid string, doc content.Stub, err error), handled *sync.WaitGroup) error {
+	tid := msg.Headers["X-Request-Id"]
+	var lock sync.Mutex
+	remaining := len(notifications)
+	doc := notifications[0].Stub
+	var failure error
+	handled.Add(1)
+	for _, n := range notifications {
+		stub := n.Stub
+		ack := func(err error) {
+			lock.Lock()
+			defer lock.Unlock()
+			if err != nil && failure == nil {
+				doc, failure = stub, err
+			}
+			if remaining--; remaining == 0 {
+				done(tid, doc, failure)
+				handled.Done()
+			}
+		}
+		if err := p.replayer.queue.enqueue(topic, msg, []*Notification{n}, ack); err != nil {
+			// The notifications not handed over are never acked, the message is given up
+			handled.Done()
+			return err
+		}
+	}
+	return nil
+}
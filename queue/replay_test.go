@@ -0,0 +1,170 @@
+package queue
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/kafka-client-go/kafka"
+	"github.com/Shopify/sarama"
+	"github.com/Shopify/sarama/mocks"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type fakeOffsetResolver struct {
+	partitions map[string][]int32
+	newest     map[int32]int64
+	since      map[int32]int64
+}
+
+func (r *fakeOffsetResolver) Partitions(topic string) ([]int32, error) {
+	return r.partitions[topic], nil
+}
+
+func (r *fakeOffsetResolver) GetOffset(topic string, partition int32, time int64) (int64, error) {
+	if time == sarama.OffsetNewest {
+		return r.newest[partition], nil
+	}
+	return r.since[partition], nil
+}
+
+type replayOutcomes struct {
+	sync.Mutex
+	tids  []string
+	uuids []string
+}
+
+func (o *replayOutcomes) done(tid string, doc content.Stub, err error) {
+	o.Lock()
+	defer o.Unlock()
+	o.tids = append(o.tids, tid)
+	o.uuids = append(o.uuids, doc.Uuid)
+}
+
+func yield(pc *mocks.PartitionConsumer, tid, body string) {
+	msg := kafka.NewFTMessage(map[string]string{"X-Request-Id": tid}, body)
+	pc.YieldMessage(&sarama.ConsumerMessage{Value: []byte(msg.Build())})
+}
+
+func TestReplayerPlansPartitionsWithMessagesSinceTimestamp(t *testing.T) {
+	listener, _ := newTestListener(0)
+	replayer := NewReplayer(GroupConsumerConfig{Topics: []string{"topic"}}, listener)
+	resolver := &fakeOffsetResolver{
+		partitions: map[string][]int32{"topic": {0, 1, 2}},
+		newest:     map[int32]int64{0: 100, 1: 50, 2: 10},
+		since:      map[int32]int64{0: 90, 1: 50, 2: -1},
+	}
+
+	plan, err := replayer.plan(resolver, nil, time.Now().Add(-time.Hour))
+	require.NoError(t, err)
+	assert.Equal(t, []PartitionRange{{Topic: "topic", Partition: 0, From: 90, To: 100}}, plan.Partitions)
+	assert.Equal(t, 10, plan.Count)
+}
+
+func TestReplayPlanHandlesMessagesOfEachPartitionInOrder(t *testing.T) {
+	listener, handler := newTestListener(time.Minute)
+	go listener.handleNotifications()
+	defer listener.cancel()
+	replayer := NewReplayer(GroupConsumerConfig{Topics: []string{"topic"}}, listener)
+	consumer := mocks.NewConsumer(t, nil)
+	pc := consumer.ExpectConsumePartition("topic", 0, 1)
+	yield(pc, "tid_1", "uuid1 UPDATE")
+	yield(pc, "tid_2", "skipped")
+	yield(pc, "tid_3", "uuid1 DELETE")
+	yield(pc, "tid_4", "uuid2 UPDATE")
+	plan := &ReplayPlan{
+		Partitions: []PartitionRange{{Topic: "topic", Partition: 0, From: 1, To: 4}},
+		Count:      3,
+		consumer:   consumer,
+		replayer:   replayer,
+	}
+
+	outcomes := &replayOutcomes{}
+	err := plan.Run(context.Background(), func() bool { return true }, outcomes.done)
+	require.NoError(t, err)
+
+	assert.ElementsMatch(t, []string{"tid_1", "tid_2", "tid_3"}, outcomes.tids)
+	assert.ElementsMatch(t, []string{"uuid1", "", "uuid1"}, outcomes.uuids)
+	handled := handler.Handled()
+	require.Len(t, handled, 1)
+	assert.Equal(t, DELETE, handled[0].EvType)
+	assert.Equal(t, "tid_3", handled[0].Tid)
+}
+
+func TestReplayPlanStopsWhenNotReady(t *testing.T) {
+	listener, handler := newTestListener(0)
+	go listener.handleNotifications()
+	defer listener.cancel()
+	replayer := NewReplayer(GroupConsumerConfig{Topics: []string{"topic"}}, listener)
+	consumer := mocks.NewConsumer(t, nil)
+	pc := consumer.ExpectConsumePartition("topic", 0, 1)
+	yield(pc, "tid_1", "uuid1 UPDATE")
+	plan := &ReplayPlan{
+		Partitions: []PartitionRange{{Topic: "topic", Partition: 0, From: 1, To: 10}},
+		consumer:   consumer,
+		replayer:   replayer,
+	}
+
+	outcomes := &replayOutcomes{}
+	err := plan.Run(context.Background(), func() bool { return false }, outcomes.done)
+	require.NoError(t, err)
+	assert.Empty(t, outcomes.tids)
+	assert.Empty(t, handler.Handled())
+}
+
+func TestReplayPlanCoalescesNotificationsWithPendingOnes(t *testing.T) {
+	listener, handler := newTestListener(200 * time.Millisecond)
+	go listener.handleNotifications()
+	defer listener.cancel()
+	require.NoError(t, listener.HandleMessage(message("tid_1", "uuid1 UPDATE")))
+	replayer := NewReplayer(GroupConsumerConfig{Topics: []string{"topic"}}, listener)
+	consumer := mocks.NewConsumer(t, nil)
+	pc := consumer.ExpectConsumePartition("topic", 0, 1)
+	yield(pc, "tid_2", "uuid1 UPDATE")
+	plan := &ReplayPlan{
+		Partitions: []PartitionRange{{Topic: "topic", Partition: 0, From: 1, To: 2}},
+		Count:      1,
+		consumer:   consumer,
+		replayer:   replayer,
+	}
+
+	outcomes := &replayOutcomes{}
+	require.NoError(t, plan.Run(context.Background(), func() bool { return true }, outcomes.done))
+
+	assert.Equal(t, []string{"tid_2"}, outcomes.tids)
+	handled := handler.Handled()
+	require.Len(t, handled, 1)
+	assert.Equal(t, "tid_2", handled[0].Tid)
+}
+
+func TestReplayPlanStopsAtHighWaterMarkOfCompactedPartition(t *testing.T) {
+	listener, handler := newTestListener(0)
+	go listener.handleNotifications()
+	defer listener.cancel()
+	replayer := NewReplayer(GroupConsumerConfig{Topics: []string{"topic"}}, listener)
+	consumer := mocks.NewConsumer(t, nil)
+	pc := consumer.ExpectConsumePartition("topic", 0, 1)
+	yield(pc, "tid_1", "uuid1 UPDATE")
+	yield(pc, "tid_2", "uuid2 UPDATE")
+	plan := &ReplayPlan{
+		Partitions: []PartitionRange{{Topic: "topic", Partition: 0, From: 1, To: 10}},
+		Count:      9,
+		consumer:   consumer,
+		replayer:   replayer,
+	}
+
+	finished := make(chan error)
+	go func() {
+		finished <- plan.Run(context.Background(), func() bool { return true }, (&replayOutcomes{}).done)
+	}()
+	select {
+	case err := <-finished:
+		require.NoError(t, err)
+	case <-time.After(2 * time.Second):
+		t.Fatal("Replay did not stop at the high-water mark")
+	}
+	assert.Len(t, handler.Handled(), 2)
+}
@@ -0,0 +1,96 @@
+package queue
+
+import (
+	"container/heap"
+	"hash/fnv"
+)
+
+// shard holds the pending notifications of a share of the UUIDs, ordered by due time. A notification waiting
+// behind an earlier one of the same UUID is only added once the earlier one has started
+type shard struct {
+	due  dueHeap
+	wake chan struct{}
+}
+
+func newShard() *shard {
+	return &shard{wake: make(chan struct{}, 1)}
+}
+
+// push adds the pending notification and wakes up the shard, which may have to handle it before its current head
+func (s *shard) push(p *pendingNotification) {
+	heap.Push(&s.due, p)
+	s.notify()
+}
+
+// fix reorders the pending notification after its due time changed
+func (s *shard) fix(p *pendingNotification) {
+	if p.index < 0 {
+		return
+	}
+	heap.Fix(&s.due, p.index)
+	s.notify()
+}
+
+func (s *shard) notify() {
+	select {
+	case s.wake <- struct{}{}:
+	default:
+	}
+}
+
+// head returns the pending notification due first, if any
+func (s *shard) head() *pendingNotification {
+	if len(s.due) == 0 {
+		return nil
+	}
+	return s.due[0]
+}
+
+// pop removes the head, adding the notification of the same UUID waiting behind it
+func (s *shard) pop() *pendingNotification {
+	p := heap.Pop(&s.due).(*pendingNotification)
+	if p.next != nil {
+		heap.Push(&s.due, p.next)
+		p.next = nil
+	}
+	return p
+}
+
+func shardOf(uuid string, shards int) int {
+	hash := fnv.New32a()
+	hash.Write([]byte(uuid))
+	return int(hash.Sum32() % uint32(shards))
+}
+
+// dueHeap orders the pending notifications by due time, then by arrival
+type dueHeap []*pendingNotification
+
+func (d dueHeap) Len() int { return len(d) }
+
+func (d dueHeap) Less(i, j int) bool {
+	if d[i].due.Equal(d[j].due) {
+		return d[i].received.Before(d[j].received)
+	}
+	return d[i].due.Before(d[j].due)
+}
+
+func (d dueHeap) Swap(i, j int) {
+	d[i], d[j] = d[j], d[i]
+	d[i].index = i
+	d[j].index = j
+}
+
+func (d *dueHeap) Push(x interface{}) {
+	p := x.(*pendingNotification)
+	p.index = len(*d)
+	*d = append(*d, p)
+}
+
+func (d *dueHeap) Pop() interface{} {
+	old := *d
+	p := old[len(old)-1]
+	old[len(old)-1] = nil
+	p.index = -1
+	*d = old[:len(old)-1]
+	return p
+}
@@ -0,0 +1,82 @@
+package queue
+
+import (
+	"encoding/json"
+	"fmt"
+	"regexp"
+	"time"
+)
+
+// TopicConfig is how the messages of a topic are handled: the mapper understanding their format,
+// with its own whitelist, and the delay before UPDATE events are handled
+type TopicConfig struct {
+	Topic         string
+	MessageMapper MessageMapper
+	Delay         time.Duration
+}
+
+type topicSpec struct {
+	Topic     string `json:"topic"`
+	Format    string `json:"format"`
+	Whitelist string `json:"whitelist"`
+	Delay     int    `json:"delay"`
+}
+
+// ParseTopicConfigs reads topic configurations given as a json list like
+// [{"topic": "PostMetadataPublicationEvents", "format": "minimal", "whitelist": ".*", "delay": 30}], the delay in seconds
+func ParseTopicConfigs(spec string) ([]TopicConfig, error) {
+	if spec == "" {
+		return nil, nil
+	}
+	var specs []topicSpec
+	if err := json.Unmarshal([]byte(spec), &specs); err != nil {
+		return nil, fmt.Errorf("Invalid topic configurations: %v", err)
+	}
+	configs := make([]TopicConfig, 0, len(specs))
+	for _, s := range specs {
+		if s.Topic == "" {
+			return nil, fmt.Errorf("Invalid topic configurations: missing topic name")
+		}
+		if s.Delay < 0 {
+			return nil, fmt.Errorf("Invalid delay of topic %v: %v", s.Topic, s.Delay)
+		}
+		whitelistR, err := regexp.Compile(s.Whitelist)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid whitelist of topic %v: %v", s.Topic, err)
+		}
+		mapper, err := NewMessageMapper(s.Format, whitelistR)
+		if err != nil {
+			return nil, fmt.Errorf("Invalid format of topic %v: %w", s.Topic, err)
+		}
+		configs = append(configs, TopicConfig{Topic: s.Topic, MessageMapper: mapper, Delay: time.Duration(s.Delay) * time.Second})
+	}
+	return configs, nil
+}
+
+// TopicConfigs indexes the configurations by topic
+func TopicConfigs(configs ...TopicConfig) map[string]TopicConfig {
+	topics := make(map[string]TopicConfig, len(configs))
+	for _, c := range configs {
+		topics[c.Topic] = c
+	}
+	return topics
+}
+
+func mapperOf(topics map[string]TopicConfig, topic string, fallback MessageMapper) MessageMapper {
+	if c, ok := topics[topic]; ok && c.MessageMapper != nil {
+		return c.MessageMapper
+	}
+	return fallback
+}
+
+// mapperOf returns the mapper of the messages of the topic
+func (h *KafkaListener) mapperOf(topic string) MessageMapper {
+	return mapperOf(h.Topics, topic, h.MessageMapper)
+}
+
+func delayOf(topics map[string]TopicConfig, topic string, fallback time.Duration) time.Duration {
+	if c, ok := topics[topic]; ok {
+		return c.Delay
+	}
+	return fallback
+}
@@ -0,0 +1,51 @@
+package queue
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseTopicConfigs(t *testing.T) {
+	configs, err := ParseTopicConfigs(`[{"topic": "annotations", "format": "minimal", "delay": 60}, {"topic": "events", "format": "cloudevents", "whitelist": "^http://.*"}]`)
+	require.NoError(t, err)
+	require.Len(t, configs, 2)
+	assert.Equal(t, "annotations", configs[0].Topic)
+	assert.IsType(t, &MinimalMessageMapper{}, configs[0].MessageMapper)
+	assert.Equal(t, time.Minute, configs[0].Delay)
+	assert.Equal(t, "events", configs[1].Topic)
+	assert.IsType(t, &CloudEventsMapper{}, configs[1].MessageMapper)
+	assert.Equal(t, time.Duration(0), configs[1].Delay)
+}
+
+func TestParseTopicConfigsEmpty(t *testing.T) {
+	configs, err := ParseTopicConfigs("")
+	assert.NoError(t, err)
+	assert.Empty(t, configs)
+}
+
+func TestParseTopicConfigsInvalid(t *testing.T) {
+	for _, spec := range []string{
+		`not json`,
+		`[{"format": "minimal"}]`,
+		`[{"topic": "annotations", "delay": -1}]`,
+		`[{"topic": "annotations", "whitelist": "("}]`,
+	} {
+		_, err := ParseTopicConfigs(spec)
+		assert.Error(t, err, spec)
+	}
+	_, err := ParseTopicConfigs(`[{"topic": "annotations", "format": "unknown"}]`)
+	assert.True(t, errors.Is(err, ErrUnknownMessageFormat))
+}
+
+func TestTopicConfigsFallBackForOtherTopics(t *testing.T) {
+	topics := TopicConfigs(TopicConfig{Topic: "annotations", MessageMapper: NewMinimalMessageMapper(), Delay: time.Second})
+	fallback := bodyMapper{}
+	assert.IsType(t, &MinimalMessageMapper{}, mapperOf(topics, "annotations", fallback))
+	assert.Equal(t, fallback, mapperOf(topics, "content", fallback))
+	assert.Equal(t, time.Second, delayOf(topics, "annotations", time.Minute))
+	assert.Equal(t, time.Minute, delayOf(topics, "", time.Minute))
+}
@@ -0,0 +1,224 @@
+package web
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/Financial-Times/content-exporter/content"
+	"github.com/Financial-Times/content-exporter/export"
+	"github.com/Financial-Times/content-exporter/queue"
+	"github.com/Financial-Times/transactionid-utils-go"
+	"github.com/gorilla/mux"
+	"github.com/pborman/uuid"
+	log "github.com/sirupsen/logrus"
+)
+
+// ConcurrencyAdjuster is a pipeline whose concurrency can be changed while it runs
+type ConcurrencyAdjuster interface {
+	Concurrency() int
+	SetConcurrency(n int)
+}
+
+// DeadLetterQueue holds the INCREMENTAL export notifications whose handling failed
+type DeadLetterQueue interface {
+	ListDeadLetters() ([]queue.DeadLetter, error)
+	ReplayDeadLetter(id string) error
+	DiscardDeadLetter(id string) error
+}
+
+// NotificationReplayer plans the replay of the INCREMENTAL export notifications received since a point in time
+type NotificationReplayer interface {
+	PlanReplay(since time.Time) (*queue.ReplayPlan, error)
+}
+
+type AdminHandler struct {
+	RateLimiters map[string]*content.RateLimiter
+	Incremental  ConcurrencyAdjuster
+	DeadLetters  DeadLetterQueue
+	Replayer     NotificationReplayer
+	Jobs         *export.Service
+}
+
+func NewAdminHandler(rateLimiters map[string]*content.RateLimiter, incremental ConcurrencyAdjuster, deadLetters DeadLetterQueue, replayer NotificationReplayer, jobs *export.Service) *AdminHandler {
+	return &AdminHandler{RateLimiters: rateLimiters, Incremental: incremental, DeadLetters: deadLetters, Replayer: replayer, Jobs: jobs}
+}
+
+type rateLimit struct {
+	Rate        int     `json:"rate"`
+	CurrentRate float64 `json:"currentRate"`
+}
+
+func (handler *AdminHandler) GetRateLimits(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+
+	writer.Header().Add("Content-Type", "application/json")
+
+	limits := make(map[string]rateLimit)
+	for upstream, limiter := range handler.RateLimiters {
+		maxRate, currentRate := limiter.Rate()
+		limits[upstream] = rateLimit{Rate: int(maxRate), CurrentRate: currentRate}
+	}
+	if err := json.NewEncoder(writer).Encode(limits); err != nil {
+		log.Warnf(`Failed to write rate limits to response writer: "%v"`, err)
+	}
+}
+
+// SetRateLimit changes the rate of calls per second allowed to an upstream. Zero removes the limit
+func (handler *AdminHandler) SetRateLimit(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+
+	upstream := mux.Vars(request)["upstream"]
+	limiter, ok := handler.RateLimiters[upstream]
+	if !ok {
+		http.Error(writer, fmt.Sprintf("Unknown upstream: %v", upstream), http.StatusNotFound)
+		return
+	}
+
+	var limit rateLimit
+	if err := json.NewDecoder(request.Body).Decode(&limit); err != nil || limit.Rate < 0 {
+		http.Error(writer, "Invalid rate limit. Expected a json body like {\"rate\": 50}", http.StatusBadRequest)
+		return
+	}
+	limiter.SetRate(limit.Rate)
+	log.Infof("Rate limit of %v set to %v call(s) per second", upstream, limit.Rate)
+	writer.WriteHeader(http.StatusOK)
+}
+
+type concurrency struct {
+	MaxGoRoutines int `json:"maxGoRoutines"`
+}
+
+func (handler *AdminHandler) GetIncremental(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+
+	if handler.Incremental == nil {
+		http.Error(writer, "INCREMENTAL export is not enabled", http.StatusNotFound)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(concurrency{MaxGoRoutines: handler.Incremental.Concurrency()}); err != nil {
+		log.Warnf(`Failed to write incremental export settings to response writer: "%v"`, err)
+	}
+}
+
+// AdjustIncremental changes the number of notifications the INCREMENTAL export handles at once
+func (handler *AdminHandler) AdjustIncremental(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+
+	if handler.Incremental == nil {
+		http.Error(writer, "INCREMENTAL export is not enabled", http.StatusNotFound)
+		return
+	}
+	var c concurrency
+	if err := json.NewDecoder(request.Body).Decode(&c); err != nil || c.MaxGoRoutines < 1 {
+		http.Error(writer, "Invalid concurrency. Expected a json body like {\"maxGoRoutines\": 100}", http.StatusBadRequest)
+		return
+	}
+	handler.Incremental.SetConcurrency(c.MaxGoRoutines)
+	writer.WriteHeader(http.StatusOK)
+}
+
+func (handler *AdminHandler) GetDeadLetters(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+
+	if handler.DeadLetters == nil {
+		http.Error(writer, "INCREMENTAL export is not enabled", http.StatusNotFound)
+		return
+	}
+	letters, err := handler.DeadLetters.ListDeadLetters()
+	if err != nil {
+		msg := fmt.Sprintf("Failed to read dead letters: %v", err)
+		log.Error(msg)
+		http.Error(writer, msg, http.StatusInternalServerError)
+		return
+	}
+	writer.Header().Add("Content-Type", "application/json")
+	if err := json.NewEncoder(writer).Encode(letters); err != nil {
+		log.Warnf(`Failed to write dead letters to response writer: "%v"`, err)
+	}
+}
+
+// ReplayDeadLetter hands the content of the dead letter to the INCREMENTAL export again
+func (handler *AdminHandler) ReplayDeadLetter(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+	handler.handleDeadLetter(writer, request, "replayed", func(id string) error {
+		return handler.DeadLetters.ReplayDeadLetter(id)
+	})
+}
+
+func (handler *AdminHandler) DiscardDeadLetter(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+	handler.handleDeadLetter(writer, request, "discarded", func(id string) error {
+		return handler.DeadLetters.DiscardDeadLetter(id)
+	})
+}
+
+func (handler *AdminHandler) handleDeadLetter(writer http.ResponseWriter, request *http.Request, action string, handle func(id string) error) {
+	if handler.DeadLetters == nil {
+		http.Error(writer, "INCREMENTAL export is not enabled", http.StatusNotFound)
+		return
+	}
+	id := mux.Vars(request)["id"]
+	if err := handle(id); err != nil {
+		status := http.StatusInternalServerError
+		if err == queue.ErrDeadLetterNotFound {
+			status = http.StatusNotFound
+		}
+		msg := fmt.Sprintf("Dead letter %v could not be %v: %v", id, action, err)
+		log.Info(msg)
+		http.Error(writer, msg, status)
+		return
+	}
+	log.Infof("Dead letter %v %v", id, action)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+type replayRequest struct {
+	Since time.Time `json:"since"`
+}
+
+// Replay starts a job handling again the notifications received on the topic since the given time
+func (handler *AdminHandler) Replay(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+
+	tid := transactionidutils.GetTransactionIDFromRequest(request)
+
+	if handler.Replayer == nil {
+		http.Error(writer, "INCREMENTAL export is not enabled", http.StatusNotFound)
+		return
+	}
+	var replayReq replayRequest
+	if err := json.NewDecoder(request.Body).Decode(&replayReq); err != nil || replayReq.Since.IsZero() || replayReq.Since.After(time.Now()) {
+		http.Error(writer, "Invalid replay request. Expected a json body like {\"since\": \"2020-01-30T10:00:00Z\"}", http.StatusBadRequest)
+		return
+	}
+	if len(handler.Jobs.GetRunningJobs()) > 0 {
+		http.Error(writer, "There are already running export jobs. Please wait them to finish", http.StatusBadRequest)
+		return
+	}
+
+	plan, err := handler.Replayer.PlanReplay(replayReq.Since)
+	if err != nil {
+		msg := fmt.Sprintf("Failed to plan replay since %v: %v", replayReq.Since.Format(time.RFC3339), err)
+		log.WithField("transaction_id", tid).Error(msg)
+		http.Error(writer, msg, http.StatusServiceUnavailable)
+		return
+	}
+	job := &export.Job{
+		ID:          uuid.New(),
+		Status:      export.STARTING,
+		Count:       plan.Count,
+		ReplaySince: replayReq.Since.Format(time.RFC3339),
+	}
+	ctx := handler.Jobs.AddJob(job)
+	log.WithField("transaction_id", tid).Infof("Replaying %v notification(s) since %v in job %v", plan.Count, job.ReplaySince, job.ID)
+	go job.RunReplay(ctx, plan.Run)
+
+	writer.Header().Add("Content-Type", "application/json")
+	writer.WriteHeader(http.StatusAccepted)
+	if err := json.NewEncoder(writer).Encode(job.Copy()); err != nil {
+		log.Warnf(`Failed to write job %v to response writer: "%v"`, job.ID, err)
+	}
+}
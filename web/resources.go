@@ -2,6 +2,7 @@ package web
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -22,18 +23,32 @@ type RequestHandler struct {
 	ContentRetrievalThrottle int
 	*export.Locker
 	IsIncExportEnabled bool
+	Transformations    map[string]content.TransformerChain
+	Sources            map[string]content.Fetcher
+	AllowedXPolicies   []string
 }
 
-func NewRequestHandler(fullExporter *export.Service, inquirer content.Inquirer, locker *export.Locker, isIncExportEnabled bool, contentRetrievalThrottle int) *RequestHandler {
+func NewRequestHandler(fullExporter *export.Service, inquirer content.Inquirer, locker *export.Locker, isIncExportEnabled bool, contentRetrievalThrottle int, transformations map[string]content.TransformerChain, sources map[string]content.Fetcher, allowedXPolicies []string) *RequestHandler {
 	return &RequestHandler{
 		FullExporter:             fullExporter,
 		Inquirer:                 inquirer,
 		Locker:                   locker,
 		IsIncExportEnabled:       isIncExportEnabled,
 		ContentRetrievalThrottle: contentRetrievalThrottle,
+		Transformations:          transformations,
+		Sources:                  sources,
+		AllowedXPolicies:         allowedXPolicies,
 	}
 }
 
+type exportRequest struct {
+	candidates     []string
+	transformation string
+	source         string
+	xPolicies      []string
+	encoding       string
+}
+
 func (handler *RequestHandler) Export(writer http.ResponseWriter, request *http.Request) {
 	defer request.Body.Close()
 
@@ -45,6 +60,51 @@ func (handler *RequestHandler) Export(writer http.ResponseWriter, request *http.
 		return
 	}
 
+	exportReq := getExportRequest(request)
+	exporter := handler.FullExporter.Exporter
+	if exportReq.transformation != "" {
+		transformer, ok := handler.Transformations[exportReq.transformation]
+		if !ok {
+			http.Error(writer, fmt.Sprintf("Unknown transformation: %v", exportReq.transformation), http.StatusBadRequest)
+			return
+		}
+		exporter = exporter.WithTransformer(transformer)
+	}
+	if exportReq.source != "" {
+		fetcher, ok := handler.Sources[exportReq.source]
+		if !ok {
+			http.Error(writer, fmt.Sprintf("Unknown source: %v", exportReq.source), http.StatusBadRequest)
+			return
+		}
+		exporter = exporter.WithFetcher(fetcher)
+	}
+	xPolicies := strings.Join(exportReq.xPolicies, ",")
+	if len(exportReq.xPolicies) != 0 {
+		for _, xPolicy := range exportReq.xPolicies {
+			if !handler.isAllowedXPolicy(xPolicy) {
+				http.Error(writer, fmt.Sprintf("X-Policy not allowed: %v", xPolicy), http.StatusBadRequest)
+				return
+			}
+		}
+		var err error
+		if exporter, err = exporter.WithXPolicies(xPolicies); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
+	if exportReq.encoding != "" {
+		encoding, err := content.ParseEncoding(exportReq.encoding)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		if exporter, err = exporter.WithEncoding(encoding); err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+	}
+
 	if handler.IsIncExportEnabled {
 		select {
 		case handler.Locker.Locked <- true:
@@ -66,11 +126,24 @@ func (handler *RequestHandler) Export(writer http.ResponseWriter, request *http.
 			return
 		}
 	}
-	candidates := getCandidateUUIDs(request)
-
+	batchSize := handler.FullExporter.BatchSize
+	if !exporter.SupportsBatches() {
+		// Batching a source without batch calls would only serialise the fetches of a worker
+		batchSize = 1
+	}
 	jobID := uuid.New()
-	job := &export.Job{ID: jobID, NrWorker: handler.FullExporter.NrOfConcurrentWorkers, Status: export.STARTING, ContentRetrievalThrottle: handler.ContentRetrievalThrottle}
-	handler.FullExporter.AddJob(job)
+	job := &export.Job{
+		ID:                       jobID,
+		NrWorker:                 handler.FullExporter.NrOfConcurrentWorkers,
+		BatchSize:                batchSize,
+		Status:                   export.STARTING,
+		ContentRetrievalThrottle: handler.ContentRetrievalThrottle,
+		ContentEncoding:          string(content.UpdaterEncoding(exporter.Updater)),
+		Transformation:           exportReq.transformation,
+		Source:                   exportReq.source,
+		XPolicies:                xPolicies,
+	}
+	ctx := handler.FullExporter.AddJob(job)
 
 	go func() {
 		if handler.IsIncExportEnabled {
@@ -80,19 +153,21 @@ func (handler *RequestHandler) Export(writer http.ResponseWriter, request *http.
 			}()
 		}
 		log.Infoln("Calling mongo")
-		docs, err, count := handler.Inquirer.Inquire("content", candidates)
+		docs, err, count := handler.Inquirer.Inquire(ctx, "content", exportReq.candidates)
 		if err != nil {
 			msg := fmt.Sprintf(`Failed to read IDs from mongo for %v! "%v"`, "content", err.Error())
 			log.Info(msg)
 			job.ErrorMessage = msg
 			job.Status = export.FINISHED
+			job.Release()
 			return
 		}
 		log.Infof("Nr of UUIDs found: %v", count)
 		job.DocIds = docs
 		job.Count = count
 
-		job.RunFullExport(tid, handler.FullExporter.HandleContent)
+		job.RunFullExport(ctx, tid, exporter.HandleContents)
+		job.WriteManifest(tid, exporter.Updater)
 	}()
 
 	writer.WriteHeader(http.StatusAccepted)
@@ -107,7 +182,7 @@ func (handler *RequestHandler) Export(writer http.ResponseWriter, request *http.
 	}
 }
 
-func getCandidateUUIDs(request *http.Request) (candidates []string) {
+func getExportRequest(request *http.Request) (exportReq exportRequest) {
 	var result map[string]interface{}
 	body, err := ioutil.ReadAll(request.Body)
 	if err != nil {
@@ -120,6 +195,33 @@ func getCandidateUUIDs(request *http.Request) (candidates []string) {
 		return
 	}
 	log.Infof("DEBUG Parsing request body: %v", result)
+	exportReq.candidates = getCandidateUUIDs(result)
+	if transformation, ok := result["transformation"].(string); ok {
+		exportReq.transformation = transformation
+	}
+	if source, ok := result["source"].(string); ok {
+		exportReq.source = source
+	}
+	if xPolicies, ok := result["xPolicies"].(string); ok {
+		exportReq.xPolicies = content.ParseXPolicies(xPolicies)
+	}
+	if encoding, ok := result["contentEncoding"].(string); ok {
+		exportReq.encoding = encoding
+	}
+
+	return
+}
+
+func (handler *RequestHandler) isAllowedXPolicy(xPolicy string) bool {
+	for _, allowed := range handler.AllowedXPolicies {
+		if xPolicy == allowed {
+			return true
+		}
+	}
+	return false
+}
+
+func getCandidateUUIDs(result map[string]interface{}) (candidates []string) {
 	ids, ok := result["ids"]
 	if !ok {
 		log.Infof("No ids field found in json body, thus no candidate ids to export.")
@@ -151,7 +253,62 @@ func (handler *RequestHandler) GetJob(writer http.ResponseWriter, request *http.
 		return
 	}
 
-	err = json.NewEncoder(writer).Encode(job)
+	err = json.NewEncoder(writer).Encode(&job)
+	if err != nil {
+		msg := fmt.Sprintf(`Failed to write job %v to response writer: "%v"`, job.ID, err)
+		log.Warn(msg)
+		fmt.Fprintf(writer, "{\"ID\": \"%v\"}", job.ID)
+		return
+	}
+}
+
+func (handler *RequestHandler) CancelJob(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+
+	vars := mux.Vars(request)
+	jobID := vars["jobID"]
+
+	if err := handler.FullExporter.CancelJob(jobID); err != nil {
+		msg := fmt.Sprintf(`{"message":"%v"}`, err)
+		log.Info(msg)
+		writer.Header().Add("Content-Type", "application/json")
+		http.Error(writer, msg, jobErrorStatus(err))
+		return
+	}
+	log.Infof("Job %v cancelled", jobID)
+	writer.WriteHeader(http.StatusAccepted)
+}
+
+type jobAdjustment struct {
+	NrWorker                 *int `json:"NrWorker"`
+	ContentRetrievalThrottle *int `json:"ContentRetrievalThrottle"`
+}
+
+// AdjustJob changes the number of workers and the throttle of a running job
+func (handler *RequestHandler) AdjustJob(writer http.ResponseWriter, request *http.Request) {
+	defer request.Body.Close()
+
+	vars := mux.Vars(request)
+	jobID := vars["jobID"]
+
+	writer.Header().Add("Content-Type", "application/json")
+
+	var adjustment jobAdjustment
+	err := json.NewDecoder(request.Body).Decode(&adjustment)
+	if err != nil || (adjustment.NrWorker != nil && *adjustment.NrWorker < 1) || (adjustment.ContentRetrievalThrottle != nil && *adjustment.ContentRetrievalThrottle < 0) {
+		http.Error(writer, `{"message":"Invalid adjustment. Expected a json body like {\"NrWorker\": 10, \"ContentRetrievalThrottle\": 100}"}`, http.StatusBadRequest)
+		return
+	}
+
+	job, err := handler.FullExporter.AdjustJob(jobID, adjustment.NrWorker, adjustment.ContentRetrievalThrottle)
+	if err != nil {
+		msg := fmt.Sprintf(`{"message":"%v"}`, err)
+		log.Info(msg)
+		http.Error(writer, msg, jobErrorStatus(err))
+		return
+	}
+
+	err = json.NewEncoder(writer).Encode(&job)
 	if err != nil {
 		msg := fmt.Sprintf(`Failed to write job %v to response writer: "%v"`, job.ID, err)
 		log.Warn(msg)
@@ -160,6 +317,14 @@ func (handler *RequestHandler) GetJob(writer http.ResponseWriter, request *http.
 	}
 }
 
+// jobErrorStatus is 404 for unknown jobs and 409 for jobs which are not running anymore
+func jobErrorStatus(err error) int {
+	if errors.Is(err, export.ErrJobNotRunning) {
+		return http.StatusConflict
+	}
+	return http.StatusNotFound
+}
+
 func (handler *RequestHandler) GetRunningJobs(writer http.ResponseWriter, request *http.Request) {
 	defer request.Body.Close()
 